@@ -18,6 +18,7 @@ import (
 	"github.com/Doridian/wsvpn/shared/cli"
 	"github.com/Doridian/wsvpn/shared/features"
 	"github.com/Doridian/wsvpn/shared/iface"
+	"github.com/Doridian/wsvpn/shared/sockets"
 )
 
 func reloadConfig(configPtr *string, client *clients.Client) error {
@@ -103,8 +104,17 @@ func reloadConfig(configPtr *string, client *clients.Client) error {
 		client.ProxyURL = proxyURL
 	}
 
-	client.SocketConfigurator = &cli.PingFlagsSocketConfigurator{
-		Config: &config.Tunnel.Ping,
+	compressionConfigurator, err := cli.NewCompressionSocketConfigurator(&config.Tunnel.Compression)
+	if err != nil {
+		return err
+	}
+	client.SocketConfigurator = &sockets.MultiSocketConfigurator{
+		Configurators: []sockets.SocketConfigurator{
+			&cli.PingFlagsSocketConfigurator{
+				Config: &config.Tunnel.Ping,
+			},
+			compressionConfigurator,
+		},
 	}
 	for feat, en := range config.Tunnel.Features {
 		if !features.IsFeatureSupported(feat) {
@@ -114,6 +124,7 @@ func reloadConfig(configPtr *string, client *clients.Client) error {
 	}
 	client.FirewallMark = config.FirewallMark
 	client.SetDefaultGateway = config.Tunnel.SetDefaultGateway
+	client.ApplyDNS = config.Tunnel.ApplyDNS
 	client.ServerURL = dest
 	client.InterfaceConfig = &config.Interface
 	client.InterfaceConfig.OneInterfacePerConnection = false
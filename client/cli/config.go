@@ -18,9 +18,11 @@ var defaultConfig string
 
 type Config struct {
 	Tunnel struct {
-		SetDefaultGateway bool                  `yaml:"set-default-gateway"`
-		Ping              shared_cli.PingConfig `yaml:"ping"`
-		Features          features.Config       `yaml:"features"`
+		SetDefaultGateway bool                         `yaml:"set-default-gateway"`
+		ApplyDNS          bool                         `yaml:"apply-dns"`
+		Ping              shared_cli.PingConfig        `yaml:"ping"`
+		Features          features.Config              `yaml:"features"`
+		Compression       shared_cli.CompressionConfig `yaml:"compression"`
 	} `yaml:"tunnel"`
 
 	Interface    iface.InterfaceConfig `yaml:"interface"`
@@ -26,6 +26,7 @@ type Client struct {
 	Headers            http.Header
 	FirewallMark       int
 	SetDefaultGateway  bool
+	ApplyDNS           bool
 	SocketConfigurator sockets.SocketConfigurator
 	InterfaceConfig    *iface.InterfaceConfig
 	AutoReconnectDelay time.Duration
@@ -37,9 +38,11 @@ type Client struct {
 	doIPConfig bool
 	iface      *iface.WaterInterfaceWrapper
 	remoteNet  *shared.VPNNet
+	remoteNets []*shared.VPNNet
 	socket     *sockets.Socket
 	adapter    adapters.SocketAdapter
 	connectors map[string]connectors.SocketConnector
+	routes     map[string]*net.IPNet
 	dialer     *net.Dialer
 
 	sentUpEvent bool
@@ -53,6 +56,7 @@ func NewClient() *Client {
 		TLSConfig:     &tls.Config{},
 		log:           shared.MakeLogger("CLIENT", ""),
 		connectors:    make(map[string]connectors.SocketConnector),
+		routes:        make(map[string]*net.IPNet),
 		localFeatures: make(map[features.Feature]bool),
 	}
 }
@@ -121,6 +125,9 @@ func (c *Client) Serve() error {
 	}
 
 	c.socket = sockets.MakeSocket(c.log, c.adapter, nil, true, nil)
+	c.socket.SetMessageHandler(func(msgType string, message string) {
+		c.doRunEventScript(shared.EventMessage, msgType, message)
+	})
 	err = c.UpdateSocketConfig()
 	if err != nil {
 		return err
@@ -170,13 +177,19 @@ func (c *Client) closeInternal() {
 		_ = c.adapter.Close()
 		c.adapter = nil
 	}
+	c.removeAllRoutes()
+
 	if c.iface != nil {
+		err := c.iface.RestoreDNS()
+		if err != nil {
+			c.log.Printf("Error restoring DNS configuration: %v", err)
+		}
 		_ = c.iface.Close()
 		c.iface = nil
 	}
 }
 
-func (c *Client) doRunEventScript(event string) {
+func (c *Client) doRunEventScript(event string, args ...string) {
 	ifaceName := ""
 	if c.iface != nil {
 		ifaceName = c.iface.Interface.Name()
@@ -187,7 +200,7 @@ func (c *Client) doRunEventScript(event string) {
 	}
 
 	go func() {
-		err := c.RunEventScript(event, remoteNetStr, ifaceName)
+		err := c.RunEventScript(event, remoteNetStr, ifaceName, args...)
 		if err != nil {
 			c.log.Printf("Error running %s script: %v", event, err)
 		}
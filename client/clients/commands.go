@@ -2,7 +2,9 @@ package clients
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"strings"
 
 	"github.com/Doridian/water"
 	"github.com/Doridian/wsvpn/shared"
@@ -28,13 +30,37 @@ func (c *Client) registerCommandHandlers() {
 			return err
 		}
 
-		err = c.iface.AddIPRoute(routeNet, c.remoteNet.GetServerIP())
+		err = c.addRoute(routeNet)
 		if err != nil {
 			c.log.Printf("Error adding subnet route (not fatal): %v", err)
 		}
 		return nil
 	})
 
+	c.socket.AddCommandHandler(commands.RemoveRouteCommandName, func(command *commands.IncomingCommand) error {
+		var err error
+		var parameters commands.RemoveRouteParameters
+		err = command.DeserializeParameters(&parameters)
+		if err != nil {
+			return err
+		}
+
+		if c.iface == nil || c.remoteNet == nil {
+			return errors.New("cannot removeroute before init")
+		}
+
+		_, routeNet, err := net.ParseCIDR(parameters.Route)
+		if err != nil {
+			return err
+		}
+
+		err = c.removeRoute(routeNet)
+		if err != nil {
+			c.log.Printf("Error removing subnet route (not fatal): %v", err)
+		}
+		return nil
+	})
+
 	c.socket.AddCommandHandler(commands.InitCommandName, func(command *commands.IncomingCommand) error {
 		var err error
 		var parameters commands.InitParameters
@@ -55,16 +81,32 @@ func (c *Client) registerCommandHandlers() {
 
 		mode := shared.VPNModeFromString(parameters.Mode)
 
-		c.remoteNet, err = shared.ParseVPNNet(parameters.IPAddress)
-		if err != nil {
-			return err
+		// Older servers only send a single address in IPAddress
+		ipAddresses := parameters.IPAddresses
+		if len(ipAddresses) == 0 {
+			ipAddresses = []commands.IPAddressWithCIDR{parameters.IPAddress}
+		}
+
+		remoteNets := make([]*shared.VPNNet, 0, len(ipAddresses))
+		remoteNetStrs := make([]string, 0, len(ipAddresses))
+		assignedIPs := make([]net.IP, 0, len(ipAddresses))
+		for _, ipAddress := range ipAddresses {
+			remoteNet, err := shared.ParseVPNNet(ipAddress)
+			if err != nil {
+				return err
+			}
+			remoteNets = append(remoteNets, remoteNet)
+			remoteNetStrs = append(remoteNetStrs, remoteNet.GetRaw())
+			assignedIPs = append(assignedIPs, remoteNet.GetRawIP())
 		}
+		c.remoteNet = remoteNets[0]
+		c.remoteNets = remoteNets
 
 		c.doIPConfig = parameters.DoIPConfig
 
-		c.socket.AssignedIP = c.remoteNet.GetRawIP()
+		c.socket.AssignedIPs = assignedIPs
 
-		c.log.Printf("Network mode %s, Subnet %s, MTU %d, IPConfig %s", parameters.Mode, c.remoteNet.GetRaw(), parameters.MTU, shared.BoolToEnabled(c.doIPConfig))
+		c.log.Printf("Network mode %s, Subnet %s, MTU %d, IPConfig %s", parameters.Mode, strings.Join(remoteNetStrs, ", "), parameters.MTU, shared.BoolToEnabled(c.doIPConfig))
 
 		ifconfig := water.Config{
 			DeviceType: mode.ToWaterDeviceType(),
@@ -85,7 +127,12 @@ func (c *Client) registerCommandHandlers() {
 		c.log.Printf("Opened interface %s", c.iface.Interface.Name())
 
 		if c.doIPConfig {
-			err = c.iface.Configure(c.remoteNet.GetRawIP(), c.remoteNet, c.remoteNet.GetServerIP())
+			for _, remoteNet := range c.remoteNets {
+				err = c.iface.Configure(remoteNet.GetRawIP(), remoteNet, remoteNet.GetServerIP())
+				if err != nil {
+					break
+				}
+			}
 		} else {
 			err = c.iface.Configure(nil, nil, nil)
 		}
@@ -104,9 +151,15 @@ func (c *Client) registerCommandHandlers() {
 		}
 
 		if c.SetDefaultGateway {
-			err = c.iface.AddIPRoute(&net.IPNet{IP: net.IPv4(0, 0, 0, 0), Mask: net.IPv4Mask(0, 0, 0, 0)}, c.remoteNet.GetServerIP())
-			if err != nil {
-				c.log.Printf("Error adding default gateway route (not fatal): %v", err)
+			for _, remoteNet := range c.remoteNets {
+				defaultRoute := &net.IPNet{IP: net.IPv4(0, 0, 0, 0), Mask: net.IPv4Mask(0, 0, 0, 0)}
+				if !remoteNet.IsIPv4() {
+					defaultRoute = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+				}
+				err = c.addRoute(defaultRoute)
+				if err != nil {
+					c.log.Printf("Error adding default gateway route (not fatal): %v", err)
+				}
 			}
 		}
 
@@ -118,6 +171,49 @@ func (c *Client) registerCommandHandlers() {
 		return nil
 	})
 
+	c.socket.AddCommandHandler(commands.SetDNSCommandName, func(command *commands.IncomingCommand) error {
+		var err error
+		var parameters commands.SetDNSParameters
+		err = command.DeserializeParameters(&parameters)
+		if err != nil {
+			return err
+		}
+
+		if c.iface == nil {
+			return errors.New("cannot set DNS before init")
+		}
+
+		if !c.ApplyDNS {
+			c.log.Printf("Ignoring DNS configuration from server (nameservers %v, search domains %v)", parameters.Nameservers, parameters.SearchDomains)
+			return nil
+		}
+
+		nameservers := make([]net.IP, 0, len(parameters.Nameservers))
+		for _, nameserver := range parameters.Nameservers {
+			nameserverIP := net.ParseIP(nameserver)
+			if nameserverIP == nil {
+				return fmt.Errorf("invalid DNS nameserver: %s", nameserver)
+			}
+			nameservers = append(nameservers, nameserverIP)
+		}
+
+		if len(nameservers) == 0 && len(parameters.SearchDomains) == 0 {
+			c.log.Printf("Server cleared DNS configuration")
+			err = c.iface.RestoreDNS()
+			if err != nil {
+				c.log.Printf("Error restoring DNS configuration (not fatal): %v", err)
+			}
+			return nil
+		}
+
+		c.log.Printf("Applying DNS configuration from server (nameservers %v, search domains %v)", parameters.Nameservers, parameters.SearchDomains)
+		err = c.iface.SetDNS(nameservers, parameters.SearchDomains)
+		if err != nil {
+			c.log.Printf("Error applying DNS configuration (not fatal): %v", err)
+		}
+		return nil
+	})
+
 	c.socket.AddCommandHandler(commands.SetMTUCommandName, func(command *commands.IncomingCommand) error {
 		var err error
 		var parameters commands.SetMTUParameters
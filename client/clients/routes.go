@@ -0,0 +1,64 @@
+package clients
+
+import (
+	"fmt"
+	"net"
+)
+
+// getRouteGateway picks the server's IP of the same IP family as the route
+func (c *Client) getRouteGateway(routeNet *net.IPNet) (net.IP, error) {
+	routeIsIPv4 := routeNet.IP.To4() != nil
+	for _, remoteNet := range c.remoteNets {
+		if remoteNet.IsIPv4() == routeIsIPv4 {
+			return remoteNet.GetServerIP(), nil
+		}
+	}
+	return nil, fmt.Errorf("no VPN address of the same IP family as route %s", routeNet.String())
+}
+
+func (c *Client) addRoute(routeNet *net.IPNet) error {
+	route := routeNet.String()
+	if c.routes[route] != nil {
+		return nil
+	}
+
+	gateway, err := c.getRouteGateway(routeNet)
+	if err != nil {
+		return err
+	}
+
+	err = c.iface.AddIPRoute(routeNet, gateway)
+	if err != nil {
+		return err
+	}
+	c.routes[route] = routeNet
+	return nil
+}
+
+func (c *Client) removeRoute(routeNet *net.IPNet) error {
+	route := routeNet.String()
+	if c.routes[route] == nil {
+		return nil
+	}
+	delete(c.routes, route)
+
+	gateway, err := c.getRouteGateway(routeNet)
+	if err != nil {
+		return err
+	}
+	return c.iface.RemoveIPRoute(routeNet, gateway)
+}
+
+func (c *Client) removeAllRoutes() {
+	if c.iface == nil || c.remoteNet == nil {
+		c.routes = make(map[string]*net.IPNet)
+		return
+	}
+
+	for _, routeNet := range c.routes {
+		err := c.removeRoute(routeNet)
+		if err != nil {
+			c.log.Printf("Error removing route %s: %v", routeNet.String(), err)
+		}
+	}
+}
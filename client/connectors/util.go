@@ -7,9 +7,9 @@ import (
 	"github.com/Doridian/wsvpn/shared/commands"
 )
 
-func addSupportedSerializationHeader(header http.Header) {
+func addSupportedSerializationHeader(header http.Header, allowBinary bool) {
 	header.Del(commands.SupportedCommandSerializationsHeaderName)
-	header.Add(commands.SupportedCommandSerializationsHeaderName, strings.Join(commands.GetSupportedSerializationTypeNames(), ", "))
+	header.Add(commands.SupportedCommandSerializationsHeaderName, strings.Join(commands.GetSupportedSerializationTypeNames(allowBinary), ", "))
 }
 
 func readSerializationType(header http.Header) commands.SerializationType {
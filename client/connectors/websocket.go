@@ -52,7 +52,7 @@ func (c *WebSocketConnector) Dial(config SocketConnectorConfig) (adapters.Socket
 	dialer.TLSConfig = config.GetTLSConfig()
 
 	headers := config.GetHeaders()
-	addSupportedSerializationHeader(headers)
+	addSupportedSerializationHeader(headers, false)
 	dialer.Header = ws.HandshakeHeaderHTTP(headers)
 
 	conn, reader, _, err := dialer.Dial(context.Background(), config.GetServerURL().String())
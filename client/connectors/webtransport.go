@@ -66,7 +66,7 @@ func (c *WebTransportConnector) Dial(config SocketConnectorConfig) (adapters.Soc
 	}
 
 	headers := config.GetHeaders()
-	addSupportedSerializationHeader(headers)
+	addSupportedSerializationHeader(headers, true)
 	resp, conn, err := dialer.Dial(context.Background(), serverURL.String(), headers)
 	if err != nil {
 		return nil, err
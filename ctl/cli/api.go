@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	server_cli "github.com/Doridian/wsvpn/server/cli"
+)
+
+const unixPrefix = "unix:"
+
+// apiClient talks to the admin listener or, if that is not configured, the API on the VPN listener
+type apiClient struct {
+	baseURL    string
+	token      string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+type apiOptions struct {
+	url        string
+	token      string
+	authFile   string
+	ca         string
+	cert       string
+	key        string
+	serverName string
+	insecure   bool
+	timeout    time.Duration
+}
+
+// localAddress turns a listen address into one we can connect to, wildcard hosts become localhost
+func localAddress(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	ip := net.ParseIP(host)
+	if host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func makeAPIClient(configFile string, opts *apiOptions) (*apiClient, error) {
+	client := &apiClient{
+		token: opts.token,
+	}
+	if client.token == "" {
+		client.token = os.Getenv("WSVPN_TOKEN")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         opts.serverName,
+		InsecureSkipVerify: opts.insecure, // #nosec G402 -- Only if explicitly asked for
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+	client.httpClient = &http.Client{
+		Transport: transport,
+		Timeout:   opts.timeout,
+	}
+
+	listen := ""
+	useTLS := false
+	if opts.url != "" {
+		client.baseURL = strings.TrimSuffix(opts.url, "/")
+	} else {
+		config, err := server_cli.Load(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading server config %s (use -url to not need it): %v", configFile, err)
+		}
+
+		if config.Server.Admin.Listen != "" {
+			listen = config.Server.Admin.Listen
+			useTLS = config.Server.Admin.TLS.Certificate != ""
+			if client.token == "" && len(config.Server.Admin.Tokens) > 0 {
+				client.token = config.Server.Admin.Tokens[0]
+			}
+		} else {
+			if !config.Server.API.Enabled {
+				return nil, errors.New("neither server.admin.listen nor server.api.enabled are set in the server config")
+			}
+			listen = config.Server.Listen
+			useTLS = config.Server.TLS.Certificate != ""
+		}
+
+		if strings.HasPrefix(listen, unixPrefix) {
+			socketPath := listen[len(unixPrefix):]
+			transport.DialContext = func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			}
+			listen = "localhost"
+		} else {
+			listen = localAddress(listen)
+		}
+
+		if useTLS {
+			client.baseURL = "https://" + listen
+		} else {
+			client.baseURL = "http://" + listen
+		}
+	}
+
+	if opts.ca != "" {
+		caPEM, err := os.ReadFile(opts.ca)
+		if err != nil {
+			return nil, err
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("error reading CA PEM")
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if opts.cert != "" || opts.key != "" {
+		cert, err := tls.LoadX509KeyPair(opts.cert, opts.key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.authFile != "" {
+		authData, err := os.ReadFile(opts.authFile)
+		if err != nil {
+			return nil, err
+		}
+		authSplit := strings.SplitN(strings.Trim(string(authData), "\r\n\t "), ":", 2)
+		if len(authSplit) != 2 {
+			return nil, errors.New("auth file must contain user:password")
+		}
+		client.username = authSplit[0]
+		client.password = authSplit[1]
+	}
+
+	return client, nil
+}
+
+func (c *apiClient) request(method string, path string, body interface{}) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = strings.NewReader(string(bodyBytes))
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// call does an API request and decodes the JSON response into out (if not nil)
+// Responses with status codes in okStatus are decoded even though they are not 200
+func (c *apiClient) call(method string, path string, body interface{}, out interface{}, okStatus ...int) (int, error) {
+	resp, err := c.request(method, path, body)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	statusOk := resp.StatusCode == http.StatusOK
+	for _, status := range okStatus {
+		if resp.StatusCode == status {
+			statusOk = true
+		}
+	}
+
+	if !statusOk {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
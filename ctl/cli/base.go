@@ -0,0 +1,181 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+type command struct {
+	usage       string
+	description string
+	minArgs     int
+	maxArgs     int
+	run         func(c *ctl, args []string) error
+}
+
+type ctl struct {
+	api      *apiClient
+	json     bool
+	msgType  string
+	username string
+}
+
+var ctlCommands = map[string]*command{
+	"clients": {
+		usage:       "clients",
+		description: "List connected clients",
+		run:         (*ctl).listClients,
+	},
+	"client": {
+		usage:       "client CLIENT_ID",
+		description: "Show details of a connected client",
+		minArgs:     1,
+		maxArgs:     1,
+		run:         (*ctl).showClient,
+	},
+	"kick": {
+		usage:       "kick CLIENT_ID",
+		description: "Disconnect a client",
+		minArgs:     1,
+		maxArgs:     1,
+		run:         (*ctl).kickClient,
+	},
+	"kick-user": {
+		usage:       "kick-user USERNAME",
+		description: "Disconnect all clients of a user",
+		minArgs:     1,
+		maxArgs:     1,
+		run:         (*ctl).kickUser,
+	},
+	"message": {
+		usage:       "message CLIENT_ID TEXT...",
+		description: "Send a message to a client",
+		minArgs:     2,
+		maxArgs:     -1,
+		run:         (*ctl).sendMessage,
+	},
+	"broadcast": {
+		usage:       "broadcast TEXT...",
+		description: "Send a message to all clients (or those of -user)",
+		minArgs:     1,
+		maxArgs:     -1,
+		run:         (*ctl).broadcastMessage,
+	},
+	"stats": {
+		usage:       "stats",
+		description: "Show traffic totals of connected clients and packet filter counters",
+		run:         (*ctl).showStats,
+	},
+	"reload": {
+		usage:       "reload",
+		description: "Reload the server configuration",
+		run:         (*ctl).reload,
+	},
+	"mtu": {
+		usage:       "mtu [MTU]",
+		description: "Show or change the tunnel MTU",
+		maxArgs:     1,
+		run:         (*ctl).mtu,
+	},
+	"events": {
+		usage:       "events",
+		description: "Print server events as they happen until interrupted",
+		run:         (*ctl).events,
+	},
+}
+
+func printUsage(flags *flag.FlagSet) {
+	out := flags.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s -mode ctl [-config server.yml] COMMAND [options] [args...]\n\n", os.Args[0])
+	_, _ = fmt.Fprintf(out, "Talks to the API of the server configured in the given server config.\n")
+	_, _ = fmt.Fprintf(out, "Uses server.admin if set, otherwise the API on the VPN listener (server.api.enabled).\n\n")
+	_, _ = fmt.Fprintf(out, "Commands:\n")
+
+	names := make([]string, 0, len(ctlCommands))
+	for name := range ctlCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(out, "  %-28s %s\n", ctlCommands[name].usage, ctlCommands[name].description)
+	}
+
+	_, _ = fmt.Fprintf(out, "\nOptions:\n")
+	flags.PrintDefaults()
+}
+
+func fail(err error) {
+	_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
+
+func Main(configPtr *string, args []string) {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	opts := &apiOptions{}
+	c := &ctl{}
+
+	flags.StringVar(&opts.url, "url", "", "Base URL of the API (for example http://localhost:9001), instead of taking it from the config")
+	flags.StringVar(&opts.token, "token", "", "Bearer token, defaults to the WSVPN_TOKEN environment variable or the first of server.admin.tokens")
+	flags.StringVar(&opts.authFile, "auth-file", "", "File containing user:password for the API on the VPN listener")
+	flags.StringVar(&opts.ca, "ca", "", "CA bundle to verify the server certificate with")
+	flags.StringVar(&opts.cert, "cert", "", "Client certificate for mTLS")
+	flags.StringVar(&opts.key, "key", "", "Client private key for mTLS")
+	flags.StringVar(&opts.serverName, "server-name", "", "Hostname to check for in the server certificate")
+	flags.BoolVar(&opts.insecure, "insecure", false, "Do not verify the server certificate")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "Timeout for API requests (except events)")
+	flags.BoolVar(&c.json, "json", false, "Print raw JSON instead of tables")
+	flags.StringVar(&c.msgType, "type", "", "Message type for message and broadcast (default info)")
+	flags.StringVar(&c.username, "user", "", "Only broadcast to clients of this user")
+	flags.Usage = func() {
+		printUsage(flags)
+	}
+
+	// Options may be given anywhere between the positional arguments
+	positional := make([]string, 0, len(args))
+	for {
+		_ = flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if len(positional) < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cmdName := positional[0]
+	cmdArgs := positional[1:]
+	if cmdName == "help" {
+		flags.SetOutput(os.Stdout)
+		flags.Usage()
+		return
+	}
+
+	cmd := ctlCommands[cmdName]
+	if cmd == nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", cmdName)
+		flags.Usage()
+		os.Exit(2)
+	}
+	if len(cmdArgs) < cmd.minArgs || (cmd.maxArgs >= 0 && len(cmdArgs) > cmd.maxArgs) {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s\n", cmd.usage)
+		os.Exit(2)
+	}
+
+	var err error
+	c.api, err = makeAPIClient(*configPtr, opts)
+	if err != nil {
+		fail(err)
+	}
+
+	err = cmd.run(c, cmdArgs)
+	if err != nil {
+		fail(err)
+	}
+}
@@ -0,0 +1,317 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Doridian/wsvpn/server/acl"
+	"github.com/Doridian/wsvpn/server/servers"
+)
+
+func printJSON(data interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	return enc.Encode(data)
+}
+
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(since time.Time) string {
+	return time.Since(since).Truncate(time.Second).String()
+}
+
+func (c *ctl) listClients(_ []string) error {
+	clients := make([]servers.SocketStruct, 0)
+	_, err := c.api.call(http.MethodGet, "/api/clients", nil, &clients)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return printJSON(clients)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "CLIENT ID\tUSERNAME\tVPN IPS\tREMOTE ADDRESS\tPROTOCOL\tCONNECTED\tIN\tOUT\tRTT")
+	for _, client := range clients {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%.1f ms\n",
+			client.ClientID, client.Username, strings.Join(client.VPNIPs, ","), client.RemoteAddr, client.Protocol,
+			formatDuration(client.ConnectedSince), formatBytes(client.BytesIn), formatBytes(client.BytesOut), client.PingRTT)
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) showClient(args []string) error {
+	client := &servers.SocketStruct{}
+	_, err := c.api.call(http.MethodGet, "/api/clients/"+url.PathEscape(args[0]), nil, client)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return printJSON(client)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Client ID:\t%s\n", client.ClientID)
+	_, _ = fmt.Fprintf(tw, "Username:\t%s\n", client.Username)
+	_, _ = fmt.Fprintf(tw, "VPN IPs:\t%s\n", strings.Join(client.VPNIPs, ", "))
+	_, _ = fmt.Fprintf(tw, "Remote address:\t%s\n", client.RemoteAddr)
+	_, _ = fmt.Fprintf(tw, "Local address:\t%s\n", client.LocalAddr)
+	_, _ = fmt.Fprintf(tw, "Protocol:\t%s\n", client.Protocol)
+	_, _ = fmt.Fprintf(tw, "Version:\t%s (protocol %d)\n", client.Version, client.ProtocolVersion)
+	_, _ = fmt.Fprintf(tw, "Features:\t%s\n", strings.Join(client.Features, ", "))
+	if client.TLSVersion != "" {
+		_, _ = fmt.Fprintf(tw, "TLS:\t%s %s\n", client.TLSVersion, client.TLSCipher)
+	}
+	_, _ = fmt.Fprintf(tw, "Connected since:\t%s (%s)\n", client.ConnectedSince.Local().Format(time.RFC3339), formatDuration(client.ConnectedSince))
+	_, _ = fmt.Fprintf(tw, "In:\t%s, %d packets\n", formatBytes(client.BytesIn), client.PacketsIn)
+	_, _ = fmt.Fprintf(tw, "Out:\t%s, %d packets\n", formatBytes(client.BytesOut), client.PacketsOut)
+	_, _ = fmt.Fprintf(tw, "Dropped:\t%d packets\n", client.PacketsDropped)
+	_, _ = fmt.Fprintf(tw, "Ping RTT:\t%.1f ms\n", client.PingRTT)
+	return tw.Flush()
+}
+
+func (c *ctl) kickClient(args []string) error {
+	_, err := c.api.call(http.MethodDelete, "/api/clients/"+url.PathEscape(args[0]), nil, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Disconnected client %s\n", args[0])
+	return nil
+}
+
+func (c *ctl) kickUser(args []string) error {
+	res := &servers.DisconnectedStruct{}
+	_, err := c.api.call(http.MethodDelete, "/api/users/"+url.PathEscape(args[0]), nil, res)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Disconnected %d client(s) of user %s\n", res.Disconnected, args[0])
+	return nil
+}
+
+func (c *ctl) sendMessage(args []string) error {
+	res := &servers.MessageResponse{}
+	_, err := c.api.call(http.MethodPost, "/api/clients/"+url.PathEscape(args[0])+"/message", &servers.MessageRequest{
+		Type:    c.msgType,
+		Message: strings.Join(args[1:], " "),
+	}, res)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sent message to client %s\n", args[0])
+	return nil
+}
+
+func (c *ctl) broadcastMessage(args []string) error {
+	res := &servers.MessageResponse{}
+	_, err := c.api.call(http.MethodPost, "/api/message", &servers.MessageRequest{
+		Type:     c.msgType,
+		Message:  strings.Join(args, " "),
+		Username: c.username,
+	}, res)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sent message to %d client(s)\n", res.Sent)
+	return nil
+}
+
+type statsOutput struct {
+	Clients        int        `json:"clients"`
+	Users          int        `json:"users"`
+	BytesIn        uint64     `json:"bytes_in"`
+	BytesOut       uint64     `json:"bytes_out"`
+	PacketsIn      uint64     `json:"packets_in"`
+	PacketsOut     uint64     `json:"packets_out"`
+	PacketsDropped uint64     `json:"packets_dropped"`
+	ACL            *acl.Stats `json:"acl,omitempty"`
+}
+
+func (c *ctl) showStats(_ []string) error {
+	clients := make([]servers.SocketStruct, 0)
+	_, err := c.api.call(http.MethodGet, "/api/clients", nil, &clients)
+	if err != nil {
+		return err
+	}
+
+	stats := &statsOutput{
+		Clients: len(clients),
+	}
+	users := make(map[string]bool)
+	for _, client := range clients {
+		if client.Username != "" {
+			users[client.Username] = true
+		}
+		stats.BytesIn += client.BytesIn
+		stats.BytesOut += client.BytesOut
+		stats.PacketsIn += client.PacketsIn
+		stats.PacketsOut += client.PacketsOut
+		stats.PacketsDropped += client.PacketsDropped
+	}
+	stats.Users = len(users)
+
+	aclStats := &acl.Stats{}
+	status, err := c.api.call(http.MethodGet, "/api/acl", nil, aclStats)
+	if err == nil {
+		stats.ACL = aclStats
+	} else if status != http.StatusNotFound {
+		return err
+	}
+
+	if c.json {
+		return printJSON(stats)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Connected clients:\t%d (%d users)\n", stats.Clients, stats.Users)
+	_, _ = fmt.Fprintf(tw, "In:\t%s, %d packets\n", formatBytes(stats.BytesIn), stats.PacketsIn)
+	_, _ = fmt.Fprintf(tw, "Out:\t%s, %d packets\n", formatBytes(stats.BytesOut), stats.PacketsOut)
+	_, _ = fmt.Fprintf(tw, "Dropped:\t%d packets\n", stats.PacketsDropped)
+	if stats.ACL != nil {
+		_, _ = fmt.Fprintf(tw, "\nPacket filter rule\tAction\tHits\n")
+		for _, rule := range stats.ACL.Rules {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\n", rule.Name, rule.Action, rule.Hits)
+		}
+		_, _ = fmt.Fprintf(tw, "(default)\t%s\t%d\n", stats.ACL.DefaultAction, stats.ACL.DefaultHits)
+	}
+	return tw.Flush()
+}
+
+func (c *ctl) reload(_ []string) error {
+	res := &servers.ReloadResult{}
+	_, err := c.api.call(http.MethodPost, "/api/reload", nil, res, http.StatusInternalServerError)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		err = printJSON(res)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, warning := range res.Warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+		if res.Success {
+			fmt.Println("Configuration reloaded")
+		}
+	}
+
+	if !res.Success {
+		return fmt.Errorf("reload failed: %s", res.Error)
+	}
+	return nil
+}
+
+func (c *ctl) mtu(args []string) error {
+	res := &servers.MTUStruct{}
+	var err error
+	if len(args) == 0 {
+		_, err = c.api.call(http.MethodGet, "/api/mtu", nil, res)
+	} else {
+		var mtu int
+		mtu, err = strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid MTU: %s", args[0])
+		}
+		_, err = c.api.call(http.MethodPut, "/api/mtu", &servers.MTUStruct{MTU: mtu}, res)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return printJSON(res)
+	}
+	fmt.Println(res.MTU)
+	return nil
+}
+
+func (c *ctl) events(_ []string) error {
+	c.api.httpClient.Timeout = 0
+	resp, err := c.api.request(http.MethodGet, "/api/events", nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /api/events: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := line[len("data: "):]
+
+		if c.json {
+			fmt.Println(data)
+			continue
+		}
+
+		evt := &servers.Event{}
+		err = json.Unmarshal([]byte(data), evt)
+		if err != nil {
+			return err
+		}
+		printEvent(evt)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream ended")
+}
+
+func printEvent(evt *servers.Event) {
+	details := make([]string, 0)
+	addDetail := func(name string, value string) {
+		if value != "" {
+			details = append(details, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	addDetail("client", evt.ClientID)
+	addDetail("user", evt.Username)
+	addDetail("remote", evt.RemoteAddr)
+	addDetail("ips", strings.Join(evt.VPNIPs, ","))
+	addDetail("source", evt.Source)
+	if evt.Reason != "" {
+		addDetail("reason", strconv.Quote(evt.Reason))
+	}
+	if evt.MTU != 0 {
+		addDetail("mtu", strconv.Itoa(evt.MTU))
+	}
+	if evt.Error != "" {
+		addDetail("error", strconv.Quote(evt.Error))
+	}
+
+	fmt.Printf("%s %s %s\n", evt.Time.Local().Format(time.RFC3339), evt.Type, strings.Join(details, " "))
+}
@@ -0,0 +1,269 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+type Action int
+
+const (
+	ActionAllow Action = iota
+	ActionDeny
+)
+
+func (a Action) ToString() string {
+	if a == ActionDeny {
+		return "deny"
+	}
+	return "allow"
+}
+
+func actionFromString(action string) (Action, error) {
+	switch strings.ToLower(action) {
+	case "allow":
+		return ActionAllow, nil
+	case "deny":
+		return ActionDeny, nil
+	}
+	return ActionAllow, fmt.Errorf("invalid ACL action: %s", action)
+}
+
+type Direction int
+
+const (
+	DirectionAny Direction = iota
+	// DirectionIn is used for packets sent by a client into the VPN
+	DirectionIn
+	// DirectionOut is used for packets sent to a client from the VPN
+	DirectionOut
+)
+
+func (d Direction) ToString() string {
+	switch d {
+	case DirectionIn:
+		return "in"
+	case DirectionOut:
+		return "out"
+	}
+	return "any"
+}
+
+func directionFromString(direction string) (Direction, error) {
+	switch strings.ToLower(direction) {
+	case "", "any":
+		return DirectionAny, nil
+	case "in":
+		return DirectionIn, nil
+	case "out":
+		return DirectionOut, nil
+	}
+	return DirectionAny, fmt.Errorf("invalid ACL direction: %s", direction)
+}
+
+const protocolAny = -1
+
+func protocolFromString(protocol string) (int, error) {
+	switch strings.ToLower(protocol) {
+	case "", "any":
+		return protocolAny, nil
+	case "icmp":
+		return 1, nil
+	case "tcp":
+		return 6, nil
+	case "udp":
+		return 17, nil
+	case "icmpv6":
+		return 58, nil
+	}
+
+	protocolNum, err := strconv.ParseUint(protocol, 10, 8)
+	if err != nil {
+		return protocolAny, fmt.Errorf("invalid ACL protocol: %s", protocol)
+	}
+	return int(protocolNum), nil
+}
+
+type portRange struct {
+	from uint16
+	to   uint16
+}
+
+func portRangeFromString(ports string) (portRange, error) {
+	fromStr, toStr, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		toStr = fromStr
+	}
+
+	from, err := strconv.ParseUint(fromStr, 10, 16)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid ACL port range: %s", ports)
+	}
+	to, err := strconv.ParseUint(toStr, 10, 16)
+	if err != nil || to < from {
+		return portRange{}, fmt.Errorf("invalid ACL port range: %s", ports)
+	}
+
+	return portRange{
+		from: uint16(from),
+		to:   uint16(to),
+	}, nil
+}
+
+type Rule struct {
+	name      string
+	action    Action
+	direction Direction
+	srcNets   []*net.IPNet
+	dstNets   []*net.IPNet
+	protocol  int
+	srcPorts  []portRange
+	dstPorts  []portRange
+	users     map[string]bool
+	groups    map[string]bool
+
+	hits uint64
+}
+
+type ACL struct {
+	rules         []*Rule
+	defaultAction Action
+	defaultHits   uint64
+	fragments     fragmentTracker
+}
+
+type RuleStats struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Hits   uint64 `json:"hits"`
+}
+
+type Stats struct {
+	DefaultAction string      `json:"default_action"`
+	DefaultHits   uint64      `json:"default_hits"`
+	Rules         []RuleStats `json:"rules"`
+}
+
+func (a *ACL) HasRules() bool {
+	return len(a.rules) > 0 || a.defaultAction != ActionAllow
+}
+
+func (a *ACL) GetStats() *Stats {
+	rules := make([]RuleStats, 0, len(a.rules))
+	for _, rule := range a.rules {
+		rules = append(rules, RuleStats{
+			Name:   rule.name,
+			Action: rule.action.ToString(),
+			Hits:   atomic.LoadUint64(&rule.hits),
+		})
+	}
+
+	return &Stats{
+		DefaultAction: a.defaultAction.ToString(),
+		DefaultHits:   atomic.LoadUint64(&a.defaultHits),
+		Rules:         rules,
+	}
+}
+
+// Allows evaluates the rules in order for a packet passing the switch in the given direction
+// socket is the client on the side of the switch the packet is checked on
+// A nil ACL or a nil (non-IP) packet are always allowed, Invalid packets get the default action
+// Later fragments are allowed without counting a hit if the first fragment of their datagram was allowed
+func (a *ACL) Allows(packet *Packet, direction Direction, socket *sockets.Socket) bool {
+	if a == nil || packet == nil {
+		return true
+	}
+
+	if packet.Fragmented && packet.LaterFragment && a.fragments.isAllowed(packet, direction, socket) {
+		return true
+	}
+
+	allowed := a.evaluate(packet, direction, socket)
+	if allowed && packet.Fragmented && !packet.LaterFragment {
+		a.fragments.allow(packet, direction, socket)
+	}
+	return allowed
+}
+
+func (a *ACL) evaluate(packet *Packet, direction Direction, socket *sockets.Socket) bool {
+	if packet.Invalid {
+		atomic.AddUint64(&a.defaultHits, 1)
+		return a.defaultAction == ActionAllow
+	}
+
+	username, _ := socket.Metadata["username"].(string)
+	groups, _ := socket.Metadata["groups"].([]string)
+
+	for _, rule := range a.rules {
+		if rule.matches(packet, direction, username, groups) {
+			atomic.AddUint64(&rule.hits, 1)
+			return rule.action == ActionAllow
+		}
+	}
+
+	atomic.AddUint64(&a.defaultHits, 1)
+	return a.defaultAction == ActionAllow
+}
+
+func (r *Rule) matches(packet *Packet, direction Direction, username string, groups []string) bool {
+	if r.direction != DirectionAny && r.direction != direction {
+		return false
+	}
+
+	if r.protocol != protocolAny && r.protocol != int(packet.Protocol) {
+		return false
+	}
+
+	if !netsMatch(r.srcNets, packet.SrcIP) || !netsMatch(r.dstNets, packet.DstIP) {
+		return false
+	}
+
+	// A first fragment cut short before the ports could be anything, so it matches deny rules but never allow rules
+	portsUnknown := packet.PortsTruncated && r.action == ActionDeny
+	if len(r.srcPorts) > 0 && !portsUnknown && (!packet.HasPorts || !portsMatch(r.srcPorts, packet.SrcPort)) {
+		return false
+	}
+	if len(r.dstPorts) > 0 && !portsUnknown && (!packet.HasPorts || !portsMatch(r.dstPorts, packet.DstPort)) {
+		return false
+	}
+
+	if len(r.users) > 0 || len(r.groups) > 0 {
+		if r.users[username] {
+			return true
+		}
+		for _, group := range groups {
+			if r.groups[group] {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func netsMatch(nets []*net.IPNet, ip net.IP) bool {
+	if len(nets) == 0 {
+		return true
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func portsMatch(ports []portRange, port uint16) bool {
+	for _, ports := range ports {
+		if port >= ports.from && port <= ports.to {
+			return true
+		}
+	}
+	return false
+}
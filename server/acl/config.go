@@ -0,0 +1,130 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+)
+
+type RuleConfig struct {
+	Name             string   `yaml:"name"`
+	Action           string   `yaml:"action"`
+	Direction        string   `yaml:"direction"`
+	Source           []string `yaml:"source"`
+	Destination      []string `yaml:"destination"`
+	Protocol         string   `yaml:"protocol"`
+	SourcePorts      []string `yaml:"source-ports"`
+	DestinationPorts []string `yaml:"destination-ports"`
+	Users            []string `yaml:"users"`
+	Groups           []string `yaml:"groups"`
+}
+
+type Config struct {
+	DefaultAction string       `yaml:"default-action"`
+	Rules         []RuleConfig `yaml:"rules"`
+}
+
+func Load(config *Config) (*ACL, error) {
+	defaultAction, err := actionFromString(config.DefaultAction)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*Rule, 0, len(config.Rules))
+	for i := range config.Rules {
+		rule, err := loadRule(&config.Rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("ACL rule %d: %v", i+1, err)
+		}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule-%d", i+1)
+		}
+		rules = append(rules, rule)
+	}
+
+	return &ACL{
+		rules:         rules,
+		defaultAction: defaultAction,
+	}, nil
+}
+
+func loadRule(config *RuleConfig) (*Rule, error) {
+	var err error
+	rule := &Rule{
+		name: config.Name,
+	}
+
+	rule.action, err = actionFromString(config.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.direction, err = directionFromString(config.Direction)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.protocol, err = protocolFromString(config.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.srcNets, err = loadNets(config.Source)
+	if err != nil {
+		return nil, err
+	}
+	rule.dstNets, err = loadNets(config.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.srcPorts, err = loadPortRanges(config.SourcePorts)
+	if err != nil {
+		return nil, err
+	}
+	rule.dstPorts, err = loadPortRanges(config.DestinationPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.users = make(map[string]bool, len(config.Users))
+	for _, user := range config.Users {
+		rule.users[user] = true
+	}
+	rule.groups = make(map[string]bool, len(config.Groups))
+	for _, group := range config.Groups {
+		rule.groups[group] = true
+	}
+
+	return rule, nil
+}
+
+func loadNets(nets []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(nets))
+	for _, netStr := range nets {
+		_, ipNet, err := net.ParseCIDR(netStr)
+		if err != nil {
+			ip := net.ParseIP(netStr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ACL network: %s", netStr)
+			}
+			if ip.To4() != nil {
+				ip = ip.To4()
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+func loadPortRanges(ports []string) ([]portRange, error) {
+	res := make([]portRange, 0, len(ports))
+	for _, portStr := range ports {
+		ports, err := portRangeFromString(portStr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ports)
+	}
+	return res, nil
+}
@@ -0,0 +1,83 @@
+package acl
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+// Matches the IPv6 reassembly timeout, IPv4 uses a shorter one
+const fragmentTimeout = 60 * time.Second
+const maxTrackedFragments = 4096
+
+type fragmentKey struct {
+	srcIP     [net.IPv6len]byte
+	dstIP     [net.IPv6len]byte
+	protocol  uint8
+	id        uint32
+	direction Direction
+	socket    *sockets.Socket
+}
+
+func makeFragmentKey(packet *Packet, direction Direction, socket *sockets.Socket) fragmentKey {
+	key := fragmentKey{
+		protocol:  packet.Protocol,
+		id:        packet.FragmentID,
+		direction: direction,
+		socket:    socket,
+	}
+	copy(key.srcIP[:], packet.SrcIP.To16())
+	copy(key.dstIP[:], packet.DstIP.To16())
+	return key
+}
+
+// fragmentTracker remembers the datagrams whose first fragment was allowed
+// Later fragments carry no ports, so without this they could never match port allow rules
+type fragmentTracker struct {
+	lock    sync.Mutex
+	expires map[fragmentKey]time.Time
+}
+
+func (t *fragmentTracker) allow(packet *Packet, direction Direction, socket *sockets.Socket) {
+	now := time.Now()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.expires == nil {
+		t.expires = make(map[fragmentKey]time.Time)
+	}
+
+	if len(t.expires) >= maxTrackedFragments {
+		for key, expires := range t.expires {
+			if now.After(expires) {
+				delete(t.expires, key)
+			}
+		}
+		// Later fragments of this datagram fall back to the rules, just like when they arrive before the first one
+		if len(t.expires) >= maxTrackedFragments {
+			return
+		}
+	}
+
+	t.expires[makeFragmentKey(packet, direction, socket)] = now.Add(fragmentTimeout)
+}
+
+func (t *fragmentTracker) isAllowed(packet *Packet, direction Direction, socket *sockets.Socket) bool {
+	key := makeFragmentKey(packet, direction, socket)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	expires, ok := t.expires[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(t.expires, key)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package acl
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	protocolTCP = 6
+	protocolUDP = 17
+
+	ipv6HeaderHopByHop    = 0
+	ipv6HeaderRouting     = 43
+	ipv6HeaderFragment    = 44
+	ipv6HeaderDestOptions = 60
+)
+
+type Packet struct {
+	SrcIP    net.IP
+	DstIP    net.IP
+	Protocol uint8
+	HasPorts bool
+	SrcPort  uint16
+	DstPort  uint16
+	// Invalid packets could not be parsed, only the default action applies to them
+	Invalid bool
+	// PortsTruncated is set for a first fragment too short to contain the ports
+	PortsTruncated bool
+	// Fragmented is set for all fragments of a fragmented datagram, FragmentID identifies it
+	// together with the addresses and protocol. LaterFragment is set for all but the first one
+	Fragmented    bool
+	FragmentID    uint32
+	LaterFragment bool
+}
+
+var invalidPacket = &Packet{Invalid: true}
+
+// ParsePacket extracts the fields rules can match on from an IPv4 or IPv6 packet
+// Callers only pass what should be an IP packet, so anything that is not a well-formed one is Invalid
+func ParsePacket(packet []byte) *Packet {
+	if len(packet) < 1 {
+		return invalidPacket
+	}
+
+	var res *Packet
+	var payload []byte
+	isFirstFragment := true
+
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return invalidPacket
+		}
+		headerLen := int(packet[0]&0x0F) * 4
+		if headerLen < 20 || len(packet) < headerLen {
+			return invalidPacket
+		}
+
+		res = &Packet{
+			SrcIP:    net.IP(packet[12:16]),
+			DstIP:    net.IP(packet[16:20]),
+			Protocol: packet[9],
+		}
+		payload = packet[headerLen:]
+		flagsAndOffset := binary.BigEndian.Uint16(packet[6:8])
+		isFirstFragment = flagsAndOffset&0x1FFF == 0
+		// More fragments flag or a fragment offset
+		if flagsAndOffset&0x3FFF != 0 {
+			res.Fragmented = true
+			res.FragmentID = uint32(binary.BigEndian.Uint16(packet[4:6]))
+		}
+	case 6:
+		if len(packet) < 40 {
+			return invalidPacket
+		}
+
+		res = &Packet{
+			SrcIP: net.IP(packet[8:24]),
+			DstIP: net.IP(packet[24:40]),
+		}
+
+		nextHeader := packet[6]
+		payload = packet[40:]
+	parseHeaders:
+		for {
+			switch nextHeader {
+			case ipv6HeaderHopByHop, ipv6HeaderRouting, ipv6HeaderDestOptions:
+				if len(payload) < 8 {
+					return invalidPacket
+				}
+				headerLen := (int(payload[1]) + 1) * 8
+				if len(payload) < headerLen {
+					return invalidPacket
+				}
+				nextHeader = payload[0]
+				payload = payload[headerLen:]
+			case ipv6HeaderFragment:
+				if len(payload) < 8 {
+					return invalidPacket
+				}
+				offsetAndFlags := binary.BigEndian.Uint16(payload[2:4])
+				isFirstFragment = isFirstFragment && offsetAndFlags&0xFFF8 == 0
+				// Atomic fragments (offset 0, no more fragments flag) are whole datagrams
+				if offsetAndFlags&0xFFF9 != 0 {
+					res.Fragmented = true
+					res.FragmentID = binary.BigEndian.Uint32(payload[4:8])
+				}
+				nextHeader = payload[0]
+				payload = payload[8:]
+			default:
+				break parseHeaders
+			}
+		}
+		res.Protocol = nextHeader
+	default:
+		return invalidPacket
+	}
+
+	res.LaterFragment = !isFirstFragment
+
+	// Only the first fragment carries the ports, later ones are allowed if it was
+	if isFirstFragment && (res.Protocol == protocolTCP || res.Protocol == protocolUDP) {
+		if len(payload) < 4 {
+			res.PortsTruncated = true
+		} else {
+			res.HasPorts = true
+			res.SrcPort = binary.BigEndian.Uint16(payload[0:2])
+			res.DstPort = binary.BigEndian.Uint16(payload[2:4])
+		}
+	}
+
+	return res
+}
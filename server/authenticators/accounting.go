@@ -0,0 +1,35 @@
+package authenticators
+
+import (
+	"net"
+	"time"
+)
+
+type AccountingTerminateCause int
+
+const (
+	TerminateUserRequest    AccountingTerminateCause = iota // Connection closed for any reason but the session timeout
+	TerminateSessionTimeout                                 // AuthDetails.SessionTimeout was reached
+)
+
+// AccountingRecord describes a connection and the traffic it had so far
+type AccountingRecord struct {
+	SessionID      string
+	Username       string
+	RemoteAddr     string
+	VPNIP          net.IP
+	Started        time.Time
+	BytesIn        uint64 // Received from the client
+	BytesOut       uint64 // Sent to the client
+	PacketsIn      uint64
+	PacketsOut     uint64
+	TerminateCause AccountingTerminateCause // Only set for Stop
+}
+
+// Accounting is told about the connections of users accepted by an authenticator
+// Calls for one connection are made one after another from a goroutine of their own, so they may block
+type Accounting interface {
+	Start(record *AccountingRecord)
+	Interim(record *AccountingRecord)
+	Stop(record *AccountingRecord)
+}
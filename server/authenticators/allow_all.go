@@ -7,6 +7,10 @@ type AllowAllAuthenticator struct {
 
 var _ Authenticator = &AllowAllAuthenticator{}
 
+func (a *AllowAllAuthenticator) Name() string {
+	return "allow-all"
+}
+
 func (a *AllowAllAuthenticator) Load(configFile string) error {
 	return nil
 }
@@ -1,7 +1,11 @@
 package authenticators
 
 import (
+	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type AuthResult int
@@ -13,6 +17,56 @@ const (
 )
 
 type Authenticator interface {
+	Name() string
 	Load(configFile string) error
 	Authenticate(r *http.Request, w http.ResponseWriter) (AuthResult, string)
 }
+
+// AuthDetails is what an authenticator knows about an accepted user besides the username
+type AuthDetails struct {
+	Groups             []string      // Added to the groups configured for the user
+	FramedIP           net.IP        // Address to give to the connection instead of a static or leased one
+	SessionTimeout     time.Duration // Disconnect after this long, 0 for no limit
+	Accounting         Accounting    // Told about connections of the user, may be nil
+	AccountingInterval time.Duration // How often to send interim accounting updates, 0 to not send any
+}
+
+// DetailsAuthenticator is implemented by authenticators which know more about a user than the username
+type DetailsAuthenticator interface {
+	Authenticator
+	AuthenticateWithDetails(r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails)
+}
+
+// New makes an authenticator of the given type, which still needs to be loaded
+func New(authenticatorType string) (Authenticator, error) {
+	switch strings.ToLower(authenticatorType) {
+	case "allow-all":
+		return &AllowAllAuthenticator{}, nil
+	case "htpasswd":
+		return &HtpasswdAuthenticator{}, nil
+	case "radius":
+		return &RadiusAuthenticator{}, nil
+	case "oidc":
+		return &OIDCAuthenticator{}, nil
+	case "ldap":
+		return &LDAPAuthenticator{}, nil
+	case "chain":
+		return &ChainAuthenticator{}, nil
+	}
+	return nil, errors.New("invalid authenticator selected")
+}
+
+// AuthenticateWithDetails runs an authenticator, the returned details are never nil
+func AuthenticateWithDetails(a Authenticator, r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails) {
+	detailsAuthenticator, ok := a.(DetailsAuthenticator)
+	if !ok {
+		authResult, username := a.Authenticate(r, w)
+		return authResult, username, &AuthDetails{}
+	}
+
+	authResult, username, details := detailsAuthenticator.AuthenticateWithDetails(r, w)
+	if details == nil {
+		details = &AuthDetails{}
+	}
+	return authResult, username, details
+}
@@ -0,0 +1,219 @@
+package authenticators
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ChainModeAny = "any" // The first authenticator to accept the client wins
+	ChainModeAll = "all" // All authenticators have to accept the client
+)
+
+type chainStep struct {
+	Type   string `yaml:"type"`
+	Config string `yaml:"config"`
+
+	authenticator Authenticator
+}
+
+// ChainAuthenticator tries a list of authenticators in order
+type ChainAuthenticator struct {
+	Mode           string       `yaml:"mode"`
+	Authenticators []*chainStep `yaml:"authenticators"`
+}
+
+var _ DetailsAuthenticator = &ChainAuthenticator{}
+
+func (a *ChainAuthenticator) Name() string {
+	return "chain"
+}
+
+func (a *ChainAuthenticator) Load(configFile string) error {
+	fh, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	a.Mode = ChainModeAny
+	err = yaml.NewDecoder(fh).Decode(a)
+	if err != nil {
+		return err
+	}
+
+	a.Mode = strings.ToLower(a.Mode)
+	if a.Mode != ChainModeAny && a.Mode != ChainModeAll {
+		return fmt.Errorf("chain: invalid mode %s, must be %s or %s", a.Mode, ChainModeAny, ChainModeAll)
+	}
+	if len(a.Authenticators) == 0 {
+		return errors.New("chain: authenticators must not be empty")
+	}
+
+	for i, step := range a.Authenticators {
+		step.authenticator, err = New(step.Type)
+		if err != nil {
+			return fmt.Errorf("chain: step %d: %v", i, err)
+		}
+		err = step.authenticator.Load(step.Config)
+		if err != nil {
+			return fmt.Errorf("chain: step %d (%s): %v", i, step.authenticator.Name(), err)
+		}
+	}
+	return nil
+}
+
+// chainResponseWriter holds back what a step responds with, as only the response of the step that decides
+// the outcome may reach the client
+type chainResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newChainResponseWriter() *chainResponseWriter {
+	return &chainResponseWriter{
+		header: make(http.Header),
+	}
+}
+
+func (c *chainResponseWriter) Header() http.Header {
+	return c.header
+}
+
+func (c *chainResponseWriter) Write(data []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(data)
+}
+
+func (c *chainResponseWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *chainResponseWriter) replay(w http.ResponseWriter) {
+	for key, values := range c.header {
+		w.Header()[key] = values
+	}
+	if c.status == 0 {
+		return
+	}
+	w.WriteHeader(c.status)
+	_, _ = w.Write(c.body.Bytes())
+}
+
+func (a *ChainAuthenticator) Authenticate(r *http.Request, w http.ResponseWriter) (AuthResult, string) {
+	result, username, _ := a.AuthenticateWithDetails(r, w)
+	return result, username
+}
+
+func (a *ChainAuthenticator) AuthenticateWithDetails(r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails) {
+	if a.Mode == ChainModeAll {
+		return a.authenticateAll(r, w)
+	}
+	return a.authenticateAny(r, w)
+}
+
+func (a *ChainAuthenticator) authenticateAny(r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails) {
+	var customResponse *chainResponseWriter
+	wwwAuthenticate := make([]string, 0)
+
+	for i, step := range a.Authenticators {
+		stepWriter := newChainResponseWriter()
+		result, username, details := AuthenticateWithDetails(step.authenticator, r, stepWriter)
+		if result == AuthOk {
+			log.Printf("chain: step %d (%s) accepted user %q", i, step.authenticator.Name(), username)
+			return AuthOk, username, details
+		}
+
+		log.Printf("chain: step %d (%s) rejected client", i, step.authenticator.Name())
+		if result == AuthFailedCustom && customResponse == nil {
+			customResponse = stepWriter
+		}
+		for _, value := range stepWriter.header.Values("WWW-Authenticate") {
+			if !containsString(wwwAuthenticate, value) {
+				wwwAuthenticate = append(wwwAuthenticate, value)
+			}
+		}
+	}
+
+	// A custom response (such as an internal error) is more useful than a generic one
+	if customResponse != nil {
+		customResponse.replay(w)
+		return AuthFailedCustom, "", nil
+	}
+	for _, value := range wwwAuthenticate {
+		w.Header().Add("WWW-Authenticate", value)
+	}
+	return AuthFailedDefault, "", nil
+}
+
+func (a *ChainAuthenticator) authenticateAll(r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails) {
+	chainUsername := ""
+	chainDetails := &AuthDetails{
+		Groups: make([]string, 0),
+	}
+
+	for i, step := range a.Authenticators {
+		stepWriter := newChainResponseWriter()
+		result, username, details := AuthenticateWithDetails(step.authenticator, r, stepWriter)
+		if result != AuthOk {
+			log.Printf("chain: step %d (%s) rejected client", i, step.authenticator.Name())
+			stepWriter.replay(w)
+			return result, "", nil
+		}
+
+		if username != "" {
+			if chainUsername != "" && username != chainUsername {
+				log.Printf("chain: step %d (%s) accepted user %q, but earlier steps accepted %q", i, step.authenticator.Name(), username, chainUsername)
+				return AuthFailedDefault, "", nil
+			}
+			chainUsername = username
+		}
+		chainDetails.merge(details)
+		log.Printf("chain: step %d (%s) accepted user %q", i, step.authenticator.Name(), username)
+	}
+
+	return AuthOk, chainUsername, chainDetails
+}
+
+// merge adds the details of a later step, the first step to set a value wins except for the
+// shortest session timeout
+func (d *AuthDetails) merge(other *AuthDetails) {
+	for _, group := range other.Groups {
+		if !containsString(d.Groups, group) {
+			d.Groups = append(d.Groups, group)
+		}
+	}
+	if d.FramedIP == nil {
+		d.FramedIP = other.FramedIP
+	}
+	if other.SessionTimeout > 0 && (d.SessionTimeout == 0 || other.SessionTimeout < d.SessionTimeout) {
+		d.SessionTimeout = other.SessionTimeout
+	}
+	if d.Accounting == nil {
+		d.Accounting = other.Accounting
+		d.AccountingInterval = other.AccountingInterval
+	}
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
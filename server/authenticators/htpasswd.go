@@ -12,6 +12,10 @@ type HtpasswdAuthenticator struct {
 
 var _ Authenticator = &HtpasswdAuthenticator{}
 
+func (a *HtpasswdAuthenticator) Name() string {
+	return "htpasswd"
+}
+
 func (a *HtpasswdAuthenticator) Load(configFile string) (err error) {
 	if configFile == "" {
 		configFile = "htpasswd"
@@ -0,0 +1,382 @@
+package authenticators
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultLDAPUserFilter = "(uid={username})"
+const defaultLDAPGroupFilter = "(|(member={dn})(uniqueMember={dn})(memberUid={username}))"
+
+// ldapConn is the part of *ldap.Conn we use, so a stand-in directory can be plugged in via LDAPAuthenticator.connect
+type ldapConn interface {
+	Bind(username, password string) error
+	UnauthenticatedBind(username string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	IsClosing() bool
+	Close() error
+}
+
+// LDAPAuthenticator checks HTTP Basic credentials by searching for the user's entry and binding as it
+type LDAPAuthenticator struct {
+	URL      string `yaml:"url"`
+	StartTLS bool   `yaml:"start-tls"`
+	TLS      struct {
+		CA                 string `yaml:"ca"`
+		ServerName         string `yaml:"server-name"`
+		InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+	} `yaml:"tls"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	BindDN       string `yaml:"bind-dn"`
+	BindPassword string `yaml:"bind-password"`
+
+	BaseDN            string `yaml:"base-dn"`
+	UserFilter        string `yaml:"user-filter"`
+	UsernameAttribute string `yaml:"username-attribute"`
+
+	GroupBaseDN        string   `yaml:"group-base-dn"`
+	GroupFilter        string   `yaml:"group-filter"`
+	GroupNameAttribute string   `yaml:"group-name-attribute"`
+	RequiredGroups     []string `yaml:"required-groups"`
+
+	PoolSize int `yaml:"pool-size"`
+
+	tlsConfig *tls.Config
+	dial      func() (ldapConn, error)
+	pool      chan ldapConn
+}
+
+var _ DetailsAuthenticator = &LDAPAuthenticator{}
+
+func (a *LDAPAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a *LDAPAuthenticator) Load(configFile string) error {
+	err := a.loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	return a.connect(a.dialLDAP)
+}
+
+// loadConfig reads and checks the config without talking to the directory yet
+func (a *LDAPAuthenticator) loadConfig(configFile string) error {
+	fh, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	a.Timeout = 10 * time.Second
+	a.UserFilter = defaultLDAPUserFilter
+	a.GroupFilter = defaultLDAPGroupFilter
+	a.GroupNameAttribute = "cn"
+	a.PoolSize = 4
+	err = yaml.NewDecoder(fh).Decode(a)
+	if err != nil {
+		return err
+	}
+
+	if a.URL == "" {
+		return errors.New("ldap: url must be set")
+	}
+	if a.BaseDN == "" {
+		return errors.New("ldap: base-dn must be set")
+	}
+	if !strings.Contains(a.UserFilter, "{username}") {
+		return errors.New("ldap: user-filter must contain {username}")
+	}
+	if len(a.RequiredGroups) > 0 && a.GroupBaseDN == "" {
+		return errors.New("ldap: required-groups needs group-base-dn to be set")
+	}
+	if a.StartTLS && strings.HasPrefix(strings.ToLower(a.URL), "ldaps://") {
+		return errors.New("ldap: start-tls can not be used with ldaps:// URLs")
+	}
+
+	a.tlsConfig = &tls.Config{
+		ServerName:         a.TLS.ServerName,
+		InsecureSkipVerify: a.TLS.InsecureSkipVerify, // #nosec G402 -- Only if explicitly configured
+	}
+	if a.tlsConfig.ServerName == "" {
+		a.tlsConfig.ServerName = ldapURLHost(a.URL)
+	}
+	if a.TLS.CA != "" {
+		caPEM, err := os.ReadFile(a.TLS.CA)
+		if err != nil {
+			return err
+		}
+		a.tlsConfig.RootCAs = x509.NewCertPool()
+		if !a.tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return errors.New("ldap: error reading CA PEM")
+		}
+	}
+
+	if a.PoolSize < 0 {
+		a.PoolSize = 0
+	}
+	return nil
+}
+
+// connect sets up the connection pool using dial and makes sure the directory is reachable and the service account works
+func (a *LDAPAuthenticator) connect(dial func() (ldapConn, error)) error {
+	a.pool = make(chan ldapConn, a.PoolSize)
+	a.dial = dial
+
+	conn, err := a.getConn()
+	if err != nil {
+		return fmt.Errorf("ldap: %v", err)
+	}
+	a.putConn(conn)
+	return nil
+}
+
+func (a *LDAPAuthenticator) dialLDAP() (ldapConn, error) {
+	conn, err := ldap.DialURL(a.URL, ldap.DialWithDialer(&net.Dialer{Timeout: a.Timeout}), ldap.DialWithTLSConfig(a.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.Timeout)
+
+	if a.StartTLS {
+		err = conn.StartTLS(a.tlsConfig)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func ldapURLHost(ldapURL string) string {
+	parsedURL, err := url.Parse(ldapURL)
+	if err != nil {
+		return ""
+	}
+	return parsedURL.Hostname()
+}
+
+// bindService binds as the service account (or anonymously) for searches
+func (a *LDAPAuthenticator) bindService(conn ldapConn) error {
+	if a.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(a.BindDN, a.BindPassword)
+}
+
+// getConn returns an idle pooled connection bound as the service account, or a new one
+func (a *LDAPAuthenticator) getConn() (ldapConn, error) {
+	for {
+		select {
+		case conn := <-a.pool:
+			if !conn.IsClosing() {
+				return conn, nil
+			}
+			continue
+		default:
+		}
+
+		conn, err := a.dial()
+		if err != nil {
+			return nil, err
+		}
+		err = a.bindService(conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("service bind failed: %v", err)
+		}
+		return conn, nil
+	}
+}
+
+func (a *LDAPAuthenticator) putConn(conn ldapConn) {
+	if conn.IsClosing() {
+		return
+	}
+	select {
+	case a.pool <- conn:
+	default:
+		_ = conn.Close()
+	}
+}
+
+func expandLDAPFilter(filter string, username string, dn string) string {
+	return strings.NewReplacer("{username}", ldap.EscapeFilter(username), "{dn}", ldap.EscapeFilter(dn)).Replace(filter)
+}
+
+func (a *LDAPAuthenticator) findUser(conn ldapConn, username string) (*ldap.Entry, error) {
+	// 1.1 requests no attributes at all, we only need the DN
+	attributes := []string{"1.1"}
+	if a.UsernameAttribute != "" {
+		attributes = []string{a.UsernameAttribute}
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.Timeout.Seconds()), false,
+		expandLDAPFilter(a.UserFilter, username, ""), attributes, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		return nil, nil
+	}
+	return res.Entries[0], nil
+}
+
+func (a *LDAPAuthenticator) findGroups(conn ldapConn, username string, dn string) ([]string, []string, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(a.Timeout.Seconds()), false,
+		expandLDAPFilter(a.GroupFilter, username, dn), []string{a.GroupNameAttribute}, nil,
+	))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(res.Entries))
+	dns := make([]string, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		dns = append(dns, entry.DN)
+		name := entry.GetAttributeValue(a.GroupNameAttribute)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, dns, nil
+}
+
+// inRequiredGroup checks group names and DNs against RequiredGroups, DNs are compared case-insensitively
+func (a *LDAPAuthenticator) inRequiredGroup(names []string, dns []string) bool {
+	if len(a.RequiredGroups) == 0 {
+		return true
+	}
+	for _, required := range a.RequiredGroups {
+		for _, name := range names {
+			if name == required {
+				return true
+			}
+		}
+		for _, dn := range dns {
+			if strings.EqualFold(dn, required) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type ldapAuthResult struct {
+	ok       bool
+	username string
+	groups   []string
+}
+
+// check does the search and bind on one connection, which is only put back into the pool if it could be bound
+// as the service account again
+func (a *LDAPAuthenticator) check(conn ldapConn, username string, password string) (*ldapAuthResult, error) {
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		log.Printf("ldap: user %s not found or not unique", username)
+		return &ldapAuthResult{}, nil
+	}
+
+	var groupNames, groupDNs []string
+	if a.GroupBaseDN != "" {
+		groupNames, groupDNs, err = a.findGroups(conn, username, entry.DN)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = conn.Bind(entry.DN, password)
+	rebindErr := a.bindService(conn)
+	if rebindErr != nil {
+		_ = conn.Close()
+	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return &ldapAuthResult{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.inRequiredGroup(groupNames, groupDNs) {
+		log.Printf("ldap: user %s is not in any of the required groups", username)
+		return &ldapAuthResult{}, nil
+	}
+
+	if a.UsernameAttribute != "" {
+		username = entry.GetAttributeValue(a.UsernameAttribute)
+		if username == "" {
+			log.Printf("ldap: entry %s has no %s attribute", entry.DN, a.UsernameAttribute)
+			return &ldapAuthResult{}, nil
+		}
+	}
+
+	return &ldapAuthResult{
+		ok:       true,
+		username: username,
+		groups:   groupNames,
+	}, nil
+}
+
+func (a *LDAPAuthenticator) Authenticate(r *http.Request, w http.ResponseWriter) (AuthResult, string) {
+	result, username, _ := a.AuthenticateWithDetails(r, w)
+	return result, username
+}
+
+func (a *LDAPAuthenticator) AuthenticateWithDetails(r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails) {
+	username, password, ok := r.BasicAuth()
+	// An empty password would make the bind an unauthenticated one, which most servers accept
+	if !ok || username == "" || password == "" {
+		respondWWWAuthenticateBasic(w)
+		return AuthFailedDefault, "", nil
+	}
+
+	var result *ldapAuthResult
+	var err error
+	// Pooled connections might have been closed by the server in the meantime, so retry once on a fresh one
+	for try := 0; try < 2; try++ {
+		var conn ldapConn
+		conn, err = a.getConn()
+		if err != nil {
+			break
+		}
+		result, err = a.check(conn, username, password)
+		if err == nil || !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			a.putConn(conn)
+			break
+		}
+		_ = conn.Close()
+	}
+
+	if err != nil {
+		log.Printf("ldap error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return AuthFailedCustom, "", nil
+	}
+
+	if !result.ok {
+		respondWWWAuthenticateBasic(w)
+		return AuthFailedDefault, "", nil
+	}
+	return AuthOk, result.username, &AuthDetails{Groups: result.groups}
+}
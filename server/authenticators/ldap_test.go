@@ -0,0 +1,354 @@
+package authenticators
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const testLDAPServiceDN = "cn=wsvpn,ou=services,dc=example,dc=com"
+const testLDAPServicePassword = "service-secret"
+
+type testLDAPUser struct {
+	uid      string
+	password string
+	attrs    map[string]string
+}
+
+type testLDAPGroup struct {
+	cn      string
+	members []string // DNs of members
+}
+
+// testLDAPDirectory is an in-process stand-in for an LDAP server, it evaluates the filters by
+// rendering them for every candidate entry, so it only understands the filters from the config
+type testLDAPDirectory struct {
+	lock   sync.Mutex
+	users  map[string]*testLDAPUser // by DN
+	groups map[string]*testLDAPGroup
+	conns  []*testLDAPConn
+	dials  int
+	ops    []string
+}
+
+type testLDAPConn struct {
+	dir     *testLDAPDirectory
+	closed  bool
+	dropped bool
+	boundDN string
+}
+
+func newTestLDAPDirectory() *testLDAPDirectory {
+	return &testLDAPDirectory{
+		users: map[string]*testLDAPUser{
+			"uid=alice,ou=people,dc=example,dc=com": {uid: "alice", password: "alice-pw", attrs: map[string]string{"mail": "alice@example.com"}},
+			"uid=bob,ou=people,dc=example,dc=com":   {uid: "bob", password: "bob-pw"},
+		},
+		groups: map[string]*testLDAPGroup{
+			"cn=vpn,ou=groups,dc=example,dc=com":    {cn: "vpn", members: []string{"uid=alice,ou=people,dc=example,dc=com"}},
+			"cn=admins,ou=groups,dc=example,dc=com": {cn: "admins", members: []string{"uid=alice,ou=people,dc=example,dc=com"}},
+			"cn=guests,ou=groups,dc=example,dc=com": {cn: "guests", members: []string{"uid=bob,ou=people,dc=example,dc=com"}},
+		},
+	}
+}
+
+func (d *testLDAPDirectory) dial() (ldapConn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.dials++
+	conn := &testLDAPConn{dir: d}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+func (d *testLDAPDirectory) log(op string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.ops = append(d.ops, op)
+}
+
+// dropAll simulates the server closing all idle connections without the client noticing yet
+func (d *testLDAPDirectory) dropAll() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, conn := range d.conns {
+		conn.dropped = true
+	}
+}
+
+func (c *testLDAPConn) checkUsable() error {
+	if c.closed || c.dropped {
+		return ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed"))
+	}
+	return nil
+}
+
+func (c *testLDAPConn) Bind(username, password string) error {
+	err := c.checkUsable()
+	if err != nil {
+		return err
+	}
+	c.dir.log("bind " + username)
+
+	if username == testLDAPServiceDN && password == testLDAPServicePassword {
+		c.boundDN = username
+		return nil
+	}
+	user := c.dir.users[username]
+	if user == nil || password == "" || user.password != password {
+		c.boundDN = ""
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	c.boundDN = username
+	return nil
+}
+
+func (c *testLDAPConn) UnauthenticatedBind(username string) error {
+	err := c.checkUsable()
+	if err != nil {
+		return err
+	}
+	c.boundDN = ""
+	return nil
+}
+
+func (c *testLDAPConn) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	err := c.checkUsable()
+	if err != nil {
+		return nil, err
+	}
+	if c.boundDN != testLDAPServiceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("not bound as service account"))
+	}
+	c.dir.log("search " + searchRequest.BaseDN)
+
+	res := &ldap.SearchResult{}
+	switch searchRequest.BaseDN {
+	case "ou=people,dc=example,dc=com":
+		for dn, user := range c.dir.users {
+			if searchRequest.Filter != expandLDAPFilter(defaultLDAPUserFilter, user.uid, "") {
+				continue
+			}
+			entry := &ldap.Entry{DN: dn}
+			for _, attr := range searchRequest.Attributes {
+				if value, ok := user.attrs[attr]; ok {
+					entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute(attr, []string{value}))
+				}
+			}
+			res.Entries = append(res.Entries, entry)
+		}
+	case "ou=groups,dc=example,dc=com":
+		for dn, group := range c.dir.groups {
+			for _, member := range group.members {
+				uid := c.dir.users[member].uid
+				if searchRequest.Filter == expandLDAPFilter(defaultLDAPGroupFilter, uid, member) {
+					res.Entries = append(res.Entries, ldap.NewEntry(dn, map[string][]string{"cn": {group.cn}}))
+					break
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func (c *testLDAPConn) IsClosing() bool {
+	return c.closed
+}
+
+func (c *testLDAPConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newTestLDAPAuthenticator(t *testing.T, config string) (*LDAPAuthenticator, *testLDAPDirectory) {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "ldap.yml")
+	err := os.WriteFile(configFile, []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &LDAPAuthenticator{}
+	err = a.loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	dir := newTestLDAPDirectory()
+	err = a.connect(dir.dial)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	return a, dir
+}
+
+const testLDAPConfig = `
+url: ldap://ldap.example.com
+bind-dn: cn=wsvpn,ou=services,dc=example,dc=com
+bind-password: service-secret
+base-dn: ou=people,dc=example,dc=com
+group-base-dn: ou=groups,dc=example,dc=com
+pool-size: 1
+`
+
+func ldapLogin(a *LDAPAuthenticator, username string, password string) (AuthResult, string, *AuthDetails, *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if username != "" || password != "" {
+		r.SetBasicAuth(username, password)
+	}
+	w := httptest.NewRecorder()
+	result, authUsername, details := a.AuthenticateWithDetails(r, w)
+	return result, authUsername, details, w
+}
+
+func TestLDAPSearchThenBind(t *testing.T) {
+	a, dir := newTestLDAPAuthenticator(t, testLDAPConfig)
+	dir.ops = nil
+
+	result, username, details, _ := ldapLogin(a, "alice", "alice-pw")
+	if result != AuthOk || username != "alice" {
+		t.Fatalf("expected alice to be accepted, got %v %q", result, username)
+	}
+
+	groups := map[string]bool{}
+	for _, group := range details.Groups {
+		groups[group] = true
+	}
+	if !reflect.DeepEqual(groups, map[string]bool{"vpn": true, "admins": true}) {
+		t.Errorf("unexpected groups %v", details.Groups)
+	}
+
+	expectedOps := []string{
+		"search ou=people,dc=example,dc=com",
+		"search ou=groups,dc=example,dc=com",
+		"bind uid=alice,ou=people,dc=example,dc=com",
+		"bind " + testLDAPServiceDN,
+	}
+	if !reflect.DeepEqual(dir.ops, expectedOps) {
+		t.Errorf("expected operations %v, got %v", expectedOps, dir.ops)
+	}
+}
+
+func TestLDAPUsernameAttribute(t *testing.T) {
+	a, _ := newTestLDAPAuthenticator(t, testLDAPConfig+"username-attribute: mail\n")
+
+	result, username, _, _ := ldapLogin(a, "alice", "alice-pw")
+	if result != AuthOk || username != "alice@example.com" {
+		t.Fatalf("expected alice@example.com to be accepted, got %v %q", result, username)
+	}
+
+	// bob has no mail attribute
+	result, _, _, _ = ldapLogin(a, "bob", "bob-pw")
+	if result != AuthFailedDefault {
+		t.Fatalf("expected bob to be rejected, got %v", result)
+	}
+}
+
+func TestLDAPInvalidCredentials(t *testing.T) {
+	a, _ := newTestLDAPAuthenticator(t, testLDAPConfig)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "bob-pw"},
+		{"unknown user", "mallory", "alice-pw"},
+		{"empty password", "alice", ""},
+		{"no credentials", "", ""},
+		{"filter injection", "*", "alice-pw"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, username, _, w := ldapLogin(a, test.username, test.password)
+			if result != AuthFailedDefault || username != "" {
+				t.Fatalf("expected rejection, got %v %q", result, username)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("expected WWW-Authenticate header")
+			}
+		})
+	}
+
+	// The connection has to be usable for the next client after a failed bind
+	result, _, _, _ := ldapLogin(a, "alice", "alice-pw")
+	if result != AuthOk {
+		t.Fatalf("expected alice to be accepted after failed logins, got %v", result)
+	}
+}
+
+func TestLDAPRequiredGroups(t *testing.T) {
+	a, _ := newTestLDAPAuthenticator(t, testLDAPConfig+"required-groups: [vpn, \"CN=Guests,OU=Groups,DC=example,DC=com\"]\n")
+
+	result, _, _, _ := ldapLogin(a, "alice", "alice-pw")
+	if result != AuthOk {
+		t.Errorf("expected alice (in vpn by name) to be accepted, got %v", result)
+	}
+	result, _, _, _ = ldapLogin(a, "bob", "bob-pw")
+	if result != AuthOk {
+		t.Errorf("expected bob (in guests by DN) to be accepted, got %v", result)
+	}
+
+	a, _ = newTestLDAPAuthenticator(t, testLDAPConfig+"required-groups: [admins]\n")
+	result, _, _, _ = ldapLogin(a, "bob", "bob-pw")
+	if result != AuthFailedDefault {
+		t.Errorf("expected bob (not in admins) to be rejected, got %v", result)
+	}
+}
+
+func TestLDAPPoolReuse(t *testing.T) {
+	a, dir := newTestLDAPAuthenticator(t, testLDAPConfig)
+
+	for i := 0; i < 3; i++ {
+		result, _, _, _ := ldapLogin(a, "alice", "alice-pw")
+		if result != AuthOk {
+			t.Fatalf("login %d: expected alice to be accepted, got %v", i, result)
+		}
+	}
+	if dir.dials != 1 {
+		t.Fatalf("expected the pooled connection to be reused, got %d dials", dir.dials)
+	}
+
+	// The server dropped the idle connection, the next login has to retry on a new one
+	dir.dropAll()
+	result, _, _, _ := ldapLogin(a, "alice", "alice-pw")
+	if result != AuthOk {
+		t.Fatalf("expected alice to be accepted after a dropped connection, got %v", result)
+	}
+	if dir.dials != 2 {
+		t.Fatalf("expected exactly one new connection, got %d dials", dir.dials)
+	}
+
+	result, _, _, _ = ldapLogin(a, "alice", "alice-pw")
+	if result != AuthOk || dir.dials != 2 {
+		t.Fatalf("expected the new connection to be reused, got %v with %d dials", result, dir.dials)
+	}
+}
+
+func TestLDAPServiceBindFailure(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "ldap.yml")
+	err := os.WriteFile(configFile, []byte(strings.Replace(testLDAPConfig, testLDAPServicePassword, "wrong", 1)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &LDAPAuthenticator{}
+	err = a.loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	err = a.connect(newTestLDAPDirectory().dial)
+	if err == nil {
+		t.Fatal("expected connect to fail with a wrong service password")
+	}
+}
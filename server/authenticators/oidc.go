@@ -0,0 +1,173 @@
+package authenticators
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Doridian/wsvpn/server/jwks"
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+var defaultOIDCAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// OIDCAuthenticator accepts JWTs (for example OpenID Connect ID or access tokens) sent as "Authorization: Bearer TOKEN"
+type OIDCAuthenticator struct {
+	JWKS          string        `yaml:"jwks"`
+	JWKSRefresh   time.Duration `yaml:"jwks-refresh"`
+	Issuer        string        `yaml:"issuer"`
+	Audience      []string      `yaml:"audience"`
+	UsernameClaim string        `yaml:"username-claim"`
+	GroupsClaim   string        `yaml:"groups-claim"`
+	Algorithms    []string      `yaml:"algorithms"`
+	Leeway        time.Duration `yaml:"leeway"`
+
+	jwks   *jwks.Cache
+	parser *jwt.Parser
+}
+
+var _ DetailsAuthenticator = &OIDCAuthenticator{}
+
+func (a *OIDCAuthenticator) Name() string {
+	return "oidc"
+}
+
+func (a *OIDCAuthenticator) Load(configFile string) error {
+	fh, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fh.Close()
+	}()
+
+	a.JWKSRefresh = time.Hour
+	a.UsernameClaim = "sub"
+	a.Leeway = 30 * time.Second
+	err = yaml.NewDecoder(fh).Decode(a)
+	if err != nil {
+		return err
+	}
+
+	if a.JWKS == "" {
+		return errors.New("oidc: jwks must be set")
+	}
+	if a.Issuer == "" {
+		return errors.New("oidc: issuer must be set")
+	}
+	if len(a.Audience) == 0 {
+		return errors.New("oidc: audience must be set")
+	}
+	if len(a.Algorithms) == 0 {
+		a.Algorithms = defaultOIDCAlgorithms
+	}
+	for _, alg := range a.Algorithms {
+		if strings.HasPrefix(alg, "HS") || alg == "none" {
+			return fmt.Errorf("oidc: algorithm %s is not allowed, only public key algorithms are", alg)
+		}
+	}
+
+	a.jwks = jwks.NewCache(a.JWKS, a.JWKSRefresh)
+	err = a.jwks.Load()
+	if err != nil {
+		return fmt.Errorf("oidc: loading JWKS: %v", err)
+	}
+
+	a.parser = jwt.NewParser(
+		jwt.WithValidMethods(a.Algorithms),
+		jwt.WithIssuer(a.Issuer),
+		jwt.WithAudience(a.Audience...),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(a.Leeway),
+	)
+	return nil
+}
+
+func respondWWWAuthenticateBearer(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", "Bearer")
+}
+
+// lookupClaim finds a claim by its name or, if there is none with that name, by a dot separated path into nested objects
+func lookupClaim(claims jwt.MapClaims, name string) interface{} {
+	value, ok := claims[name]
+	if ok {
+		return value
+	}
+
+	var current interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(name, ".") {
+		currentMap, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = currentMap[part]
+	}
+	return current
+}
+
+func claimToStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if ok {
+				res = append(res, str)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func (a *OIDCAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	return a.jwks.GetKeys(kid)
+}
+
+func (a *OIDCAuthenticator) Authenticate(r *http.Request, w http.ResponseWriter) (AuthResult, string) {
+	result, username, _ := a.AuthenticateWithDetails(r, w)
+	return result, username
+}
+
+func (a *OIDCAuthenticator) AuthenticateWithDetails(r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails) {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 || !strings.EqualFold(authHeader[:7], "Bearer ") {
+		respondWWWAuthenticateBearer(w)
+		return AuthFailedDefault, "", nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(authHeader[7:], claims, a.keyFunc)
+	if err != nil {
+		log.Printf("oidc: invalid token: %v", err)
+		respondWWWAuthenticateBearer(w)
+		return AuthFailedDefault, "", nil
+	}
+
+	username, _ := lookupClaim(claims, a.UsernameClaim).(string)
+	if username == "" {
+		log.Printf("oidc: token has no %s claim", a.UsernameClaim)
+		respondWWWAuthenticateBearer(w)
+		return AuthFailedDefault, "", nil
+	}
+
+	details := &AuthDetails{}
+	if a.GroupsClaim != "" {
+		details.Groups = claimToStrings(lookupClaim(claims, a.GroupsClaim))
+	}
+
+	return AuthOk, username, details
+}
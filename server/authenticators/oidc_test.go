@@ -0,0 +1,290 @@
+package authenticators
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Doridian/wsvpn/server/jwks"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testOIDCIssuer = "https://idp.example.com"
+const testOIDCAudience = "wsvpn"
+
+type testOIDCKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+func newTestOIDCKeys(t *testing.T) []*testOIDCKey {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []*testOIDCKey{
+		{kid: "rsa", method: jwt.SigningMethodRS256, key: rsaKey},
+		{kid: "ec", method: jwt.SigningMethodES256, key: ecKey},
+		{kid: "ed25519", method: jwt.SigningMethodEdDSA, key: edKey},
+	}
+}
+
+// newTestOIDCAuthenticator writes the public keys as a JWKS file and loads the authenticator with it
+func newTestOIDCAuthenticator(t *testing.T, keys []*testOIDCKey, config string) (*OIDCAuthenticator, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	pubKeys := make(map[string]crypto.PublicKey, len(keys))
+	for _, key := range keys {
+		pubKeys[key.kid] = key.key.Public()
+	}
+	jwksData, err := jwks.Marshal(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(dir, "jwks.json")
+	err = os.WriteFile(jwksFile, jwksData, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "oidc.yml")
+	err = os.WriteFile(configFile, []byte("jwks: "+jwksFile+"\n"+config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &OIDCAuthenticator{}
+	return a, a.Load(configFile)
+}
+
+const testOIDCConfig = `
+issuer: https://idp.example.com
+audience: [wsvpn]
+groups-claim: realm_access.roles
+leeway: 0s
+`
+
+func testOIDCClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": testOIDCIssuer,
+		"aud": testOIDCAudience,
+		"sub": "alice",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+		"realm_access": map[string]interface{}{
+			"roles": []string{"vpn", "admins"},
+		},
+	}
+}
+
+func signTestOIDCToken(t *testing.T, key *testOIDCKey, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func oidcLogin(a *OIDCAuthenticator, token string) (AuthResult, string, *AuthDetails, *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	result, username, details := a.AuthenticateWithDetails(r, w)
+	return result, username, details, w
+}
+
+func TestOIDCValidTokens(t *testing.T) {
+	keys := newTestOIDCKeys(t)
+	a, err := newTestOIDCAuthenticator(t, keys, testOIDCConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range keys {
+		t.Run(key.method.Alg(), func(t *testing.T) {
+			result, username, details, _ := oidcLogin(a, signTestOIDCToken(t, key, testOIDCClaims()))
+			if result != AuthOk || username != "alice" {
+				t.Fatalf("expected alice to be accepted, got %v %q", result, username)
+			}
+			if !reflect.DeepEqual(details.Groups, []string{"vpn", "admins"}) {
+				t.Errorf("unexpected groups %v", details.Groups)
+			}
+		})
+	}
+
+	// Tokens without a key ID are checked against all keys
+	token := jwt.NewWithClaims(keys[1].method, testOIDCClaims())
+	signed, err := token.SignedString(keys[1].key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, _, _ := oidcLogin(a, signed)
+	if result != AuthOk {
+		t.Errorf("expected a token without kid to be accepted, got %v", result)
+	}
+}
+
+func TestOIDCInvalidTokens(t *testing.T) {
+	keys := newTestOIDCKeys(t)
+	a, err := newTestOIDCAuthenticator(t, keys, testOIDCConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKeys := newTestOIDCKeys(t)
+
+	withClaim := func(name string, value interface{}) jwt.MapClaims {
+		claims := testOIDCClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong issuer", signTestOIDCToken(t, keys[0], withClaim("iss", "https://evil.example.com"))},
+		{"no issuer", signTestOIDCToken(t, keys[0], withClaim("iss", nil))},
+		{"wrong audience", signTestOIDCToken(t, keys[0], withClaim("aud", "other-app"))},
+		{"no audience", signTestOIDCToken(t, keys[0], withClaim("aud", nil))},
+		{"expired", signTestOIDCToken(t, keys[0], withClaim("exp", time.Now().Add(-time.Minute).Unix()))},
+		{"no expiry", signTestOIDCToken(t, keys[0], withClaim("exp", nil))},
+		{"not yet valid", signTestOIDCToken(t, keys[0], withClaim("nbf", time.Now().Add(time.Hour).Unix()))},
+		{"no subject", signTestOIDCToken(t, keys[0], withClaim("sub", nil))},
+		{"unknown key", signTestOIDCToken(t, &testOIDCKey{kid: "rsa", method: otherKeys[0].method, key: otherKeys[0].key}, testOIDCClaims())},
+		{"unknown key ID", signTestOIDCToken(t, &testOIDCKey{kid: "other", method: keys[0].method, key: keys[0].key}, testOIDCClaims())},
+		{"garbage", "not.a.token"},
+		{"empty", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, username, _, w := oidcLogin(a, test.token)
+			if result != AuthFailedDefault || username != "" {
+				t.Fatalf("expected rejection, got %v %q", result, username)
+			}
+			if w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("expected WWW-Authenticate: Bearer, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestOIDCAlgorithms(t *testing.T) {
+	keys := newTestOIDCKeys(t)
+	a, err := newTestOIDCAuthenticator(t, keys, testOIDCConfig+"algorithms: [ES256]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, _, _, _ := oidcLogin(a, signTestOIDCToken(t, keys[1], testOIDCClaims()))
+	if result != AuthOk {
+		t.Fatalf("expected ES256 to be accepted, got %v", result)
+	}
+	result, _, _, _ = oidcLogin(a, signTestOIDCToken(t, keys[0], testOIDCClaims()))
+	if result != AuthFailedDefault {
+		t.Fatalf("expected RS256 to be rejected, got %v", result)
+	}
+
+	// An HMAC token keyed with public key material must never be accepted
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, testOIDCClaims())
+	hmacToken.Header["kid"] = keys[2].kid
+	signed, err := hmacToken.SignedString([]byte(keys[2].key.Public().(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, _, _ = oidcLogin(a, signed)
+	if result != AuthFailedDefault {
+		t.Fatalf("expected HS256 to be rejected, got %v", result)
+	}
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, testOIDCClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, _, _, _ = oidcLogin(a, unsigned)
+	if result != AuthFailedDefault {
+		t.Fatalf("expected an unsigned token to be rejected, got %v", result)
+	}
+}
+
+func TestOIDCLoadErrors(t *testing.T) {
+	keys := newTestOIDCKeys(t)
+
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"no issuer", "audience: [wsvpn]\n", "issuer must be set"},
+		{"no audience", "issuer: https://idp.example.com\n", "audience must be set"},
+		{"HMAC algorithm", testOIDCConfig + "algorithms: [RS256, HS256]\n", "algorithm HS256 is not allowed"},
+		{"none algorithm", testOIDCConfig + "algorithms: [none]\n", "algorithm none is not allowed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newTestOIDCAuthenticator(t, keys, test.config)
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Fatalf("expected error containing %q, got %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestOIDCUsernameClaim(t *testing.T) {
+	keys := newTestOIDCKeys(t)
+	a, err := newTestOIDCAuthenticator(t, keys, testOIDCConfig+"username-claim: preferred_username\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := testOIDCClaims()
+	claims["preferred_username"] = "alice.smith"
+	result, username, _, _ := oidcLogin(a, signTestOIDCToken(t, keys[0], claims))
+	if result != AuthOk || username != "alice.smith" {
+		t.Fatalf("expected alice.smith to be accepted, got %v %q", result, username)
+	}
+
+	// sub is not used as a fallback
+	result, _, _, _ = oidcLogin(a, signTestOIDCToken(t, keys[0], testOIDCClaims()))
+	if result != AuthFailedDefault {
+		t.Fatalf("expected a token without preferred_username to be rejected, got %v", result)
+	}
+}
@@ -2,22 +2,54 @@ package authenticators
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
 )
 
+// RadiusChallengeHeader carries the ID of a pending Access-Challenge, the client has to send it back
+// along with the response to the challenge (such as an OTP) as the password
+const RadiusChallengeHeader = "X-WSVPN-Challenge"
+
+type radiusChallenge struct {
+	username string
+	state    []byte
+	expires  time.Time
+}
+
 type RadiusAuthenticator struct {
-	Server string `yaml:"server"`
-	Secret string `yaml:"secret"`
+	Server           string        `yaml:"server"`
+	Secret           string        `yaml:"secret"`
+	Timeout          time.Duration `yaml:"timeout"`
+	NASIdentifier    string        `yaml:"nas-identifier"`
+	ChallengeTimeout time.Duration `yaml:"challenge-timeout"`
+
+	AccountingServer   string        `yaml:"accounting-server"`
+	AccountingSecret   string        `yaml:"accounting-secret"`
+	AccountingInterval time.Duration `yaml:"accounting-interval"`
+
+	challengesLock sync.Mutex
+	challenges     map[string]*radiusChallenge
 }
 
-var _ Authenticator = &RadiusAuthenticator{}
+var _ DetailsAuthenticator = &RadiusAuthenticator{}
+
+func (a *RadiusAuthenticator) Name() string {
+	return "radius"
+}
 
 func (a *RadiusAuthenticator) Load(configFile string) error {
 	fh, err := os.Open(configFile)
@@ -27,43 +59,319 @@ func (a *RadiusAuthenticator) Load(configFile string) error {
 	defer func() {
 		_ = fh.Close()
 	}()
-	return yaml.NewDecoder(fh).Decode(a)
+
+	a.Timeout = 10 * time.Second
+	a.NASIdentifier = "wsvpn"
+	a.ChallengeTimeout = 2 * time.Minute
+	err = yaml.NewDecoder(fh).Decode(a)
+	if err != nil {
+		return err
+	}
+
+	if a.AccountingSecret == "" {
+		a.AccountingSecret = a.Secret
+	}
+	a.challenges = make(map[string]*radiusChallenge)
+	return nil
+}
+
+func (a *RadiusAuthenticator) exchange(packet *radius.Packet, server string) (*radius.Packet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.Timeout)
+	defer cancel()
+	return radius.Exchange(ctx, packet, server)
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func (a *RadiusAuthenticator) addNASAttributes(packet *radius.Packet, remoteAddr string) error {
+	err := rfc2865.NASIdentifier_SetString(packet, a.NASIdentifier)
+	if err != nil {
+		return err
+	}
+	err = rfc2865.NASPortType_Set(packet, rfc2865.NASPortType_Value_Virtual)
+	if err != nil {
+		return err
+	}
+	return rfc2865.CallingStationID_SetString(packet, remoteHost(remoteAddr))
+}
+
+// addChallenge remembers the State of an Access-Challenge and returns the ID the client has to send back
+func (a *RadiusAuthenticator) addChallenge(username string, state []byte) (string, error) {
+	idBytes := make([]byte, 16)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes)
+
+	a.challengesLock.Lock()
+	defer a.challengesLock.Unlock()
+
+	now := time.Now()
+	for otherID, challenge := range a.challenges {
+		if now.After(challenge.expires) {
+			delete(a.challenges, otherID)
+		}
+	}
+
+	a.challenges[id] = &radiusChallenge{
+		username: username,
+		state:    state,
+		expires:  now.Add(a.ChallengeTimeout),
+	}
+	return id, nil
+}
+
+// takeChallenge returns the State of a pending challenge, every challenge can only be answered once
+func (a *RadiusAuthenticator) takeChallenge(id string, username string) []byte {
+	a.challengesLock.Lock()
+	defer a.challengesLock.Unlock()
+
+	challenge := a.challenges[id]
+	if challenge == nil {
+		return nil
+	}
+	delete(a.challenges, id)
+
+	if challenge.username != username || time.Now().After(challenge.expires) {
+		return nil
+	}
+	return challenge.state
 }
 
 func (a *RadiusAuthenticator) Authenticate(r *http.Request, w http.ResponseWriter) (AuthResult, string) {
+	result, username, _ := a.AuthenticateWithDetails(r, w)
+	return result, username
+}
+
+func (a *RadiusAuthenticator) AuthenticateWithDetails(r *http.Request, w http.ResponseWriter) (AuthResult, string, *AuthDetails) {
 	username, password, ok := r.BasicAuth()
 	if !ok {
 		respondWWWAuthenticateBasic(w)
-		return AuthFailedDefault, ""
+		return AuthFailedDefault, "", nil
 	}
 
 	packet := radius.New(radius.CodeAccessRequest, []byte(a.Secret))
 	err := rfc2865.UserName_SetString(packet, username)
 	if err != nil {
 		respondWWWAuthenticateBasic(w)
-		return AuthFailedDefault, ""
+		return AuthFailedDefault, "", nil
 	}
 	err = rfc2865.UserPassword_SetString(packet, password)
 	if err != nil {
 		respondWWWAuthenticateBasic(w)
-		return AuthFailedDefault, ""
+		return AuthFailedDefault, "", nil
+	}
+	err = a.addNASAttributes(packet, r.RemoteAddr)
+	if err != nil {
+		log.Printf("radius packet error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return AuthFailedCustom, "", nil
+	}
+
+	challengeID := r.Header.Get(RadiusChallengeHeader)
+	if challengeID != "" {
+		state := a.takeChallenge(challengeID, username)
+		if state == nil {
+			http.Error(w, "Unknown or expired challenge", http.StatusUnauthorized)
+			return AuthFailedCustom, "", nil
+		}
+		err = rfc2865.State_Set(packet, state)
+		if err != nil {
+			respondWWWAuthenticateBasic(w)
+			return AuthFailedDefault, "", nil
+		}
 	}
-	response, err := radius.Exchange(context.Background(), packet, a.Server)
+
+	response, err := a.exchange(packet, a.Server)
 	if err != nil {
 		log.Printf("radius exchange error: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return AuthFailedCustom, ""
+		return AuthFailedCustom, "", nil
 	}
 
 	if response.Code == radius.CodeAccessChallenge {
-		http.Error(w, "Access challenge not implemented", http.StatusUnauthorized)
-		return AuthFailedCustom, ""
+		return a.respondChallenge(w, username, response), "", nil
 	}
 
 	if response.Code != radius.CodeAccessAccept {
 		respondWWWAuthenticateBasic(w)
-		return AuthFailedDefault, ""
+		return AuthFailedDefault, "", nil
+	}
+
+	return AuthOk, username, a.makeDetails(username, response)
+}
+
+func (a *RadiusAuthenticator) respondChallenge(w http.ResponseWriter, username string, response *radius.Packet) AuthResult {
+	id, err := a.addChallenge(username, rfc2865.State_Get(response))
+	if err != nil {
+		log.Printf("radius challenge error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return AuthFailedCustom
+	}
+
+	message := "Challenge"
+	replyMessages, err := rfc2865.ReplyMessage_GetStrings(response)
+	if err == nil && len(replyMessages) > 0 {
+		message = strings.Join(replyMessages, "\n")
+	}
+
+	respondWWWAuthenticateBasic(w)
+	w.Header().Set(RadiusChallengeHeader, id)
+	http.Error(w, message, http.StatusUnauthorized)
+	return AuthFailedCustom
+}
+
+// makeDetails takes Framed-IP-Address, Session-Timeout, Filter-Id (as groups) and the attributes needed
+// for accounting from an Access-Accept
+func (a *RadiusAuthenticator) makeDetails(username string, response *radius.Packet) *AuthDetails {
+	details := &AuthDetails{
+		FramedIP:       rfc2865.FramedIPAddress_Get(response),
+		SessionTimeout: time.Duration(rfc2865.SessionTimeout_Get(response)) * time.Second,
+	}
+
+	filterIDs, err := rfc2865.FilterID_GetStrings(response)
+	if err == nil {
+		details.Groups = filterIDs
+	}
+
+	if a.AccountingServer != "" {
+		classes, _ := rfc2865.Class_Gets(response)
+		details.Accounting = &radiusAccounting{
+			authenticator: a,
+			username:      username,
+			classes:       classes,
+		}
+		details.AccountingInterval = a.AccountingInterval
+		interimInterval := rfc2869.AcctInterimInterval_Get(response)
+		if interimInterval > 0 {
+			details.AccountingInterval = time.Duration(interimInterval) * time.Second
+		}
 	}
 
-	return AuthOk, username
+	return details
+}
+
+type radiusAccounting struct {
+	authenticator *RadiusAuthenticator
+	username      string
+	classes       [][]byte
+}
+
+var _ Accounting = &radiusAccounting{}
+
+func (c *radiusAccounting) Start(record *AccountingRecord) {
+	c.send(rfc2866.AcctStatusType_Value_Start, record)
+}
+
+func (c *radiusAccounting) Interim(record *AccountingRecord) {
+	c.send(rfc2866.AcctStatusType_Value_InterimUpdate, record)
+}
+
+func (c *radiusAccounting) Stop(record *AccountingRecord) {
+	c.send(rfc2866.AcctStatusType_Value_Stop, record)
+}
+
+func (c *radiusAccounting) send(statusType rfc2866.AcctStatusType, record *AccountingRecord) {
+	a := c.authenticator
+	packet := radius.New(radius.CodeAccountingRequest, []byte(a.AccountingSecret))
+
+	err := c.fillPacket(packet, statusType, record)
+	if err != nil {
+		log.Printf("radius accounting packet error: %v", err)
+		return
+	}
+
+	response, err := a.exchange(packet, a.AccountingServer)
+	if err != nil {
+		log.Printf("radius accounting %s for session %s failed: %v", statusType, record.SessionID, err)
+		return
+	}
+	if response.Code != radius.CodeAccountingResponse {
+		log.Printf("radius accounting %s for session %s got unexpected response %s", statusType, record.SessionID, response.Code)
+	}
+}
+
+func (c *radiusAccounting) fillPacket(packet *radius.Packet, statusType rfc2866.AcctStatusType, record *AccountingRecord) error {
+	err := rfc2866.AcctStatusType_Set(packet, statusType)
+	if err != nil {
+		return err
+	}
+	err = rfc2866.AcctSessionID_SetString(packet, record.SessionID)
+	if err != nil {
+		return err
+	}
+	err = rfc2866.AcctAuthentic_Set(packet, rfc2866.AcctAuthentic_Value_RADIUS)
+	if err != nil {
+		return err
+	}
+	err = rfc2865.UserName_SetString(packet, c.username)
+	if err != nil {
+		return err
+	}
+	for _, class := range c.classes {
+		err = rfc2865.Class_Add(packet, class)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.authenticator.addNASAttributes(packet, record.RemoteAddr)
+	if err != nil {
+		return err
+	}
+	if record.VPNIP.To4() != nil {
+		err = rfc2865.FramedIPAddress_Set(packet, record.VPNIP)
+		if err != nil {
+			return err
+		}
+	}
+
+	if statusType == rfc2866.AcctStatusType_Value_Start {
+		return nil
+	}
+
+	err = rfc2866.AcctSessionTime_Set(packet, rfc2866.AcctSessionTime(time.Since(record.Started)/time.Second))
+	if err != nil {
+		return err
+	}
+	// Octet counters only have 32 bits, the gigawords attributes hold how often they wrapped around
+	err = rfc2866.AcctInputOctets_Set(packet, rfc2866.AcctInputOctets(uint32(record.BytesIn)))
+	if err != nil {
+		return err
+	}
+	err = rfc2869.AcctInputGigawords_Set(packet, rfc2869.AcctInputGigawords(uint32(record.BytesIn>>32)))
+	if err != nil {
+		return err
+	}
+	err = rfc2866.AcctOutputOctets_Set(packet, rfc2866.AcctOutputOctets(uint32(record.BytesOut)))
+	if err != nil {
+		return err
+	}
+	err = rfc2869.AcctOutputGigawords_Set(packet, rfc2869.AcctOutputGigawords(uint32(record.BytesOut>>32)))
+	if err != nil {
+		return err
+	}
+	err = rfc2866.AcctInputPackets_Set(packet, rfc2866.AcctInputPackets(uint32(record.PacketsIn)))
+	if err != nil {
+		return err
+	}
+	err = rfc2866.AcctOutputPackets_Set(packet, rfc2866.AcctOutputPackets(uint32(record.PacketsOut)))
+	if err != nil {
+		return err
+	}
+
+	if statusType != rfc2866.AcctStatusType_Value_Stop {
+		return nil
+	}
+	terminateCause := rfc2866.AcctTerminateCause_Value_UserRequest
+	if record.TerminateCause == TerminateSessionTimeout {
+		terminateCause = rfc2866.AcctTerminateCause_Value_SessionTimeout
+	}
+	return rfc2866.AcctTerminateCause_Set(packet, terminateCause)
 }
@@ -0,0 +1,322 @@
+package authenticators
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+const testRadiusSecret = "radius-secret"
+const testRadiusOTP = "123456"
+
+var testRadiusState = []byte("otp-state")
+
+// testRadiusServer is an in-process RADIUS server, alice needs an OTP after her password, bob does not
+type testRadiusServer struct {
+	addr string
+
+	lock     sync.Mutex
+	requests []*radius.Packet
+}
+
+func startTestRadiusServer(t *testing.T) *testRadiusServer {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testRadiusServer{addr: conn.LocalAddr().String()}
+	server := &radius.PacketServer{
+		Handler:      radius.HandlerFunc(s.serveRADIUS),
+		SecretSource: radius.StaticSecretSource([]byte(testRadiusSecret)),
+	}
+	go func() {
+		_ = server.Serve(conn)
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown(context.Background())
+	})
+	return s
+}
+
+func (s *testRadiusServer) serveRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	s.lock.Lock()
+	s.requests = append(s.requests, r.Packet)
+	s.lock.Unlock()
+
+	if r.Code == radius.CodeAccountingRequest {
+		_ = w.Write(r.Response(radius.CodeAccountingResponse))
+		return
+	}
+
+	username := rfc2865.UserName_GetString(r.Packet)
+	password := rfc2865.UserPassword_GetString(r.Packet)
+	state := rfc2865.State_Get(r.Packet)
+
+	var response *radius.Packet
+	switch {
+	case username == "alice" && state == nil && password == "alice-pw":
+		response = r.Response(radius.CodeAccessChallenge)
+		_ = rfc2865.State_Set(response, testRadiusState)
+		_ = rfc2865.ReplyMessage_SetString(response, "Enter your OTP")
+	case username == "alice" && bytes.Equal(state, testRadiusState) && password == testRadiusOTP:
+		response = r.Response(radius.CodeAccessAccept)
+		_ = rfc2865.FilterID_AddString(response, "vpn")
+		_ = rfc2865.FilterID_AddString(response, "admins")
+		_ = rfc2865.FramedIPAddress_Set(response, net.IPv4(192, 168, 3, 50))
+		_ = rfc2865.SessionTimeout_Set(response, 3600)
+		_ = rfc2865.Class_Add(response, []byte("class-alice"))
+		_ = rfc2869.AcctInterimInterval_Set(response, 300)
+	case username == "bob" && state == nil && password == "bob-pw":
+		response = r.Response(radius.CodeAccessAccept)
+	default:
+		response = r.Response(radius.CodeAccessReject)
+	}
+	_ = w.Write(response)
+}
+
+func (s *testRadiusServer) getRequests() []*radius.Packet {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*radius.Packet{}, s.requests...)
+}
+
+func newTestRadiusAuthenticator(t *testing.T, server *testRadiusServer, config string) *RadiusAuthenticator {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "radius.yml")
+	err := os.WriteFile(configFile, []byte("server: "+server.addr+"\nsecret: "+testRadiusSecret+"\ntimeout: 2s\n"+config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &RadiusAuthenticator{}
+	err = a.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func radiusLogin(a *RadiusAuthenticator, username string, password string, challengeID string) (AuthResult, string, *AuthDetails, *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+	r.SetBasicAuth(username, password)
+	if challengeID != "" {
+		r.Header.Set(RadiusChallengeHeader, challengeID)
+	}
+	w := httptest.NewRecorder()
+	result, authUsername, details := a.AuthenticateWithDetails(r, w)
+	return result, authUsername, details, w
+}
+
+// radiusChallengeLogin sends alice's password and returns the challenge ID from the response
+func radiusChallengeLogin(t *testing.T, a *RadiusAuthenticator) string {
+	t.Helper()
+
+	result, _, _, w := radiusLogin(a, "alice", "alice-pw", "")
+	if result != AuthFailedCustom || w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a challenge, got %v with status %d", result, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Enter your OTP") {
+		t.Errorf("expected the Reply-Message in the body, got %q", w.Body.String())
+	}
+	challengeID := w.Header().Get(RadiusChallengeHeader)
+	if challengeID == "" {
+		t.Fatal("expected a challenge ID")
+	}
+	return challengeID
+}
+
+func TestRadiusAccept(t *testing.T) {
+	server := startTestRadiusServer(t)
+	a := newTestRadiusAuthenticator(t, server, "")
+
+	result, username, details, _ := radiusLogin(a, "bob", "bob-pw", "")
+	if result != AuthOk || username != "bob" {
+		t.Fatalf("expected bob to be accepted, got %v %q", result, username)
+	}
+	if details.Accounting != nil {
+		t.Error("expected no accounting without accounting-server")
+	}
+
+	request := server.getRequests()[0]
+	if rfc2865.NASIdentifier_GetString(request) != "wsvpn" || rfc2865.CallingStationID_GetString(request) != "203.0.113.7" {
+		t.Errorf("unexpected NAS attributes %q %q", rfc2865.NASIdentifier_GetString(request), rfc2865.CallingStationID_GetString(request))
+	}
+
+	result, _, _, w := radiusLogin(a, "bob", "wrong", "")
+	if result != AuthFailedDefault || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected rejection with WWW-Authenticate, got %v", result)
+	}
+}
+
+func TestRadiusChallenge(t *testing.T) {
+	server := startTestRadiusServer(t)
+	a := newTestRadiusAuthenticator(t, server, "")
+
+	challengeID := radiusChallengeLogin(t, a)
+
+	result, username, details, _ := radiusLogin(a, "alice", testRadiusOTP, challengeID)
+	if result != AuthOk || username != "alice" {
+		t.Fatalf("expected alice to be accepted, got %v %q", result, username)
+	}
+	if !reflect.DeepEqual(details.Groups, []string{"vpn", "admins"}) {
+		t.Errorf("unexpected groups %v", details.Groups)
+	}
+	if !details.FramedIP.Equal(net.IPv4(192, 168, 3, 50)) || details.SessionTimeout != time.Hour {
+		t.Errorf("unexpected details %v %v", details.FramedIP, details.SessionTimeout)
+	}
+}
+
+func TestRadiusChallengeReplay(t *testing.T) {
+	server := startTestRadiusServer(t)
+	a := newTestRadiusAuthenticator(t, server, "")
+
+	challengeID := radiusChallengeLogin(t, a)
+	result, _, _, _ := radiusLogin(a, "alice", testRadiusOTP, challengeID)
+	if result != AuthOk {
+		t.Fatalf("expected alice to be accepted, got %v", result)
+	}
+
+	requestCount := len(server.getRequests())
+	result, _, _, w := radiusLogin(a, "alice", testRadiusOTP, challengeID)
+	if result != AuthFailedCustom || w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a used challenge to be rejected, got %v with status %d", result, w.Code)
+	}
+	if len(server.getRequests()) != requestCount {
+		t.Error("expected a used challenge to be rejected without asking the RADIUS server")
+	}
+}
+
+func TestRadiusChallengeWrongOTP(t *testing.T) {
+	server := startTestRadiusServer(t)
+	a := newTestRadiusAuthenticator(t, server, "")
+
+	challengeID := radiusChallengeLogin(t, a)
+	result, _, _, _ := radiusLogin(a, "alice", "000000", challengeID)
+	if result != AuthFailedDefault {
+		t.Fatalf("expected a wrong OTP to be rejected, got %v", result)
+	}
+
+	// The challenge is gone after one attempt, even a failed one
+	result, _, _, _ = radiusLogin(a, "alice", testRadiusOTP, challengeID)
+	if result != AuthFailedCustom {
+		t.Fatalf("expected the challenge to be used up, got %v", result)
+	}
+}
+
+func TestRadiusChallengeExpired(t *testing.T) {
+	server := startTestRadiusServer(t)
+	a := newTestRadiusAuthenticator(t, server, "challenge-timeout: 50ms\n")
+
+	challengeID := radiusChallengeLogin(t, a)
+	time.Sleep(100 * time.Millisecond)
+
+	result, _, _, w := radiusLogin(a, "alice", testRadiusOTP, challengeID)
+	if result != AuthFailedCustom || w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an expired challenge to be rejected, got %v with status %d", result, w.Code)
+	}
+}
+
+func TestRadiusChallengeOtherUser(t *testing.T) {
+	server := startTestRadiusServer(t)
+	a := newTestRadiusAuthenticator(t, server, "")
+
+	challengeID := radiusChallengeLogin(t, a)
+	result, _, _, _ := radiusLogin(a, "bob", "bob-pw", challengeID)
+	if result != AuthFailedCustom {
+		t.Fatalf("expected a challenge of another user to be rejected, got %v", result)
+	}
+
+	// Trying it with the wrong user uses the challenge up as well
+	result, _, _, _ = radiusLogin(a, "alice", testRadiusOTP, challengeID)
+	if result != AuthFailedCustom {
+		t.Fatalf("expected the challenge to be used up, got %v", result)
+	}
+}
+
+func TestRadiusAccountingPackets(t *testing.T) {
+	server := startTestRadiusServer(t)
+	a := newTestRadiusAuthenticator(t, server, "accounting-server: "+server.addr+"\n")
+
+	challengeID := radiusChallengeLogin(t, a)
+	result, _, details, _ := radiusLogin(a, "alice", testRadiusOTP, challengeID)
+	if result != AuthOk || details.Accounting == nil {
+		t.Fatalf("expected alice to be accepted with accounting, got %v", result)
+	}
+	if details.AccountingInterval != 300*time.Second {
+		t.Errorf("expected Acct-Interim-Interval to be used, got %v", details.AccountingInterval)
+	}
+
+	record := &AccountingRecord{
+		SessionID:  "session-1",
+		Username:   "alice",
+		RemoteAddr: "203.0.113.7:51234",
+		VPNIP:      net.IPv4(192, 168, 3, 50),
+		Started:    time.Now().Add(-time.Minute),
+		BytesIn:    5<<32 + 1000,
+		BytesOut:   2000,
+		PacketsIn:  10,
+		PacketsOut: 20,
+	}
+	before := len(server.getRequests())
+	details.Accounting.Start(record)
+	details.Accounting.Interim(record)
+	record.TerminateCause = TerminateSessionTimeout
+	details.Accounting.Stop(record)
+
+	requests := server.getRequests()[before:]
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 accounting requests, got %d", len(requests))
+	}
+
+	expectedTypes := []rfc2866.AcctStatusType{rfc2866.AcctStatusType_Value_Start, rfc2866.AcctStatusType_Value_InterimUpdate, rfc2866.AcctStatusType_Value_Stop}
+	for i, request := range requests {
+		if request.Code != radius.CodeAccountingRequest || rfc2866.AcctStatusType_Get(request) != expectedTypes[i] {
+			t.Errorf("request %d: expected %v, got %v %v", i, expectedTypes[i], request.Code, rfc2866.AcctStatusType_Get(request))
+		}
+		if rfc2866.AcctSessionID_GetString(request) != "session-1" || rfc2865.UserName_GetString(request) != "alice" {
+			t.Errorf("request %d: unexpected session %q or user %q", i, rfc2866.AcctSessionID_GetString(request), rfc2865.UserName_GetString(request))
+		}
+		if !bytes.Equal(rfc2865.Class_Get(request), []byte("class-alice")) {
+			t.Errorf("request %d: expected the Class from the Access-Accept", i)
+		}
+		if !rfc2865.FramedIPAddress_Get(request).Equal(record.VPNIP) {
+			t.Errorf("request %d: unexpected Framed-IP-Address %v", i, rfc2865.FramedIPAddress_Get(request))
+		}
+	}
+
+	stop := requests[2]
+	if rfc2866.AcctInputOctets_Get(stop) != 1000 || rfc2869.AcctInputGigawords_Get(stop) != 5 {
+		t.Errorf("unexpected input octets %d and gigawords %d", rfc2866.AcctInputOctets_Get(stop), rfc2869.AcctInputGigawords_Get(stop))
+	}
+	if rfc2866.AcctOutputOctets_Get(stop) != 2000 || rfc2866.AcctInputPackets_Get(stop) != 10 || rfc2866.AcctOutputPackets_Get(stop) != 20 {
+		t.Error("unexpected traffic counters")
+	}
+	if rfc2866.AcctSessionTime_Get(stop) < 60 {
+		t.Errorf("expected a session time of at least 60s, got %d", rfc2866.AcctSessionTime_Get(stop))
+	}
+	if rfc2866.AcctTerminateCause_Get(stop) != rfc2866.AcctTerminateCause_Value_SessionTimeout {
+		t.Errorf("unexpected terminate cause %v", rfc2866.AcctTerminateCause_Get(stop))
+	}
+	if rfc2866.AcctSessionTime_Get(requests[0]) != 0 {
+		t.Error("expected no session time in Start")
+	}
+}
@@ -0,0 +1,74 @@
+package certidentity
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	SourceCN       = "cn"       // Subject Common Name
+	SourceEmail    = "email"    // Email address SAN
+	SourceURI      = "uri"      // URI SAN
+	SourceDN       = "dn"       // Full subject DN (RFC 2253)
+	SourceTemplate = "template" // Template with {placeholders} filled from the certificate
+)
+
+type Config struct {
+	Source       string `yaml:"source"`
+	Template     string `yaml:"template"`
+	Pattern      string `yaml:"pattern"`
+	Replacement  string `yaml:"replacement"`
+	GroupsFromOU bool   `yaml:"groups-from-ou"`
+}
+
+var templatePlaceholderRegexp = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+func Load(config *Config) (*Mapper, error) {
+	mapper := &Mapper{
+		source:       strings.ToLower(config.Source),
+		template:     config.Template,
+		replacement:  config.Replacement,
+		groupsFromOU: config.GroupsFromOU,
+	}
+	if mapper.source == "" {
+		mapper.source = SourceCN
+	}
+
+	switch mapper.source {
+	case SourceCN, SourceEmail, SourceURI, SourceDN:
+		if config.Template != "" {
+			return nil, fmt.Errorf("template is only used with source %s", SourceTemplate)
+		}
+	case SourceTemplate:
+		if config.Template == "" {
+			return nil, fmt.Errorf("source %s requires a template", SourceTemplate)
+		}
+		for _, match := range templatePlaceholderRegexp.FindAllStringSubmatch(config.Template, -1) {
+			if templateFields[match[1]] == nil {
+				return nil, fmt.Errorf("unknown template placeholder %s", match[0])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid source %s", config.Source)
+	}
+
+	if config.Pattern != "" {
+		var err error
+		mapper.pattern, err = regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		if mapper.replacement == "" {
+			// Without a replacement the first capture group (or the whole match) becomes the username
+			mapper.replacement = "$0"
+			if mapper.pattern.NumSubexp() > 0 {
+				mapper.replacement = "$1"
+			}
+		}
+	} else if config.Replacement != "" {
+		return nil, fmt.Errorf("replacement requires a pattern")
+	}
+
+	return mapper, nil
+}
@@ -0,0 +1,109 @@
+package certidentity
+
+import (
+	"crypto/x509"
+	"regexp"
+)
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+var templateFields = map[string]func(cert *x509.Certificate) string{
+	"CN": func(cert *x509.Certificate) string {
+		return cert.Subject.CommonName
+	},
+	"O": func(cert *x509.Certificate) string {
+		return firstValue(cert.Subject.Organization)
+	},
+	"OU": func(cert *x509.Certificate) string {
+		return firstValue(cert.Subject.OrganizationalUnit)
+	},
+	"C": func(cert *x509.Certificate) string {
+		return firstValue(cert.Subject.Country)
+	},
+	"ST": func(cert *x509.Certificate) string {
+		return firstValue(cert.Subject.Province)
+	},
+	"L": func(cert *x509.Certificate) string {
+		return firstValue(cert.Subject.Locality)
+	},
+	"serialNumber": func(cert *x509.Certificate) string {
+		return cert.Subject.SerialNumber
+	},
+	"email": func(cert *x509.Certificate) string {
+		return firstValue(cert.EmailAddresses)
+	},
+	"uri": func(cert *x509.Certificate) string {
+		if len(cert.URIs) == 0 {
+			return ""
+		}
+		return cert.URIs[0].String()
+	},
+}
+
+// Mapper derives the username and groups of a client from its certificate
+type Mapper struct {
+	source       string
+	template     string
+	pattern      *regexp.Regexp
+	replacement  string
+	groupsFromOU bool
+}
+
+// DefaultMapper uses the Common Name as the username, without any groups
+var DefaultMapper = &Mapper{
+	source: SourceCN,
+}
+
+// candidates returns the values the username can be taken from, multi-valued sources (SANs) in certificate order
+func (m *Mapper) candidates(cert *x509.Certificate) []string {
+	switch m.source {
+	case SourceEmail:
+		return cert.EmailAddresses
+	case SourceURI:
+		uris := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			uris = append(uris, uri.String())
+		}
+		return uris
+	case SourceDN:
+		return []string{cert.Subject.String()}
+	case SourceTemplate:
+		return []string{templatePlaceholderRegexp.ReplaceAllStringFunc(m.template, func(placeholder string) string {
+			return templateFields[placeholder[1:len(placeholder)-1]](cert)
+		})}
+	}
+	return []string{cert.Subject.CommonName}
+}
+
+// Username returns the username for cert, or an empty string if it has none
+// With a pattern, the first value matching it is used and rewritten with the replacement
+func (m *Mapper) Username(cert *x509.Certificate) string {
+	for _, candidate := range m.candidates(cert) {
+		if candidate == "" {
+			continue
+		}
+		if m.pattern == nil {
+			return candidate
+		}
+
+		match := m.pattern.FindStringSubmatchIndex(candidate)
+		if match == nil {
+			continue
+		}
+		return string(m.pattern.ExpandString(nil, m.replacement, candidate, match))
+	}
+	return ""
+}
+
+// Groups returns the groups of cert, these are its OUs if enabled
+func (m *Mapper) Groups(cert *x509.Certificate) []string {
+	if !m.groupsFromOU {
+		return nil
+	}
+	return cert.Subject.OrganizationalUnit
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Doridian/wsvpn/server/servers"
+	"github.com/Doridian/wsvpn/shared/cli"
+)
+
+type AdminConfig struct {
+	Listen string   `yaml:"listen"`
+	Tokens []string `yaml:"tokens"`
+	TLS    struct {
+		ClientCA    string        `yaml:"client-ca"`
+		Certificate string        `yaml:"certificate"`
+		Key         string        `yaml:"key"`
+		Config      cli.TLSConfig `yaml:"config"`
+	} `yaml:"tls"`
+}
+
+var adminTLSConfig *tls.Config
+
+func getAdminTLSConfig(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	return adminTLSConfig, nil
+}
+
+func getAdminTLSCert(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &adminTLSConfig.Certificates[0], nil
+}
+
+func (c *AdminConfig) loadTLSConfig() (*tls.Config, error) {
+	if c.TLS.Certificate == "" && c.TLS.Key == "" {
+		if c.TLS.ClientCA != "" {
+			return nil, errors.New("server.admin.tls.client-ca requires server.admin.tls.certificate and server.admin.tls.key")
+		}
+		return nil, nil
+	}
+
+	if c.TLS.Certificate == "" || c.TLS.Key == "" {
+		return nil, errors.New("provide either both server.admin.tls.certificate and server.admin.tls.key or neither")
+	}
+
+	newTLSConfig := &tls.Config{}
+
+	cert, err := tls.LoadX509KeyPair(c.TLS.Certificate, c.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	newTLSConfig.Certificates = []tls.Certificate{cert}
+
+	if c.TLS.ClientCA != "" {
+		tlsClientCAPEM, err := os.ReadFile(c.TLS.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsClientCAPool := x509.NewCertPool()
+		ok := tlsClientCAPool.AppendCertsFromPEM(tlsClientCAPEM)
+		if !ok {
+			return nil, errors.New("error reading server.admin.tls.client-ca PEM")
+		}
+
+		newTLSConfig.ClientCAs = tlsClientCAPool
+		newTLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	err = cli.TLSUseConfig(newTLSConfig, &c.TLS.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTLSConfig, nil
+}
+
+func (c *AdminConfig) apply(server *servers.Server, initialConfig bool) error {
+	newTLSConfig, err := c.loadTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	if initialConfig {
+		server.AdminListenAddr = c.Listen
+		if newTLSConfig != nil {
+			server.AdminTLSConfig = &tls.Config{
+				GetConfigForClient: getAdminTLSConfig,
+				GetCertificate:     getAdminTLSCert,
+			}
+		}
+	} else {
+		if server.AdminListenAddr != c.Listen {
+			server.ConfigWarning("Ignoring change of server.admin.listen on reload")
+		}
+		if server.AdminTLSConfig == nil && newTLSConfig != nil {
+			server.ConfigWarning("Ignoring enablement of admin TLS on reload")
+			newTLSConfig = nil
+		} else if server.AdminTLSConfig != nil && newTLSConfig == nil {
+			server.ConfigWarning("Ignoring disablement of admin TLS on reload")
+		}
+	}
+
+	tokens := make([][]byte, 0, len(c.Tokens))
+	for _, token := range c.Tokens {
+		if token == "" {
+			return errors.New("server.admin.tokens must not contain empty tokens")
+		}
+		tokens = append(tokens, []byte(token))
+	}
+
+	effectiveTLSConfig := newTLSConfig
+	if effectiveTLSConfig == nil && server.AdminTLSConfig != nil {
+		effectiveTLSConfig = adminTLSConfig
+	}
+	hasMTLS := effectiveTLSConfig != nil && effectiveTLSConfig.ClientAuth == tls.RequireAndVerifyClientCert
+	err = servers.CheckAdminAuthentication(server.AdminListenAddr, len(tokens) > 0, hasMTLS)
+	if err != nil {
+		return fmt.Errorf("server.admin: %v", err)
+	}
+
+	if newTLSConfig != nil {
+		adminTLSConfig = newTLSConfig
+	}
+
+	server.AdminTokens = tokens
+
+	return nil
+}
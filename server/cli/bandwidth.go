@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/Doridian/wsvpn/server/servers"
+	"github.com/Doridian/wsvpn/shared/ratelimit"
+)
+
+func overrideRate(rate string, base uint64, name string) (uint64, error) {
+	if rate == "" {
+		return base, nil
+	}
+	res, err := ratelimit.ParseRate(rate)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+	return res, nil
+}
+
+// toLimits applies all rates set in this config over base, empty rates are taken from base
+func (c *BandwidthConfig) toLimits(base servers.BandwidthLimits) (servers.BandwidthLimits, error) {
+	var err error
+	res := base
+
+	res.PerConnectionUpload, err = overrideRate(c.PerConnection.Upload, base.PerConnectionUpload, "per-connection upload")
+	if err != nil {
+		return res, err
+	}
+	res.PerConnectionDownload, err = overrideRate(c.PerConnection.Download, base.PerConnectionDownload, "per-connection download")
+	if err != nil {
+		return res, err
+	}
+	res.PerUserUpload, err = overrideRate(c.PerUser.Upload, base.PerUserUpload, "per-user upload")
+	if err != nil {
+		return res, err
+	}
+	res.PerUserDownload, err = overrideRate(c.PerUser.Download, base.PerUserDownload, "per-user download")
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
@@ -1,8 +1,10 @@
 package cli
 
 import (
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"log"
@@ -11,15 +13,21 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/server/authenticators"
+	"github.com/Doridian/wsvpn/server/certidentity"
 	"github.com/Doridian/wsvpn/server/ipswitch"
+	"github.com/Doridian/wsvpn/server/jwks"
 	"github.com/Doridian/wsvpn/server/macswitch"
+	"github.com/Doridian/wsvpn/server/revocation"
 	"github.com/Doridian/wsvpn/server/servers"
 	"github.com/Doridian/wsvpn/shared"
 	"github.com/Doridian/wsvpn/shared/cli"
 	"github.com/Doridian/wsvpn/shared/features"
 	"github.com/Doridian/wsvpn/shared/iface"
+	"github.com/Doridian/wsvpn/shared/sockets"
 	"github.com/google/uuid"
 )
 
@@ -33,6 +41,35 @@ func getTLSCert(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return &tlsConfig.Certificates[0], nil
 }
 
+func loadRevocationChecker(config *Config, tlsClientCAPEM []byte) (*revocation.Checker, error) {
+	cas := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, tlsClientCAPEM = pem.Decode(tlsClientCAPEM)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing tls.client-ca: %v", err)
+		}
+		cas = append(cas, ca)
+	}
+
+	checker, err := revocation.NewChecker(config.Server.TLS.CRL, cas, revocation.OCSPConfig{
+		Enabled:  config.Server.TLS.OCSP.Enabled,
+		Timeout:  config.Server.TLS.OCSP.Timeout,
+		SoftFail: config.Server.TLS.OCSP.SoftFail,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tls.crl: %v", err)
+	}
+	return checker, nil
+}
+
 func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool) error {
 	config, err := Load(*configPtr)
 	if err != nil {
@@ -44,16 +81,42 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 		return err
 	}
 
+	var newSecondaryVPNNet *shared.VPNNet
+	if config.Tunnel.SecondarySubnet != "" {
+		newSecondaryVPNNet, err = shared.ParseVPNNet(config.Tunnel.SecondarySubnet)
+		if err != nil {
+			return err
+		}
+		if newSecondaryVPNNet.IsIPv4() == newVPNNet.IsIPv4() {
+			return errors.New("tunnel.secondary-subnet must be of a different IP family than tunnel.subnet")
+		}
+	}
+
 	if initialConfig {
 		server.VPNNet = newVPNNet
-	} else if !server.VPNNet.Equals(newVPNNet) {
-		log.Printf("WARNING: Ignoring change of tunnel.subnet on reload")
+		server.SecondaryVPNNet = newSecondaryVPNNet
+	} else {
+		if !server.VPNNet.Equals(newVPNNet) {
+			server.ConfigWarning("Ignoring change of tunnel.subnet on reload")
+		}
+		if !server.SecondaryVPNNet.Equals(newSecondaryVPNNet) {
+			server.ConfigWarning("Ignoring change of tunnel.secondary-subnet on reload")
+		}
 	}
 
 	server.WebsiteDirectory = config.Server.WebsiteDirectory
 
-	server.SocketConfigurator = &cli.PingFlagsSocketConfigurator{
-		Config: &config.Tunnel.Ping,
+	compressionConfigurator, err := cli.NewCompressionSocketConfigurator(&config.Tunnel.Compression)
+	if err != nil {
+		return err
+	}
+	server.SocketConfigurator = &sockets.MultiSocketConfigurator{
+		Configurators: []sockets.SocketConfigurator{
+			&cli.PingFlagsSocketConfigurator{
+				Config: &config.Tunnel.Ping,
+			},
+			compressionConfigurator,
+		},
 	}
 	server.DoLocalIPConfig = config.Tunnel.IPConfig.Local
 	server.DoRemoteIPConfig = config.Tunnel.IPConfig.Remote
@@ -78,17 +141,21 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 	if initialConfig {
 		server.ListenAddr = config.Server.Listen
 		server.HTTP3Enabled = config.Server.EnableHTTP3
+		server.ProxyProtocol = config.Server.ProxyProtocol
 
 		server.Mode = vpnMode
 	} else {
 		if server.ListenAddr != config.Server.Listen {
-			log.Printf("WARNING: Ignoring change of server.listen on reload")
+			server.ConfigWarning("Ignoring change of server.listen on reload")
 		}
 		if server.HTTP3Enabled != config.Server.EnableHTTP3 {
-			log.Printf("WARNING: Ignoring change of server.enable-http3 on reload")
+			server.ConfigWarning("Ignoring change of server.enable-http3 on reload")
+		}
+		if server.ProxyProtocol != config.Server.ProxyProtocol {
+			server.ConfigWarning("Ignoring change of server.proxy-protocol on reload")
 		}
 		if server.Mode != vpnMode {
-			log.Printf("WARNING: Ignoring change of tunnel.mode on reload")
+			server.ConfigWarning("Ignoring change of tunnel.mode on reload")
 		}
 	}
 
@@ -99,6 +166,18 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 	}
 	server.APIUsers = apiUsers
 
+	server.MetricsEnabled = config.Server.Metrics.Enabled
+	metricsUsers := make(map[string]bool)
+	for _, u := range config.Server.Metrics.Users {
+		metricsUsers[u] = true
+	}
+	server.MetricsUsers = metricsUsers
+
+	err = config.Server.Admin.apply(server, initialConfig)
+	if err != nil {
+		return err
+	}
+
 	srvHeaders := http.Header{}
 	for name, values := range config.Server.Headers {
 		for _, value := range values {
@@ -116,6 +195,79 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 		return err
 	}
 
+	err = server.SetDNS(config.Tunnel.DNS.Nameservers, config.Tunnel.DNS.SearchDomains)
+	if err != nil {
+		return err
+	}
+
+	userRoutes := make(map[string][]string)
+	staticIPs := make(map[string]string)
+	clientNetworks := make(map[string][]string)
+	userGroups := make(map[string][]string)
+	for username, userConfig := range config.Users {
+		userRoutes[username] = userConfig.Routes
+		clientNetworks[username] = userConfig.Networks
+		userGroups[username] = userConfig.Groups
+		if userConfig.StaticIP != "" {
+			staticIPs[username] = userConfig.StaticIP
+		}
+	}
+	err = server.SetRoutes(config.Tunnel.Routes, userRoutes)
+	if err != nil {
+		return err
+	}
+
+	err = server.SetStaticIPs(staticIPs)
+	if err != nil {
+		return err
+	}
+
+	err = server.SetClientNetworks(clientNetworks)
+	if err != nil {
+		return err
+	}
+
+	server.SetUserGroups(userGroups)
+
+	defaultBandwidthLimits, err := config.Tunnel.Bandwidth.toLimits(servers.BandwidthLimits{})
+	if err != nil {
+		return fmt.Errorf("tunnel.bandwidth: %v", err)
+	}
+	userBandwidthLimits := make(map[string]servers.BandwidthLimits)
+	for username, userConfig := range config.Users {
+		userBandwidthLimits[username], err = userConfig.Bandwidth.toLimits(defaultBandwidthLimits)
+		if err != nil {
+			return fmt.Errorf("users.%s.bandwidth: %v", username, err)
+		}
+	}
+	server.SetBandwidthLimits(defaultBandwidthLimits, userBandwidthLimits)
+
+	err = server.SetTrustedProxies(config.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("server.trusted-proxies: %v", err)
+	}
+	server.SetLockoutConfig(servers.LockoutConfig{
+		MaxFailures:        config.Server.Lockout.MaxFailures,
+		LockoutDuration:    config.Server.Lockout.Duration,
+		MaxLockoutDuration: config.Server.Lockout.MaxDuration,
+		ResetAfter:         config.Server.Lockout.ResetAfter,
+	})
+	server.SetConnectionLimitConfig(servers.ConnectionLimitConfig{
+		Rate:                    config.Server.ConnectionLimit.Rate,
+		Burst:                   config.Server.ConnectionLimit.Burst,
+		MaxConcurrentHandshakes: config.Server.ConnectionLimit.MaxConcurrentHandshakes,
+	})
+
+	aclRules, err := acl.Load(&config.Tunnel.ACL)
+	if err != nil {
+		return err
+	}
+
+	err = server.SetLeaseFile(config.Tunnel.LeaseFile)
+	if err != nil {
+		return err
+	}
+
 	server.MaxConnectionsPerUser = config.Server.MaxConnectionsPerUser
 	switch config.Server.MaxConnectionsPerUserMode {
 	case "kill-oldest":
@@ -125,7 +277,7 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 	}
 
 	if !initialConfig && server.InterfaceConfig.OneInterfacePerConnection != config.Interface.OneInterfacePerConnection {
-		log.Printf("WARNING: Ignroing interface config due to change of interface.one-interface-per-connection on reload")
+		server.ConfigWarning("Ignoring interface config due to change of interface.one-interface-per-connection on reload")
 	} else {
 		server.InterfaceConfig = &config.Interface
 
@@ -157,17 +309,11 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 		}
 	}
 
-	var newAuthenticator authenticators.Authenticator
+	server.SetACL(aclRules)
 
-	switch strings.ToLower(config.Server.Authenticator.Type) {
-	case "allow-all":
-		newAuthenticator = &authenticators.AllowAllAuthenticator{}
-	case "htpasswd":
-		newAuthenticator = &authenticators.HtpasswdAuthenticator{}
-	case "radius":
-		newAuthenticator = &authenticators.RadiusAuthenticator{}
-	default:
-		return errors.New("invalid authenticator selected")
+	newAuthenticator, err := authenticators.New(config.Server.Authenticator.Type)
+	if err != nil {
+		return err
 	}
 
 	err = newAuthenticator.Load(config.Server.Authenticator.Config)
@@ -177,6 +323,12 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 
 	server.Authenticator = newAuthenticator
 
+	certificateIdentity, err := certidentity.Load(&config.Server.TLS.Identity)
+	if err != nil {
+		return fmt.Errorf("tls.identity: %v", err)
+	}
+	server.CertificateIdentity = certificateIdentity
+
 	if config.Server.TLS.Certificate != "" || config.Server.TLS.Key != "" || config.Server.TLS.ClientCA != "" {
 		if config.Server.TLS.Certificate == "" && config.Server.TLS.Key == "" {
 			return errors.New("tls-client-ca requires tls-key and tls-cert")
@@ -186,6 +338,10 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 			return errors.New("provide either both tls-key and tls-cert or neither")
 		}
 
+		if config.Server.TLS.ClientCA == "" && (config.Server.TLS.CRL != "" || config.Server.TLS.OCSP.Enabled) {
+			return errors.New("tls.crl and tls.ocsp require tls.client-ca")
+		}
+
 		newTLSConfig := &tls.Config{}
 
 		cert, err := tls.LoadX509KeyPair(config.Server.TLS.Certificate, config.Server.TLS.Key)
@@ -194,6 +350,7 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 		}
 		newTLSConfig.Certificates = []tls.Certificate{cert}
 
+		var revocationChecker *revocation.Checker
 		if config.Server.TLS.ClientCA != "" {
 			var tlsClientCAPEM []byte
 			tlsClientCAPEM, err = os.ReadFile(config.Server.TLS.ClientCA)
@@ -209,14 +366,26 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 
 			newTLSConfig.ClientCAs = tlsClientCAPool
 			newTLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+			if config.Server.TLS.CRL != "" || config.Server.TLS.OCSP.Enabled {
+				revocationChecker, err = loadRevocationChecker(config, tlsClientCAPEM)
+				if err != nil {
+					return err
+				}
+				newTLSConfig.VerifyConnection = revocationChecker.VerifyConnection
+			}
 		}
 
 		err = cli.TLSUseConfig(newTLSConfig, &config.Server.TLS.Config)
 		if err != nil {
+			if revocationChecker != nil {
+				_ = revocationChecker.Close()
+			}
 			return err
 		}
 
 		tlsConfig = newTLSConfig
+		server.SetRevocationChecker(revocationChecker)
 
 		if server.TLSConfig == nil {
 			if initialConfig {
@@ -225,14 +394,46 @@ func reloadConfig(configPtr *string, server *servers.Server, initialConfig bool)
 					GetCertificate:     getTLSCert,
 				}
 			} else {
-				log.Printf("WARNING: Ignoring enablement of TLS on reload")
+				server.ConfigWarning("Ignoring enablement of TLS on reload")
 			}
 		}
-	} else if !initialConfig && server.TLSConfig != nil {
-		log.Printf("WARNING: Ignoring disablement of TLS on reload")
+	} else {
+		if !initialConfig && server.TLSConfig != nil {
+			server.ConfigWarning("Ignoring disablement of TLS on reload")
+		}
+		server.SetRevocationChecker(nil)
 	}
 
 	server.PreauthorizeSecret = []byte(config.Server.PreauthorizeSecret)
+	server.PreauthorizeLifetime = config.Server.PreauthorizeLifetime
+	server.PreauthorizeAudience = config.Server.PreauthorizeAudience
+	server.PreauthorizeIssuer = config.Server.PreauthorizeIssuer
+
+	var preauthorizeKey crypto.Signer
+	if config.Server.PreauthorizeKey != "" {
+		preauthorizeKey, err = jwks.LoadPrivateKey(config.Server.PreauthorizeKey)
+		if err != nil {
+			return fmt.Errorf("server.preauthorize-key: %v", err)
+		}
+	}
+	err = server.SetPreauthorizeKey(preauthorizeKey)
+	if err != nil {
+		return fmt.Errorf("server.preauthorize-key: %v", err)
+	}
+
+	if config.Server.PreauthorizeTrustedJWKS != "" {
+		if config.Server.PreauthorizeAudience == "" || config.Server.PreauthorizeIssuer == "" {
+			return errors.New("server.preauthorize-trusted-jwks requires server.preauthorize-audience and server.preauthorize-issuer")
+		}
+		trustedKeys := jwks.NewCache(config.Server.PreauthorizeTrustedJWKS, time.Hour)
+		err = trustedKeys.Load()
+		if err != nil {
+			return fmt.Errorf("server.preauthorize-trusted-jwks: %v", err)
+		}
+		server.PreauthorizeTrustedKeys = trustedKeys
+	} else {
+		server.PreauthorizeTrustedKeys = nil
+	}
 
 	return server.UpdateSocketConfig()
 }
@@ -269,16 +470,16 @@ func Main(configPtr *string, printDefaultConfigPtr *bool) {
 		panic(err)
 	}
 
+	server.SetConfigReloader(func() error {
+		return reloadConfig(configPtr, server, false)
+	})
+
 	reloadSig := make(chan os.Signal, 1)
 	signal.Notify(reloadSig, syscall.SIGHUP)
 	go func() {
 		for {
 			<-reloadSig
-			log.Printf("Reloading configuration, might not take effect until next connection...")
-			reloadErr := reloadConfig(configPtr, server, false)
-			if reloadErr != nil {
-				log.Printf("Error reloading config: %v", reloadErr)
-			}
+			server.ReloadConfig()
 		}
 	}()
 
@@ -5,7 +5,10 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/Doridian/wsvpn/server/acl"
+	"github.com/Doridian/wsvpn/server/certidentity"
 	"github.com/Doridian/wsvpn/shared"
 	shared_cli "github.com/Doridian/wsvpn/shared/cli"
 	"github.com/Doridian/wsvpn/shared/features"
@@ -19,6 +22,7 @@ type Config struct {
 	Tunnel struct {
 		MTU                      int             `yaml:"mtu"`
 		Subnet                   string          `yaml:"subnet"`
+		SecondarySubnet          string          `yaml:"secondary-subnet"`
 		Mode                     string          `yaml:"mode"`
 		AllowClientToClient      bool            `yaml:"allow-client-to-client"`
 		AllowIPSpoofing          bool            `yaml:"allow-ip-spoofing"`
@@ -30,38 +34,101 @@ type Config struct {
 			Local  bool `yaml:"local"`
 			Remote bool `yaml:"remote"`
 		} `yaml:"ip-config"`
-		Ping shared_cli.PingConfig `yaml:"ping"`
+		Ping        shared_cli.PingConfig        `yaml:"ping"`
+		Compression shared_cli.CompressionConfig `yaml:"compression"`
+		DNS         struct {
+			Nameservers   []string `yaml:"nameservers"`
+			SearchDomains []string `yaml:"search-domains"`
+		} `yaml:"dns"`
+		ACL       acl.Config      `yaml:"acl"`
+		Bandwidth BandwidthConfig `yaml:"bandwidth"`
+		Routes    []string        `yaml:"routes"`
+		LeaseFile string          `yaml:"lease-file"`
 	} `yaml:"tunnel"`
 
+	Users map[string]UserConfig `yaml:"users"`
+
 	Interface iface.InterfaceConfig `yaml:"interface"`
 
 	Scripts shared.EventConfig `yaml:"scripts"`
 
 	Server struct {
-		Listen      string      `yaml:"listen"`
-		EnableHTTP3 bool        `yaml:"enable-http3"`
-		Headers     http.Header `yaml:"headers"`
-		TLS         struct {
+		Listen        string      `yaml:"listen"`
+		EnableHTTP3   bool        `yaml:"enable-http3"`
+		ProxyProtocol bool        `yaml:"proxy-protocol"`
+		Headers       http.Header `yaml:"headers"`
+		TLS           struct {
 			ClientCA    string               `yaml:"client-ca"`
 			Certificate string               `yaml:"certificate"`
 			Key         string               `yaml:"key"`
 			Config      shared_cli.TLSConfig `yaml:"config"`
+			CRL         string               `yaml:"crl"`
+			OCSP        struct {
+				Enabled  bool          `yaml:"enabled"`
+				Timeout  time.Duration `yaml:"timeout"`
+				SoftFail bool          `yaml:"soft-fail"`
+			} `yaml:"ocsp"`
+			Identity certidentity.Config `yaml:"identity"`
 		} `yaml:"tls"`
 		Authenticator struct {
 			Type   string `yaml:"type"`
 			Config string `yaml:"config"`
 		} `yaml:"authenticator"`
-		PreauthorizeSecret        string `yaml:"preauthorize-secret"`
-		MaxConnectionsPerUser     int    `yaml:"max-connections-per-user"`
-		MaxConnectionsPerUserMode string `yaml:"max-connections-per-user-mode"`
-		WebsiteDirectory          string `yaml:"website-directory"`
+		PreauthorizeSecret        string        `yaml:"preauthorize-secret"`
+		PreauthorizeKey           string        `yaml:"preauthorize-key"`
+		PreauthorizeTrustedJWKS   string        `yaml:"preauthorize-trusted-jwks"`
+		PreauthorizeLifetime      time.Duration `yaml:"preauthorize-lifetime"`
+		PreauthorizeAudience      string        `yaml:"preauthorize-audience"`
+		PreauthorizeIssuer        string        `yaml:"preauthorize-issuer"`
+		MaxConnectionsPerUser     int           `yaml:"max-connections-per-user"`
+		MaxConnectionsPerUserMode string        `yaml:"max-connections-per-user-mode"`
+		WebsiteDirectory          string        `yaml:"website-directory"`
 		API                       struct {
 			Enabled bool     `yaml:"enabled"`
 			Users   []string `yaml:"users"`
 		} `yaml:"api"`
+		Metrics struct {
+			Enabled bool     `yaml:"enabled"`
+			Users   []string `yaml:"users"`
+		} `yaml:"metrics"`
+		Admin           AdminConfig           `yaml:"admin"`
+		TrustedProxies  []string              `yaml:"trusted-proxies"`
+		Lockout         LockoutConfig         `yaml:"lockout"`
+		ConnectionLimit ConnectionLimitConfig `yaml:"connection-limit"`
 	}
 }
 
+type UserConfig struct {
+	Routes    []string        `yaml:"routes"`
+	StaticIP  string          `yaml:"static-ip"`
+	Networks  []string        `yaml:"networks"`
+	Groups    []string        `yaml:"groups"`
+	Bandwidth BandwidthConfig `yaml:"bandwidth"`
+}
+
+type BandwidthLimitConfig struct {
+	Upload   string `yaml:"upload"`
+	Download string `yaml:"download"`
+}
+
+type BandwidthConfig struct {
+	PerConnection BandwidthLimitConfig `yaml:"per-connection"`
+	PerUser       BandwidthLimitConfig `yaml:"per-user"`
+}
+
+type LockoutConfig struct {
+	MaxFailures int           `yaml:"max-failures"`
+	Duration    time.Duration `yaml:"duration"`
+	MaxDuration time.Duration `yaml:"max-duration"`
+	ResetAfter  time.Duration `yaml:"reset-after"`
+}
+
+type ConnectionLimitConfig struct {
+	Rate                    float64 `yaml:"rate"`
+	Burst                   int     `yaml:"burst"`
+	MaxConcurrentHandshakes int     `yaml:"max-concurrent-handshakes"`
+}
+
 func Load(file string) (*Config, error) {
 	out := &Config{}
 
@@ -3,7 +3,9 @@ package ipswitch
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/shared/sockets"
 )
 
@@ -15,17 +17,41 @@ func ipToIPAddr(ip net.IP) ipaddr {
 	return out
 }
 
+type networkEntry struct {
+	ipNet  *net.IPNet
+	socket *sockets.Socket
+}
+
 type IPSwitch struct {
 	AllowClientToClient bool
 
 	ipTable map[ipaddr]*sockets.Socket
-	ipLock  *sync.RWMutex
+	// Sorted by prefix length, longest first, so the first match is the most specific one
+	networkTable []networkEntry
+	ipLock       *sync.RWMutex
+
+	rules atomic.Pointer[acl.ACL]
 }
 
 func MakeIPSwitch() *IPSwitch {
 	return &IPSwitch{
 		AllowClientToClient: false,
 		ipTable:             make(map[ipaddr]*sockets.Socket),
+		networkTable:        make([]networkEntry, 0),
 		ipLock:              &sync.RWMutex{},
 	}
 }
+
+func (g *IPSwitch) SetACL(rules *acl.ACL) {
+	g.rules.Store(rules)
+}
+
+func (g *IPSwitch) GetTableSizes() map[string]int {
+	g.ipLock.RLock()
+	defer g.ipLock.RUnlock()
+
+	return map[string]int{
+		"ip":      len(g.ipTable),
+		"network": len(g.networkTable),
+	}
+}
@@ -2,11 +2,13 @@ package ipswitch
 
 import (
 	"net"
+	"sort"
 
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/shared/sockets"
 )
 
-func (g *IPSwitch) broadcastDataMessage(data []byte, skip *sockets.Socket) {
+func (g *IPSwitch) broadcastDataMessage(data []byte, skip *sockets.Socket, rules *acl.ACL, aclPacket *acl.Packet) {
 	g.ipLock.RLock()
 	targetList := make([]*sockets.Socket, 0, len(g.ipTable))
 	for _, v := range g.ipTable {
@@ -18,6 +20,9 @@ func (g *IPSwitch) broadcastDataMessage(data []byte, skip *sockets.Socket) {
 	g.ipLock.RUnlock()
 
 	for _, socket := range targetList {
+		if !rules.Allows(aclPacket, acl.DirectionOut, socket) {
+			continue
+		}
 		_ = socket.WritePacket(data)
 	}
 }
@@ -28,5 +33,43 @@ func (g *IPSwitch) findSocketByIP(ip net.IP) *sockets.Socket {
 	g.ipLock.RLock()
 	defer g.ipLock.RUnlock()
 
-	return g.ipTable[ipAddr]
+	socket := g.ipTable[ipAddr]
+	if socket != nil {
+		return socket
+	}
+
+	for _, entry := range g.networkTable {
+		if entry.ipNet.Contains(ip) {
+			return entry.socket
+		}
+	}
+	return nil
+}
+
+// addNetworks must be called with ipLock held
+func (g *IPSwitch) addNetworks(socket *sockets.Socket) {
+	for _, ipNet := range socket.RoutedNetworks {
+		g.networkTable = append(g.networkTable, networkEntry{
+			ipNet:  ipNet,
+			socket: socket,
+		})
+	}
+
+	sort.SliceStable(g.networkTable, func(i, j int) bool {
+		iOnes, _ := g.networkTable[i].ipNet.Mask.Size()
+		jOnes, _ := g.networkTable[j].ipNet.Mask.Size()
+		return iOnes > jOnes
+	})
+}
+
+// removeNetworks must be called with ipLock held
+func (g *IPSwitch) removeNetworks(socket *sockets.Socket) {
+	newNetworkTable := make([]networkEntry, 0, len(g.networkTable))
+	for _, entry := range g.networkTable {
+		if entry.socket == socket {
+			continue
+		}
+		newNetworkTable = append(newNetworkTable, entry)
+	}
+	g.networkTable = newNetworkTable
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/Doridian/water/waterutil"
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/shared/sockets"
 )
 
@@ -31,7 +32,17 @@ func (g *IPSwitch) HandlePacket(socket *sockets.Socket, packet []byte) (bool, er
 		return true, nil
 	}
 
-	if socket != nil && !srcIP.Equal(socket.AssignedIP) {
+	if socket != nil && !socket.IsAllowedSourceIP(srcIP) {
+		return true, nil
+	}
+
+	rules := g.rules.Load()
+	var aclPacket *acl.Packet
+	if rules != nil {
+		aclPacket = acl.ParsePacket(packet)
+	}
+
+	if socket != nil && !rules.Allows(aclPacket, acl.DirectionIn, socket) {
 		return true, nil
 	}
 
@@ -39,12 +50,14 @@ func (g *IPSwitch) HandlePacket(socket *sockets.Socket, packet []byte) (bool, er
 		if destIP.IsGlobalUnicast() {
 			socketDest := g.findSocketByIP(destIP)
 			if socketDest != nil {
-				_ = socketDest.WritePacket(packet)
+				if rules.Allows(aclPacket, acl.DirectionOut, socketDest) {
+					_ = socketDest.WritePacket(packet)
+				}
 			} else {
 				return false, nil
 			}
 		} else {
-			g.broadcastDataMessage(packet, socket)
+			g.broadcastDataMessage(packet, socket, rules, aclPacket)
 		}
 
 		return true, nil
@@ -54,28 +67,38 @@ func (g *IPSwitch) HandlePacket(socket *sockets.Socket, packet []byte) (bool, er
 }
 
 func (g *IPSwitch) RegisterSocket(socket *sockets.Socket) {
-	ipAddr := ipToIPAddr(socket.AssignedIP)
+	oldSockets := make([]*sockets.Socket, 0)
 
 	g.ipLock.Lock()
-	oldSocket, ok := g.ipTable[ipAddr]
-	g.ipTable[ipAddr] = socket
+	for _, ip := range socket.AssignedIPs {
+		ipAddr := ipToIPAddr(ip)
+		oldSocket, ok := g.ipTable[ipAddr]
+		g.ipTable[ipAddr] = socket
+		if ok && oldSocket != socket {
+			oldSockets = append(oldSockets, oldSocket)
+		}
+	}
+	g.addNetworks(socket)
 	g.ipLock.Unlock()
 
-	if ok {
+	for _, oldSocket := range oldSockets {
 		oldSocket.CloseError(errors.New("IP conflict"))
 	}
 }
 
 func (g *IPSwitch) UnregisterSocket(socket *sockets.Socket) {
-	ipAddr := ipToIPAddr(socket.AssignedIP)
-
 	g.ipLock.Lock()
 	defer g.ipLock.Unlock()
 
-	ourSocket, ok := g.ipTable[ipAddr]
-	if !ok || ourSocket != socket {
-		return
-	}
+	g.removeNetworks(socket)
 
-	delete(g.ipTable, ipAddr)
+	for _, ip := range socket.AssignedIPs {
+		ipAddr := ipToIPAddr(ip)
+		ourSocket, ok := g.ipTable[ipAddr]
+		if !ok || ourSocket != socket {
+			continue
+		}
+
+		delete(g.ipTable, ipAddr)
+	}
 }
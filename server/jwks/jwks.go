@@ -0,0 +1,256 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const maxJWKSSize = 1024 * 1024
+
+// Do not refetch the JWKS for unknown key IDs more often than this
+const jwksMinRefreshInterval = time.Minute
+
+const jwksFetchTimeout = 10 * time.Second
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+func decodeJWKBase64(value string, name string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return data, nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKBase64(k.N, "n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKBase64(k.E, "e")
+		if err != nil {
+			return nil, err
+		}
+		eInt := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !eInt.IsInt64() || eInt.Int64() < 3 || eInt.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(eInt.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %s", k.Crv)
+		}
+		x, err := decodeJWKBase64(k.X, "x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKBase64(k.Y, "y")
+		if err != nil {
+			return nil, err
+		}
+		byteLen := (curve.Params().BitSize + 7) / 8
+		if len(x) != byteLen || len(y) != byteLen {
+			return nil, errors.New("invalid EC key size")
+		}
+		point := append([]byte{4}, x...)
+		point = append(point, y...)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %s", k.Crv)
+		}
+		x, err := decodeJWKBase64(k.X, "x")
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+type jwksKeys struct {
+	byID map[string]crypto.PublicKey
+	all  []jwt.VerificationKey
+}
+
+func parseJWKS(data []byte) (*jwksKeys, error) {
+	set := &jsonWebKeySet{}
+	err := json.Unmarshal(data, set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &jwksKeys{
+		byID: make(map[string]crypto.PublicKey),
+		all:  make([]jwt.VerificationKey, 0, len(set.Keys)),
+	}
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Keys we do not understand are skipped, the IdP might offer them for other clients
+			log.Printf("Ignoring JWKS key %d (kid %q): %v", i, jwk.Kid, err)
+			continue
+		}
+		if jwk.Kid != "" {
+			keys.byID[jwk.Kid] = key
+		}
+		keys.all = append(keys.all, key)
+	}
+
+	if len(keys.all) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// Cache holds the keys of a JWKS file or URL, URLs are fetched again after refreshInterval
+// or when a token refers to an unknown key ID
+type Cache struct {
+	source          string
+	refreshInterval time.Duration
+	httpClient      *http.Client
+
+	lock        *sync.Mutex
+	keys        *jwksKeys
+	lastFetch   time.Time
+	lastAttempt time.Time
+}
+
+func NewCache(source string, refreshInterval time.Duration) *Cache {
+	return &Cache{
+		source:          source,
+		refreshInterval: refreshInterval,
+		httpClient: &http.Client{
+			Timeout: jwksFetchTimeout,
+		},
+		lock: &sync.Mutex{},
+	}
+}
+
+func (c *Cache) isURL() bool {
+	return strings.HasPrefix(c.source, "https://") || strings.HasPrefix(c.source, "http://")
+}
+
+func (c *Cache) fetch() ([]byte, error) {
+	if !c.isURL() {
+		return os.ReadFile(c.source)
+	}
+
+	resp, err := c.httpClient.Get(c.source)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS from %s: %s", c.source, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// refresh must be called with lock held
+func (c *Cache) refresh() error {
+	c.lastAttempt = time.Now()
+
+	data, err := c.fetch()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	c.keys = keys
+	c.lastFetch = c.lastAttempt
+	return nil
+}
+
+// Load fetches the keys, it has to succeed once before GetKeys can return any
+func (c *Cache) Load() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.refresh()
+}
+
+// GetKeys returns the key for kid, or all keys if kid is empty, for use in a jwt.Keyfunc
+func (c *Cache) GetKeys(kid string) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	canRefresh := c.isURL() && time.Since(c.lastAttempt) >= jwksMinRefreshInterval
+	needsRefresh := c.refreshInterval > 0 && time.Since(c.lastFetch) >= c.refreshInterval
+	if kid != "" && c.keys != nil && c.keys.byID[kid] == nil {
+		// Key rotation, the IdP might have published a new key
+		needsRefresh = true
+	}
+
+	if canRefresh && needsRefresh {
+		err := c.refresh()
+		if err != nil {
+			// Keep using the keys we have, the IdP might just be down for a moment
+			log.Printf("Error refreshing JWKS: %v", err)
+		}
+	}
+
+	if c.keys == nil {
+		return nil, errors.New("no JWKS loaded")
+	}
+
+	if kid == "" {
+		return jwt.VerificationKeySet{Keys: c.keys.all}, nil
+	}
+	key := c.keys.byID[kid]
+	if key == nil {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,241 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testKeysOnce = &sync.Once{}
+var testKeys map[string]crypto.Signer
+
+// getTestKeys returns one key of every supported type, generated once as RSA keys are slow to generate
+func getTestKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+
+	testKeysOnce.Do(func() {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testKeys = map[string]crypto.Signer{
+			"rsa":     rsaKey,
+			"p256":    p256Key,
+			"p384":    p384Key,
+			"ed25519": ed25519Key,
+		}
+	})
+	return testKeys
+}
+
+func marshalTestKeys(t *testing.T) []byte {
+	t.Helper()
+
+	pubKeys := make(map[string]crypto.PublicKey)
+	for kid, key := range getTestKeys(t) {
+		pubKeys[kid] = key.Public()
+	}
+	data, err := Marshal(pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+type publicKeyWithEqual interface {
+	Equal(crypto.PublicKey) bool
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys, err := parseJWKS(marshalTestKeys(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys.all) != len(getTestKeys(t)) {
+		t.Errorf("expected %d keys, got %d", len(getTestKeys(t)), len(keys.all))
+	}
+	for kid, key := range getTestKeys(t) {
+		parsed := keys.byID[kid]
+		if parsed == nil {
+			t.Errorf("key %s missing", kid)
+			continue
+		}
+		if !parsed.(publicKeyWithEqual).Equal(key.Public()) {
+			t.Errorf("key %s does not match the original", kid)
+		}
+	}
+}
+
+func TestParseJWKSBadKeys(t *testing.T) {
+	// 32 bytes, base64url
+	coordinate := "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+
+	tests := []struct {
+		name string
+		jwk  string
+	}{
+		{"unknown kty", `{"kty": "oct", "k": "c2VjcmV0"}`},
+		{"RSA missing n", `{"kty": "RSA", "e": "AQAB"}`},
+		{"RSA small exponent", `{"kty": "RSA", "n": "` + coordinate + `", "e": "AQ"}`},
+		{"RSA bad base64", `{"kty": "RSA", "n": "!!!", "e": "AQAB"}`},
+		{"EC unknown curve", `{"kty": "EC", "crv": "secp256k1", "x": "` + coordinate + `", "y": "` + coordinate + `"}`},
+		{"EC short coordinate", `{"kty": "EC", "crv": "P-256", "x": "AAEC", "y": "` + coordinate + `"}`},
+		{"EC not on curve", `{"kty": "EC", "crv": "P-256", "x": "` + coordinate + `", "y": "` + coordinate + `"}`},
+		{"OKP unknown curve", `{"kty": "OKP", "crv": "X25519", "x": "` + coordinate + `"}`},
+		{"OKP short key", `{"kty": "OKP", "crv": "Ed25519", "x": "AAEC"}`},
+		{"encryption key", `{"kty": "OKP", "crv": "Ed25519", "use": "enc", "x": "` + coordinate + `"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseJWKS([]byte(`{"keys": [` + test.jwk + `]}`))
+			if err == nil {
+				t.Fatal("expected a JWKS with only this key to be rejected")
+			}
+
+			// Other keys in the same set are still usable
+			keys, err := parseJWKS([]byte(`{"keys": [` + test.jwk + `, {"kty": "OKP", "crv": "Ed25519", "kid": "good", "x": "` + coordinate + `"}]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys.all) != 1 || keys.byID["good"] == nil {
+				t.Fatalf("expected only the good key, got %d keys", len(keys.all))
+			}
+		})
+	}
+
+	_, err := parseJWKS([]byte(`not json`))
+	if err == nil {
+		t.Error("expected invalid JSON to be rejected")
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	err := os.WriteFile(file, marshalTestKeys(t), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewCache(file, time.Hour)
+	_, err = cache.GetKeys("rsa")
+	if err == nil {
+		t.Fatal("expected an error before Load")
+	}
+
+	err = cache.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := cache.GetKeys("p256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.(publicKeyWithEqual).Equal(getTestKeys(t)["p256"].Public()) {
+		t.Error("expected the p256 key")
+	}
+
+	keySet, err := cache.GetKeys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keySet.(jwt.VerificationKeySet).Keys) != len(getTestKeys(t)) {
+		t.Error("expected all keys without a key ID")
+	}
+
+	_, err = cache.GetKeys("unknown")
+	if err == nil {
+		t.Error("expected an error for an unknown key ID")
+	}
+}
+
+func TestCacheURLRefresh(t *testing.T) {
+	oldKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock := &sync.Mutex{}
+	fetches := 0
+	served := map[string]crypto.PublicKey{"old": oldKey}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		fetches++
+		data, err := Marshal(served)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	cache := NewCache(server.URL, 0)
+	err = cache.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	served = map[string]crypto.PublicKey{"old": oldKey, "new": newKey}
+	lock.Unlock()
+
+	// Unknown key IDs only trigger a refetch once jwksMinRefreshInterval has passed
+	_, err = cache.GetKeys("new")
+	if err == nil {
+		t.Fatal("expected the new key to be unknown right after loading")
+	}
+
+	cache.lastAttempt = time.Now().Add(-jwksMinRefreshInterval)
+	_, err = cache.GetKeys("new")
+	if err != nil {
+		t.Fatalf("expected the new key after refreshing, got %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetches)
+	}
+
+	// A failing IdP keeps the keys we already have
+	server.Close()
+	cache.lastAttempt = time.Now().Add(-jwksMinRefreshInterval)
+	_, err = cache.GetKeys("rotated")
+	if err == nil {
+		t.Fatal("expected an error for an unknown key ID")
+	}
+	_, err = cache.GetKeys("old")
+	if err != nil {
+		t.Fatalf("expected the old key to stay usable, got %v", err)
+	}
+}
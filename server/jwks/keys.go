@@ -0,0 +1,135 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// LoadPrivateKey reads a PEM encoded RSA, ECDSA or Ed25519 private key (PKCS#8, PKCS#1 or SEC 1)
+func LoadPrivateKey(file string) (crypto.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.New("unsupported private key format")
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	_, err = SigningMethod(signer)
+	if err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// SigningMethod returns the JWT algorithm to sign with the given key
+func SigningMethod(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported EC curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+// KeyID derives a stable key ID from a public key
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(hash[:16]), nil
+}
+
+func encodeJWKBase64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newJSONWebKey(kid string, pub crypto.PublicKey) (*jsonWebKey, error) {
+	jwk := &jsonWebKey{
+		Kid: kid,
+		Use: "sig",
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeJWKBase64(pub.N.Bytes())
+		jwk.E = encodeJWKBase64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		point, err := pub.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		byteLen := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = encodeJWKBase64(point[1 : 1+byteLen])
+		jwk.Y = encodeJWKBase64(point[1+byteLen:])
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeJWKBase64(pub)
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+	return jwk, nil
+}
+
+// Marshal encodes public keys, keyed by key ID, as a JWKS
+func Marshal(keys map[string]crypto.PublicKey) ([]byte, error) {
+	set := &jsonWebKeySet{
+		Keys: make([]jsonWebKey, 0, len(keys)),
+	}
+	for kid, pub := range keys {
+		jwk, err := newJSONWebKey(kid, pub)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, *jwk)
+	}
+	return json.Marshal(set)
+}
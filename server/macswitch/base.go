@@ -3,8 +3,10 @@ package macswitch
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/shared/sockets"
 	lru "github.com/hashicorp/golang-lru/v2"
 )
@@ -32,6 +34,8 @@ type MACSwitch struct {
 	macLock      *sync.RWMutex
 	cleanupTimer *time.Timer
 	isRunning    bool
+
+	rules atomic.Pointer[acl.ACL]
 }
 
 func MakeMACSwitch() *MACSwitch {
@@ -54,6 +58,10 @@ func MakeMACSwitch() *MACSwitch {
 	return sw
 }
 
+func (g *MACSwitch) SetACL(rules *acl.ACL) {
+	g.rules.Store(rules)
+}
+
 func (g *MACSwitch) ConfigUpdate() {
 	g.macLock.RLock()
 	tables := make([]socketToMACs, 0, len(g.socketTable))
@@ -70,3 +78,12 @@ func (g *MACSwitch) ConfigUpdate() {
 func (g *MACSwitch) Close() {
 	g.isRunning = false
 }
+
+func (g *MACSwitch) GetTableSizes() map[string]int {
+	g.macLock.RLock()
+	defer g.macLock.RUnlock()
+
+	return map[string]int{
+		"mac": len(g.macTable),
+	}
+}
@@ -6,10 +6,11 @@ import (
 	"time"
 
 	"github.com/Doridian/water/waterutil"
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/shared/sockets"
 )
 
-func (g *MACSwitch) broadcastDataMessage(data []byte, skip *sockets.Socket) {
+func (g *MACSwitch) broadcastDataMessage(data []byte, skip *sockets.Socket, rules *acl.ACL, aclPacket *acl.Packet) {
 	g.macLock.RLock()
 	targetList := make([]*sockets.Socket, 0, len(g.socketTable))
 	for sock := range g.socketTable {
@@ -21,6 +22,9 @@ func (g *MACSwitch) broadcastDataMessage(data []byte, skip *sockets.Socket) {
 	g.macLock.RUnlock()
 
 	for _, socket := range targetList {
+		if !rules.Allows(aclPacket, acl.DirectionOut, socket) {
+			continue
+		}
 		_ = socket.WritePacket(data)
 	}
 }
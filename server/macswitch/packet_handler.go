@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/Doridian/water/waterutil"
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/shared/sockets"
 	lru "github.com/hashicorp/golang-lru/v2"
 )
@@ -20,6 +21,12 @@ func (g *MACSwitch) HandlePacket(socket *sockets.Socket, packet []byte) (bool, e
 		return true, nil
 	}
 
+	rules := g.rules.Load()
+	var aclPacket *acl.Packet
+	if rules != nil && (etherType == waterutil.IPv4 || etherType == waterutil.IPv6) {
+		aclPacket = acl.ParsePacket(packet[EthernetLength:])
+	}
+
 	if socket != nil {
 		if !g.setMACFrom(socket, packet) {
 			return true, nil
@@ -47,11 +54,15 @@ func (g *MACSwitch) HandlePacket(socket *sockets.Socket, packet []byte) (bool, e
 				}
 
 				srcIP := waterutil.IPSource(packet[EthernetLength:])
-				if !srcIP.Equal(socket.AssignedIP) {
+				if !socket.IsAllowedSourceIP(srcIP) {
 					return true, nil
 				}
 			}
 		}
+
+		if !rules.Allows(aclPacket, acl.DirectionIn, socket) {
+			return true, nil
+		}
 	}
 
 	if socket == nil || g.AllowClientToClient {
@@ -60,11 +71,13 @@ func (g *MACSwitch) HandlePacket(socket *sockets.Socket, packet []byte) (bool, e
 		if waterutil.IsMACUnicast(destMAC) {
 			socketDest := g.findSocketByMAC(destMAC)
 			if socketDest != nil {
-				_ = socketDest.WritePacket(packet)
+				if rules.Allows(aclPacket, acl.DirectionOut, socketDest) {
+					_ = socketDest.WritePacket(packet)
+				}
 				return true, nil
 			}
 		} else {
-			g.broadcastDataMessage(packet, socket)
+			g.broadcastDataMessage(packet, socket, rules, aclPacket)
 		}
 	}
 
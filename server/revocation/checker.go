@@ -0,0 +1,173 @@
+package revocation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the CRL file is checked for changes
+const crlPollInterval = 10 * time.Second
+
+var ErrRevoked = errors.New("certificate revoked")
+
+type OCSPConfig struct {
+	Enabled  bool
+	Timeout  time.Duration
+	SoftFail bool // Accept certificates if the responder can not be reached or gives no definite answer
+}
+
+// Checker checks client certificate chains against a CRL file and/or OCSP responders
+type Checker struct {
+	crlFile string
+	cas     []*x509.Certificate
+	ocsp    OCSPConfig
+
+	lock       *sync.Mutex
+	crl        *crlSet
+	crlModTime time.Time
+	crlSize    int64
+	ocspCache  map[string]*ocspCacheEntry
+	httpClient *http.Client
+
+	onChange  func()
+	closeChan chan bool
+	closeOnce *sync.Once
+}
+
+// NewChecker loads crlFile (if not empty), CRLs have to be signed by one of cas
+// The CRL file is watched for changes once Watch is called
+func NewChecker(crlFile string, cas []*x509.Certificate, ocspConfig OCSPConfig) (*Checker, error) {
+	c := &Checker{
+		crlFile: crlFile,
+		cas:     cas,
+		ocsp:    ocspConfig,
+
+		lock:      &sync.Mutex{},
+		ocspCache: make(map[string]*ocspCacheEntry),
+		httpClient: &http.Client{
+			Timeout: ocspConfig.Timeout,
+		},
+
+		closeChan: make(chan bool),
+		closeOnce: &sync.Once{},
+	}
+
+	if crlFile != "" {
+		_, err := c.reloadCRL()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Watch reloads the CRL file whenever it changes, calling onChange after every successful reload
+func (c *Checker) Watch(onChange func()) {
+	if c.crlFile == "" {
+		return
+	}
+
+	c.onChange = onChange
+	go func() {
+		ticker := time.NewTicker(crlPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.closeChan:
+				return
+			}
+
+			changed, err := c.reloadCRL()
+			if err != nil {
+				log.Printf("Error reloading CRL %s, keeping the previous one: %v", c.crlFile, err)
+				continue
+			}
+			if changed {
+				log.Printf("Reloaded CRL %s", c.crlFile)
+				c.onChange()
+			}
+		}
+	}()
+}
+
+// Close stops watching the CRL file
+func (c *Checker) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
+	return nil
+}
+
+// reloadCRL reads the CRL file again if its size or modification time changed
+func (c *Checker) reloadCRL() (bool, error) {
+	stat, err := os.Stat(c.crlFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.Lock()
+	unchanged := c.crl != nil && stat.ModTime().Equal(c.crlModTime) && stat.Size() == c.crlSize
+	c.lock.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	crl, err := loadCRLFile(c.crlFile, c.cas)
+	if err != nil {
+		return false, err
+	}
+
+	c.lock.Lock()
+	c.crl = crl
+	c.crlModTime = stat.ModTime()
+	c.crlSize = stat.Size()
+	c.lock.Unlock()
+	return true, nil
+}
+
+// Check returns an error wrapping ErrRevoked if any certificate of the chain (leaf first) is revoked
+func (c *Checker) Check(chain []*x509.Certificate) error {
+	// The last certificate is the root, which can not be revoked by anyone
+	for i := 0; i < len(chain)-1; i++ {
+		cert := chain[i]
+		issuer := chain[i+1]
+
+		c.lock.Lock()
+		crl := c.crl
+		c.lock.Unlock()
+		if crl != nil && crl.isRevoked(cert) {
+			return fmt.Errorf("%w: serial %s of %s (CRL)", ErrRevoked, cert.SerialNumber.String(), cert.Subject.String())
+		}
+
+		if c.ocsp.Enabled {
+			err := c.checkOCSP(cert, issuer)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// VerifyConnection can be used as tls.Config.VerifyConnection
+// Unlike VerifyPeerCertificate it also runs for resumed sessions, so session tickets can not skip the check
+func (c *Checker) VerifyConnection(state tls.ConnectionState) error {
+	// Any valid chain that is not revoked is good enough
+	var err error
+	for _, chain := range state.VerifiedChains {
+		err = c.Check(chain)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package revocation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// crlSet holds the revoked serial numbers of every CRL, keyed by the raw issuer
+type crlSet struct {
+	revoked map[string]map[string]bool
+}
+
+func loadCRLFile(file string, cas []*x509.Certificate) (*crlSet, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ders := make([][]byte, 0)
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type == "X509 CRL" {
+				ders = append(ders, block.Bytes)
+			}
+		}
+	} else {
+		ders = append(ders, data)
+	}
+	if len(ders) == 0 {
+		return nil, errors.New("no CRLs found")
+	}
+
+	set := &crlSet{
+		revoked: make(map[string]map[string]bool),
+	}
+	for i, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("CRL %d: %v", i, err)
+		}
+
+		err = verifyCRL(crl, cas)
+		if err != nil {
+			return nil, fmt.Errorf("CRL %d (%s): %v", i, crl.Issuer.String(), err)
+		}
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			log.Printf("CRL %d (%s) is outdated since %s, using it anyway", i, crl.Issuer.String(), crl.NextUpdate.String())
+		}
+
+		issuer := string(crl.RawIssuer)
+		serials := set.revoked[issuer]
+		if serials == nil {
+			serials = make(map[string]bool)
+			set.revoked[issuer] = serials
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			serials[entry.SerialNumber.String()] = true
+		}
+	}
+	return set, nil
+}
+
+func verifyCRL(crl *x509.RevocationList, cas []*x509.Certificate) error {
+	for _, ca := range cas {
+		if !bytes.Equal(ca.RawSubject, crl.RawIssuer) {
+			continue
+		}
+		if crl.CheckSignatureFrom(ca) == nil {
+			return nil
+		}
+	}
+	return errors.New("not signed by any CA in client-ca")
+}
+
+func (s *crlSet) isRevoked(cert *x509.Certificate) bool {
+	return s.revoked[string(cert.RawIssuer)][cert.SerialNumber.String()]
+}
@@ -0,0 +1,123 @@
+package revocation
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const maxOCSPResponseSize = 64 * 1024
+
+// How long to remember a response without a next update time
+const ocspDefaultCacheTime = 5 * time.Minute
+
+type ocspCacheEntry struct {
+	status  int
+	expires time.Time
+}
+
+func ocspCacheKey(cert *x509.Certificate) string {
+	return string(cert.RawIssuer) + "\x00" + cert.SerialNumber.String()
+}
+
+func (c *Checker) checkOCSP(cert *x509.Certificate, issuer *x509.Certificate) error {
+	if len(cert.OCSPServer) == 0 {
+		return nil
+	}
+
+	status, err := c.getOCSPStatus(cert, issuer)
+	if err == nil && status == ocsp.Unknown {
+		err = errors.New("responder does not know the certificate")
+	}
+	if err != nil {
+		err = fmt.Errorf("OCSP check of serial %s of %s failed: %v", cert.SerialNumber.String(), cert.Subject.String(), err)
+		if c.ocsp.SoftFail {
+			log.Printf("%v, accepting it anyway", err)
+			return nil
+		}
+		return err
+	}
+
+	if status == ocsp.Revoked {
+		return fmt.Errorf("%w: serial %s of %s (OCSP)", ErrRevoked, cert.SerialNumber.String(), cert.Subject.String())
+	}
+	return nil
+}
+
+func (c *Checker) getOCSPStatus(cert *x509.Certificate, issuer *x509.Certificate) (int, error) {
+	key := ocspCacheKey(cert)
+	now := time.Now()
+
+	c.lock.Lock()
+	for otherKey, entry := range c.ocspCache {
+		if now.After(entry.expires) {
+			delete(c.ocspCache, otherKey)
+		}
+	}
+	entry := c.ocspCache[key]
+	c.lock.Unlock()
+	if entry != nil {
+		return entry.status, nil
+	}
+
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var lastErr error
+	for _, server := range cert.OCSPServer {
+		var resp *ocsp.Response
+		resp, lastErr = c.queryOCSP(server, request, cert, issuer)
+		if lastErr != nil {
+			continue
+		}
+
+		expires := resp.NextUpdate
+		if expires.IsZero() {
+			expires = now.Add(ocspDefaultCacheTime)
+		}
+		c.lock.Lock()
+		c.ocspCache[key] = &ocspCacheEntry{
+			status:  resp.Status,
+			expires: expires,
+		}
+		c.lock.Unlock()
+		return resp.Status, nil
+	}
+	return 0, lastErr
+}
+
+func (c *Checker) queryOCSP(server string, request []byte, cert *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	httpResp, err := c.httpClient.Post(server, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = httpResp.Body.Close()
+	}()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("responder %s: %s", server, httpResp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	// Old responses could be replayed by anyone on the way to a plain HTTP responder
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		return nil, fmt.Errorf("responder %s: response expired at %s", server, resp.NextUpdate.String())
+	}
+	return resp, nil
+}
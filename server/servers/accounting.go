@@ -0,0 +1,57 @@
+package servers
+
+import (
+	"time"
+
+	"github.com/Doridian/wsvpn/server/authenticators"
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+// startAccounting reports a connection to the accounting of its authenticator (if any)
+// The returned function has to be called once the connection is closed
+func (s *Server) startAccounting(details *authenticators.AuthDetails, socket *sockets.Socket, record *authenticators.AccountingRecord) func(authenticators.AccountingTerminateCause) {
+	if details.Accounting == nil {
+		return func(authenticators.AccountingTerminateCause) {}
+	}
+
+	record.Started = socket.GetConnectedSince()
+	stopChan := make(chan authenticators.AccountingTerminateCause, 1)
+
+	// Records are copied for every call, so accounting implementations may hold on to them
+	makeRecord := func() *authenticators.AccountingRecord {
+		stats := socket.GetStats()
+		current := *record
+		current.BytesIn = stats.BytesReceived
+		current.BytesOut = stats.BytesSent
+		current.PacketsIn = stats.PacketsReceived
+		current.PacketsOut = stats.PacketsSent
+		return &current
+	}
+
+	go func() {
+		details.Accounting.Start(makeRecord())
+
+		var interimChan <-chan time.Time
+		if details.AccountingInterval > 0 {
+			interimTicker := time.NewTicker(details.AccountingInterval)
+			defer interimTicker.Stop()
+			interimChan = interimTicker.C
+		}
+
+		for {
+			select {
+			case <-interimChan:
+				details.Accounting.Interim(makeRecord())
+			case cause := <-stopChan:
+				stopRecord := makeRecord()
+				stopRecord.TerminateCause = cause
+				details.Accounting.Stop(stopRecord)
+				return
+			}
+		}
+	}()
+
+	return func(cause authenticators.AccountingTerminateCause) {
+		stopChan <- cause
+	}
+}
@@ -0,0 +1,163 @@
+package servers
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Doridian/wsvpn/server/authenticators"
+	"github.com/Doridian/wsvpn/shared/sockets"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+const testRadiusSecret = "radius-secret"
+
+// startTestRadiusServer runs an in-process RADIUS server accepting everyone and sends accounting requests to the returned channel
+func startTestRadiusServer(t *testing.T) (string, <-chan *radius.Packet) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountingRequests := make(chan *radius.Packet, 100)
+	server := &radius.PacketServer{
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			if r.Code == radius.CodeAccountingRequest {
+				accountingRequests <- r.Packet
+				_ = w.Write(r.Response(radius.CodeAccountingResponse))
+				return
+			}
+			_ = w.Write(r.Response(radius.CodeAccessAccept))
+		}),
+		SecretSource: radius.StaticSecretSource([]byte(testRadiusSecret)),
+	}
+	go func() {
+		_ = server.Serve(conn)
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown(context.Background())
+	})
+	return conn.LocalAddr().String(), accountingRequests
+}
+
+// newTestRadiusDetails logs in with an authenticator pointed at addr for both authentication and accounting
+func newTestRadiusDetails(t *testing.T, addr string) *authenticators.AuthDetails {
+	t.Helper()
+
+	configFile := filepath.Join(t.TempDir(), "radius.yml")
+	err := os.WriteFile(configFile, []byte("server: "+addr+"\nsecret: "+testRadiusSecret+"\ntimeout: 2s\naccounting-server: "+addr+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &authenticators.RadiusAuthenticator{}
+	err = a.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "alice-pw")
+	result, _, details := a.AuthenticateWithDetails(r, httptest.NewRecorder())
+	if result != authenticators.AuthOk || details.Accounting == nil {
+		t.Fatalf("expected alice to be accepted with accounting, got %v", result)
+	}
+	return details
+}
+
+func waitAccountingRequest(t *testing.T, accountingRequests <-chan *radius.Packet) *radius.Packet {
+	t.Helper()
+
+	select {
+	case request := <-accountingRequests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an accounting request")
+		return nil
+	}
+}
+
+func TestAccountingSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		cause    authenticators.AccountingTerminateCause
+		expected rfc2866.AcctTerminateCause
+	}{
+		{"user request", authenticators.TerminateUserRequest, rfc2866.AcctTerminateCause_Value_UserRequest},
+		{"session timeout", authenticators.TerminateSessionTimeout, rfc2866.AcctTerminateCause_Value_SessionTimeout},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr, accountingRequests := startTestRadiusServer(t)
+			details := newTestRadiusDetails(t, addr)
+			details.AccountingInterval = 50 * time.Millisecond
+
+			s := &Server{}
+			socket := sockets.MakeSocket(log.New(os.Stderr, "", 0), nil, nil, false, nil)
+			stopAccounting := s.startAccounting(details, socket, &authenticators.AccountingRecord{
+				SessionID:  "session-1",
+				Username:   "alice",
+				RemoteAddr: "203.0.113.7:51234",
+				VPNIP:      net.IPv4(192, 168, 3, 2),
+			})
+
+			start := waitAccountingRequest(t, accountingRequests)
+			if rfc2866.AcctStatusType_Get(start) != rfc2866.AcctStatusType_Value_Start {
+				t.Fatalf("expected Start first, got %v", rfc2866.AcctStatusType_Get(start))
+			}
+			if rfc2866.AcctSessionID_GetString(start) != "session-1" || rfc2865.UserName_GetString(start) != "alice" {
+				t.Errorf("unexpected session %q or user %q", rfc2866.AcctSessionID_GetString(start), rfc2865.UserName_GetString(start))
+			}
+
+			interim := waitAccountingRequest(t, accountingRequests)
+			if rfc2866.AcctStatusType_Get(interim) != rfc2866.AcctStatusType_Value_InterimUpdate {
+				t.Fatalf("expected Interim-Update after Start, got %v", rfc2866.AcctStatusType_Get(interim))
+			}
+
+			stopAccounting(test.cause)
+
+			// Interim updates may still be on their way, Stop must be the last request
+			var stop *radius.Packet
+			for stop == nil {
+				request := waitAccountingRequest(t, accountingRequests)
+				switch rfc2866.AcctStatusType_Get(request) {
+				case rfc2866.AcctStatusType_Value_InterimUpdate:
+				case rfc2866.AcctStatusType_Value_Stop:
+					stop = request
+				default:
+					t.Fatalf("unexpected accounting request %v", rfc2866.AcctStatusType_Get(request))
+				}
+			}
+			if rfc2866.AcctTerminateCause_Get(stop) != test.expected {
+				t.Errorf("expected terminate cause %v, got %v", test.expected, rfc2866.AcctTerminateCause_Get(stop))
+			}
+			if rfc2866.AcctSessionID_GetString(stop) != "session-1" {
+				t.Errorf("unexpected session %q", rfc2866.AcctSessionID_GetString(stop))
+			}
+
+			select {
+			case request := <-accountingRequests:
+				t.Errorf("unexpected accounting request %v after Stop", rfc2866.AcctStatusType_Get(request))
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestAccountingWithoutAccounting(t *testing.T) {
+	s := &Server{}
+	socket := sockets.MakeSocket(log.New(os.Stderr, "", 0), nil, nil, false, nil)
+	stopAccounting := s.startAccounting(&authenticators.AuthDetails{}, socket, &authenticators.AccountingRecord{})
+	stopAccounting(authenticators.TerminateUserRequest)
+}
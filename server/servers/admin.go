@@ -0,0 +1,142 @@
+package servers
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Doridian/wsvpn/shared"
+)
+
+const authFailureSourceAdmin = "admin"
+
+const adminUnixPrefix = "unix:"
+
+func (s *Server) isAdminUnixSocket() bool {
+	return strings.HasPrefix(s.AdminListenAddr, adminUnixPrefix)
+}
+
+// CheckAdminAuthentication refuses admin listeners on TCP without tokens or mTLS, anyone who can reach those would have full access
+// Unix sockets are protected by their file permissions instead
+func CheckAdminAuthentication(listenAddr string, hasTokens bool, hasMTLS bool) error {
+	if listenAddr == "" || strings.HasPrefix(listenAddr, adminUnixPrefix) || hasTokens || hasMTLS {
+		return nil
+	}
+	return fmt.Errorf("admin listener %s needs tokens or a client CA, only unix sockets can go without", listenAddr)
+}
+
+func (s *Server) listenAdmin() {
+	network := "tcp"
+	address := s.AdminListenAddr
+	if s.isAdminUnixSocket() {
+		network = "unix"
+		address = address[len(adminUnixPrefix):]
+
+		// Remove a leftover socket of an earlier run, but never any other kind of file
+		stat, err := os.Stat(address)
+		if err == nil && stat.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(address)
+		}
+	}
+
+	tlsConfigTemp := s.AdminTLSConfig
+	if tlsConfigTemp != nil && tlsConfigTemp.GetConfigForClient != nil {
+		tlsConfigTemp, _ = tlsConfigTemp.GetConfigForClient(nil)
+	}
+	mTLSEnabled := tlsConfigTemp != nil && tlsConfigTemp.ClientAuth == tls.RequireAndVerifyClientCert
+
+	err := CheckAdminAuthentication(s.AdminListenAddr, len(s.AdminTokens) > 0, mTLSEnabled)
+	if err != nil {
+		s.setServeError(err)
+		return
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		s.setServeError(err)
+		return
+	}
+
+	if network == "unix" {
+		// Do not rely on the umask, only the user running the server may connect
+		err = os.Chmod(address, 0600)
+		if err != nil {
+			_ = listener.Close()
+			s.setServeError(err)
+			return
+		}
+	}
+
+	s.log.Printf("Admin API online at %s (TLS %s, mTLS %s, tokens %s)",
+		s.AdminListenAddr, shared.BoolToEnabled(s.AdminTLSConfig != nil), shared.BoolToEnabled(mTLSEnabled), shared.BoolToEnabled(len(s.AdminTokens) > 0))
+
+	server := &http.Server{
+		Handler:           http.HandlerFunc(s.serveAdmin),
+		TLSConfig:         s.AdminTLSConfig,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+	}
+	s.addCloser(server)
+
+	s.serveWaitGroup.Add(1)
+	go func() {
+		defer s.serveWaitGroup.Done()
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		s.setServeError(err)
+	}()
+}
+
+// checkAdminAuth allows clients with a verified certificate or one of AdminTokens as bearer token
+// If neither mTLS nor tokens are configured, everyone is allowed on unix sockets and nobody on TCP
+// With mTLS the handshake already fails without a valid certificate, so no tokens means no further checks
+func (s *Server) checkAdminAuth(w http.ResponseWriter, r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	tokens := s.AdminTokens
+	if len(tokens) == 0 && s.isAdminUnixSocket() {
+		return true
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) > 7 && strings.EqualFold(authHeader[:7], "Bearer ") {
+		token := []byte(authHeader[7:])
+		for _, allowedToken := range tokens {
+			if subtle.ConstantTimeCompare(token, allowedToken) == 1 {
+				return true
+			}
+		}
+	}
+
+	s.authFailed(r, authFailureSourceAdmin, "invalid admin token")
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// serveAdmin serves the API and metrics on the admin listener, they do not need to be enabled on the VPN listener for this
+func (s *Server) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api") {
+		s.handleAPI(w, r, true)
+		return
+	}
+
+	if r.URL.Path == "/metrics" {
+		s.handleMetrics(w, r)
+		return
+	}
+
+	http.Error(w, "Not found", http.StatusNotFound)
+}
@@ -1,51 +1,190 @@
 package servers
 
 import (
+	"crypto"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/Doridian/wsvpn/server/authenticators"
+	"github.com/Doridian/wsvpn/server/certidentity"
+	"github.com/Doridian/wsvpn/server/jwks"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 const rootRoutePreauthorize = "/preauthorize"
 const prefixRoutePreauthorize = "/preauthorize/"
 
-func (s *Server) handleSocketAuth(logger *log.Logger, w http.ResponseWriter, r *http.Request, tlsState *tls.ConnectionState) (bool, string) {
+const routeJWKS = "/.well-known/jwks.json"
+
+const defaultPreauthorizeTokenLifetime = time.Minute
+
+var preauthorizeAlgorithms = []string{
+	jwt.SigningMethodHS256.Alg(),
+	jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg(),
+	jwt.SigningMethodPS256.Alg(), jwt.SigningMethodPS384.Alg(), jwt.SigningMethodPS512.Alg(),
+	jwt.SigningMethodES256.Alg(), jwt.SigningMethodES384.Alg(), jwt.SigningMethodES512.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// preauthorizedDetails keeps what the authenticator told us about a user until their token expires
+type preauthorizedDetails struct {
+	details *authenticators.AuthDetails
+	expires time.Time
+}
+
+// preauthorizeClaims are the claims of preauthorization tokens, IP and Groups may also be set by other
+// services minting tokens with a trusted key
+type preauthorizeClaims struct {
+	jwt.RegisteredClaims
+	IP     string   `json:"ip,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+type preauthorizeKey struct {
+	signer crypto.Signer
+	id     string
+	method jwt.SigningMethod
+	jwks   []byte
+}
+
+// SetPreauthorizeKey sets the private key to sign preauthorization tokens with instead of PreauthorizeSecret,
+// its public key is published at /.well-known/jwks.json
+func (s *Server) SetPreauthorizeKey(signer crypto.Signer) error {
+	if signer == nil {
+		s.preauthorizeKey = nil
+		return nil
+	}
+
+	method, err := jwks.SigningMethod(signer)
+	if err != nil {
+		return err
+	}
+	keyID, err := jwks.KeyID(signer.Public())
+	if err != nil {
+		return err
+	}
+	keySet, err := jwks.Marshal(map[string]crypto.PublicKey{keyID: signer.Public()})
+	if err != nil {
+		return err
+	}
+
+	s.preauthorizeKey = &preauthorizeKey{
+		signer: signer,
+		id:     keyID,
+		method: method,
+		jwks:   keySet,
+	}
+	return nil
+}
+
+// canIssuePreauthorizeTokens is true if we have a secret or key to sign tokens with
+func (s *Server) canIssuePreauthorizeTokens() bool {
+	return len(s.PreauthorizeSecret) > 0 || s.preauthorizeKey != nil
+}
+
+// acceptsPreauthorizeTokens is true if there is any secret or key to verify tokens with
+func (s *Server) acceptsPreauthorizeTokens() bool {
+	return s.canIssuePreauthorizeTokens() || s.PreauthorizeTrustedKeys != nil
+}
+
+func (s *Server) getPreauthorizeLifetime() time.Duration {
+	if s.PreauthorizeLifetime <= 0 {
+		return defaultPreauthorizeTokenLifetime
+	}
+	return s.PreauthorizeLifetime
+}
+
+func (s *Server) handleSocketAuth(logger *log.Logger, w http.ResponseWriter, r *http.Request, tlsState *tls.ConnectionState) (bool, string, *authenticators.AuthDetails) {
 	tlsUsername := ""
-	if tlsState != nil && len(tlsState.PeerCertificates) > 0 {
-		tlsUsername = tlsState.PeerCertificates[0].Subject.CommonName
+	var tlsGroups []string
+	hasCertificate := tlsState != nil && len(tlsState.PeerCertificates) > 0
+	if hasCertificate {
+		identityMapper := s.CertificateIdentity
+		if identityMapper == nil {
+			identityMapper = certidentity.DefaultMapper
+		}
+		tlsUsername = identityMapper.Username(tlsState.PeerCertificates[0])
+		tlsGroups = identityMapper.Groups(tlsState.PeerCertificates[0])
+	}
+
+	if hasCertificate && tlsUsername == "" {
+		http.Error(w, "Client certificate has no usable identity", http.StatusUnauthorized)
+		logger.Printf("Client certificate has no usable identity")
+		s.authFailed(r, authFailureSourceMTLS, "no identity in client certificate")
+		return false, "", nil
 	}
 
 	if s.TLSConfig != nil && s.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert && tlsUsername == "" {
 		http.Error(w, "Mutual TLS required but no certificate given", http.StatusUnauthorized)
 		logger.Printf("Mutual TLS required but no certificate given")
-		return false, ""
+		s.authFailed(r, authFailureSourceMTLS, "no client certificate")
+		return false, "", nil
 	}
 
-	authResult, authUsername := s.Authenticator.Authenticate(r, w)
+	authResult, authUsername, authDetails := authenticators.AuthenticateWithDetails(s.Authenticator, r, w)
 	if authResult != authenticators.AuthOk {
 		if authResult == authenticators.AuthFailedDefault {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		}
 		logger.Printf("Client failed authenticator challenge")
-		return false, ""
+		s.authFailed(r, s.Authenticator.Name(), "authenticator rejected client")
+		// Only wrong credentials count towards lockouts, not the initial challenge without any or custom responses (like RADIUS challenges)
+		if authResult == authenticators.AuthFailedDefault && r.Header.Get("Authorization") != "" {
+			s.recordLoginFailure(r)
+		}
+		return false, "", nil
 	}
 
 	if authUsername != "" && tlsUsername != "" && authUsername != tlsUsername {
-		http.Error(w, "Mismatch between MTLS CN and authenticator username", http.StatusUnauthorized)
-		logger.Printf("Mismatch between MTLS CN and authenticator username")
-		return false, ""
+		http.Error(w, "Mismatch between MTLS identity and authenticator username", http.StatusUnauthorized)
+		logger.Printf("Mismatch between MTLS identity %q and authenticator username %q", tlsUsername, authUsername)
+		s.authFailed(r, authFailureSourceMTLS, "certificate identity does not match authenticator username")
+		return false, "", nil
+	}
+
+	if len(tlsGroups) > 0 {
+		groups := make([]string, 0, len(authDetails.Groups)+len(tlsGroups))
+		groups = append(groups, authDetails.Groups...)
+		authDetails.Groups = append(groups, tlsGroups...)
 	}
 
 	if authUsername == "" {
-		return true, tlsUsername
+		source := s.Authenticator.Name()
+		if tlsUsername != "" {
+			source = authFailureSourceMTLS
+		}
+		s.authSucceeded(r, source, tlsUsername)
+		return true, tlsUsername, authDetails
 	}
 
-	return true, authUsername
+	s.authSucceeded(r, s.Authenticator.Name(), authUsername)
+	return true, authUsername, authDetails
+}
+
+func (s *Server) authSucceeded(r *http.Request, source string, username string) {
+	s.clearLoginFailures(r, username)
+	s.publishEvent(&Event{
+		Type:       EventTypeAuthSuccess,
+		Username:   username,
+		RemoteAddr: r.RemoteAddr,
+		Source:     source,
+	})
+}
+
+func (s *Server) authFailed(r *http.Request, source string, reason string) {
+	s.recordAuthFailure(source)
+	s.publishEvent(&Event{
+		Type:       EventTypeAuthFailure,
+		RemoteAddr: r.RemoteAddr,
+		Source:     source,
+		Reason:     reason,
+	})
 }
 
 type preauthorizeResponse struct {
@@ -65,32 +204,152 @@ func sendPreauthorizedResponse(w http.ResponseWriter, r *http.Request, resp *pre
 	_, _ = w.Write(d)
 }
 
-func (s *Server) handlePreauthorizeToken(logger *log.Logger, w http.ResponseWriter, r *http.Request, token string) (bool, string) {
-	jwtToken, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.PreauthorizeSecret, nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+func (s *Server) addPreauthorizedDetails(tokenID string, details *authenticators.AuthDetails, expires time.Time) {
+	s.preauthorizeLock.Lock()
+	defer s.preauthorizeLock.Unlock()
+
+	now := time.Now()
+	for otherID, entry := range s.preauthorizedDetails {
+		if now.After(entry.expires) {
+			delete(s.preauthorizedDetails, otherID)
+		}
+	}
+
+	s.preauthorizedDetails[tokenID] = &preauthorizedDetails{
+		details: details,
+		expires: expires,
+	}
+}
+
+// redeemPreauthorizeToken marks a token ID as used, returning false if it was used before
+// The details stored when issuing it are returned as well (nil for tokens minted elsewhere)
+func (s *Server) redeemPreauthorizeToken(tokenID string, expires time.Time) (bool, *authenticators.AuthDetails) {
+	s.preauthorizeLock.Lock()
+	defer s.preauthorizeLock.Unlock()
+
+	now := time.Now()
+	for otherID, otherExpires := range s.usedPreauthorizeTokens {
+		if now.After(otherExpires) {
+			delete(s.usedPreauthorizeTokens, otherID)
+		}
+	}
+
+	_, used := s.usedPreauthorizeTokens[tokenID]
+	if used {
+		return false, nil
+	}
+	s.usedPreauthorizeTokens[tokenID] = expires
+
+	entry := s.preauthorizedDetails[tokenID]
+	if entry == nil {
+		return true, nil
+	}
+	delete(s.preauthorizedDetails, tokenID)
+	return true, entry.details
+}
+
+// getPreauthorizeVerificationKey also returns whether the key is one of PreauthorizeTrustedKeys instead of our own
+func (s *Server) getPreauthorizeVerificationKey(token *jwt.Token) (interface{}, bool, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		if len(s.PreauthorizeSecret) == 0 {
+			return nil, false, errors.New("HS256 tokens are not accepted without preauthorize-secret")
+		}
+		return s.PreauthorizeSecret, false, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key := s.preauthorizeKey
+	if key != nil && kid == key.id {
+		return key.signer.Public(), false, nil
+	}
+	if s.PreauthorizeTrustedKeys != nil {
+		trustedKey, err := s.PreauthorizeTrustedKeys.GetKeys(kid)
+		return trustedKey, true, err
+	}
+	if key != nil && kid == "" {
+		return key.signer.Public(), false, nil
+	}
+	return nil, false, errors.New("no key to verify token with")
+}
+
+func (s *Server) handlePreauthorizeToken(logger *log.Logger, w http.ResponseWriter, r *http.Request, token string) (bool, string, *authenticators.AuthDetails) {
+	claims := &preauthorizeClaims{}
+	isTrustedKey := false
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		key, trusted, err := s.getPreauthorizeVerificationKey(token)
+		isTrustedKey = trusted
+		return key, err
+	}, jwt.WithValidMethods(preauthorizeAlgorithms), jwt.WithExpirationRequired())
+
+	// Trusted keys might sign tokens for other applications as well, so only accept the ones meant for us
+	if err == nil && isTrustedKey {
+		err = jwt.NewValidator(jwt.WithAudience(s.PreauthorizeAudience), jwt.WithIssuer(s.PreauthorizeIssuer),
+			jwt.WithExpirationRequired()).Validate(claims)
+	}
 
 	if err != nil {
 		logger.Printf("JWT parsing failed: %v", err)
+		s.authFailed(r, authFailureSourcePreauthorize, "invalid token")
 		http.Error(w, "Failed to parse JWT", http.StatusBadRequest)
-		return false, ""
+		return false, "", nil
 	}
 
-	subject, err := jwtToken.Claims.GetSubject()
-	if err != nil {
-		logger.Printf("JWT reading failed: %v", err)
+	if claims.Subject == "" || claims.ID == "" {
+		logger.Printf("JWT is missing sub or jti claim")
+		s.authFailed(r, authFailureSourcePreauthorize, "invalid token claims")
 		http.Error(w, "Failed to read JWT", http.StatusBadRequest)
-		return false, ""
+		return false, "", nil
+	}
+
+	var framedIP net.IP
+	if claims.IP != "" {
+		framedIP = net.ParseIP(claims.IP)
+		if framedIP == nil {
+			logger.Printf("JWT has invalid ip claim %q", claims.IP)
+			s.authFailed(r, authFailureSourcePreauthorize, "invalid token claims")
+			http.Error(w, "Failed to read JWT", http.StatusBadRequest)
+			return false, "", nil
+		}
 	}
 
-	return true, subject
+	redeemed, details := s.redeemPreauthorizeToken(claims.ID, claims.ExpiresAt.Time)
+	if !redeemed {
+		logger.Printf("JWT %s was already used", claims.ID)
+		s.authFailed(r, authFailureSourcePreauthorize, "token already used")
+		http.Error(w, "Token already used", http.StatusUnauthorized)
+		return false, "", nil
+	}
+
+	// Tokens issued by this server carry everything the authenticator told us, others only what is in the claims
+	if details == nil {
+		details = &authenticators.AuthDetails{
+			Groups:   claims.Groups,
+			FramedIP: framedIP,
+		}
+	}
+
+	s.authSucceeded(r, authFailureSourcePreauthorize, claims.Subject)
+	return true, claims.Subject, details
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	key := s.preauthorizeKey
+	if key == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(key.jwks)
 }
 
 func (s *Server) handlePreauthorize(logger *log.Logger, w http.ResponseWriter, r *http.Request, tlsState *tls.ConnectionState) {
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "POST")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Authorization, "+authenticators.RadiusChallengeHeader)
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		_, _ = w.Write([]byte("OK"))
 		return
@@ -101,12 +360,12 @@ func (s *Server) handlePreauthorize(logger *log.Logger, w http.ResponseWriter, r
 		return
 	}
 
-	if len(s.PreauthorizeSecret) == 0 {
+	if !s.canIssuePreauthorizeTokens() {
 		http.Error(w, "Preauthorization is not enabled", http.StatusForbidden)
 		return
 	}
 
-	authOk, authUsername := s.handleSocketAuth(s.log, w, r, tlsState)
+	authOk, authUsername, authDetails := s.handleSocketAuth(s.log, w, r, tlsState)
 	if !authOk {
 		return
 	}
@@ -119,18 +378,52 @@ func (s *Server) handlePreauthorize(logger *log.Logger, w http.ResponseWriter, r
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   authUsername,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute * 1)),
-	})
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		logger.Printf("JWT ID generation failed: %v", err)
+		http.Error(w, "Failed to sign JWT", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	expires := now.Add(s.getPreauthorizeLifetime())
+	claims := &preauthorizeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID.String(),
+			Subject:   authUsername,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+		Groups: authDetails.Groups,
+	}
+	if s.PreauthorizeIssuer != "" {
+		claims.Issuer = s.PreauthorizeIssuer
+	}
+	if s.PreauthorizeAudience != "" {
+		claims.Audience = jwt.ClaimStrings{s.PreauthorizeAudience}
+	}
+	// Other servers accepting our tokens can not know the details, so pass on what fits into claims
+	if authDetails.FramedIP != nil {
+		claims.IP = authDetails.FramedIP.String()
+	}
 
-	signedToken, err := token.SignedString(s.PreauthorizeSecret)
+	var signedToken string
+	key := s.preauthorizeKey
+	if key != nil {
+		token := jwt.NewWithClaims(key.method, claims)
+		token.Header["kid"] = key.id
+		signedToken, err = token.SignedString(key.signer)
+	} else {
+		signedToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.PreauthorizeSecret)
+	}
 	if err != nil {
 		logger.Printf("JWT signing failed: %v", err)
 		http.Error(w, "Failed to sign JWT", http.StatusInternalServerError)
 		return
 	}
 
+	s.addPreauthorizedDetails(tokenID.String(), authDetails, expires)
+
 	sendPreauthorizedResponse(w, r, &preauthorizeResponse{
 		Success: true,
 		Token:   signedToken,
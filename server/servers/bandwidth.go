@@ -0,0 +1,104 @@
+package servers
+
+import (
+	"github.com/Doridian/wsvpn/shared/ratelimit"
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+// BandwidthLimits are in bytes per second, 0 means unlimited
+// Upload is traffic sent by clients, Download is traffic sent to clients
+type BandwidthLimits struct {
+	PerConnectionUpload   uint64
+	PerConnectionDownload uint64
+	PerUserUpload         uint64
+	PerUserDownload       uint64
+}
+
+type userLimiters struct {
+	upload   *ratelimit.Limiter
+	download *ratelimit.Limiter
+}
+
+// SetBandwidthLimits replaces the bandwidth limits and applies them to all connected clients
+// userLimits overrides defaultLimits for the given usernames, per-user limits are shared by all connections of a user
+func (s *Server) SetBandwidthLimits(defaultLimits BandwidthLimits, userLimits map[string]BandwidthLimits) {
+	s.socketsLock.Lock()
+	defer s.socketsLock.Unlock()
+
+	s.bandwidthLimits = defaultLimits
+	s.userBandwidthLimits = userLimits
+
+	for _, socket := range s.sockets {
+		s.applyBandwidthLimits(socket)
+	}
+}
+
+func updateLimiter(limiter *ratelimit.Limiter, bytesPerSecond uint64) *ratelimit.Limiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	if limiter == nil {
+		return ratelimit.NewLimiter(bytesPerSecond)
+	}
+	limiter.SetRate(bytesPerSecond)
+	return limiter
+}
+
+// applyBandwidthLimits must be called with socketsLock held
+func (s *Server) applyBandwidthLimits(socket *sockets.Socket) {
+	username, _ := socket.Metadata["username"].(string)
+
+	limits, ok := s.userBandwidthLimits[username]
+	if !ok || username == "" {
+		limits = s.bandwidthLimits
+	}
+
+	limiters := &sockets.BandwidthLimiters{
+		Upload:   make([]*ratelimit.Limiter, 0, 2),
+		Download: make([]*ratelimit.Limiter, 0, 2),
+	}
+
+	if limits.PerConnectionUpload > 0 {
+		limiters.Upload = append(limiters.Upload, ratelimit.NewLimiter(limits.PerConnectionUpload))
+	}
+	if limits.PerConnectionDownload > 0 {
+		limiters.Download = append(limiters.Download, ratelimit.NewLimiter(limits.PerConnectionDownload))
+	}
+
+	if username != "" {
+		userLimiter := s.userLimiters[username]
+		if userLimiter == nil {
+			userLimiter = &userLimiters{}
+			s.userLimiters[username] = userLimiter
+		}
+
+		userLimiter.upload = updateLimiter(userLimiter.upload, limits.PerUserUpload)
+		userLimiter.download = updateLimiter(userLimiter.download, limits.PerUserDownload)
+
+		if userLimiter.upload != nil {
+			limiters.Upload = append(limiters.Upload, userLimiter.upload)
+		}
+		if userLimiter.download != nil {
+			limiters.Download = append(limiters.Download, userLimiter.download)
+		}
+	}
+
+	socket.SetBandwidthLimiters(limiters)
+}
+
+// releaseUserLimiters drops the per-user limiters once the user's last connection is gone
+// Must be called with socketsLock held
+func (s *Server) releaseUserLimiters(username string) {
+	if username == "" {
+		return
+	}
+
+	for _, socket := range s.sockets {
+		socketUsername, _ := socket.Metadata["username"].(string)
+		if socketUsername == username {
+			return
+		}
+	}
+
+	delete(s.userLimiters, username)
+}
@@ -3,12 +3,21 @@ package servers
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/Doridian/wsvpn/server/acl"
 	"github.com/Doridian/wsvpn/server/authenticators"
+	"github.com/Doridian/wsvpn/server/certidentity"
+	"github.com/Doridian/wsvpn/server/jwks"
+	"github.com/Doridian/wsvpn/server/revocation"
 	"github.com/Doridian/wsvpn/server/upgraders"
 	"github.com/Doridian/wsvpn/shared"
 	"github.com/Doridian/wsvpn/shared/commands"
@@ -32,12 +41,15 @@ type Server struct {
 
 	PacketHandler             sockets.PacketHandler
 	VPNNet                    *shared.VPNNet
+	SecondaryVPNNet           *shared.VPNNet
 	DoLocalIPConfig           bool
 	DoRemoteIPConfig          bool
 	TLSConfig                 *tls.Config
 	ListenAddr                string
 	HTTP3Enabled              bool
+	ProxyProtocol             bool
 	Authenticator             authenticators.Authenticator
+	CertificateIdentity       *certidentity.Mapper
 	Mode                      shared.VPNMode
 	SocketConfigurator        sockets.SocketConfigurator
 	InterfaceConfig           *iface.InterfaceConfig
@@ -46,15 +58,36 @@ type Server struct {
 	WebsiteDirectory          string
 	APIEnabled                bool
 	APIUsers                  map[string]bool
+	MetricsEnabled            bool
+	MetricsUsers              map[string]bool
 	PreauthorizeSecret        []byte
+	PreauthorizeTrustedKeys   *jwks.Cache
+	PreauthorizeLifetime      time.Duration
+	PreauthorizeAudience      string
+	PreauthorizeIssuer        string
+	AdminListenAddr           string
+	AdminTLSConfig            *tls.Config
+	AdminTokens               [][]byte
 	headers                   http.Header
 
 	upgraders          []upgraders.SocketUpgrader
 	slotMutex          *sync.Mutex
 	ifaceCreationMutex *sync.Mutex
-	usedSlots          map[uint64]bool
+	usedSlots          map[uint64]string
+	staticSlots        map[string]uint64
+	staticSlotOwners   map[uint64]string
+	leaseFile          string
+	leasedSlots        map[string]uint64
+	leasedSlotOwners   map[uint64]string
 	packetBufferSize   int
 	mtu                int
+	dnsConfig          *commands.SetDNSParameters
+	routes             []string
+	userRoutes         map[string][]string
+	clientNetworks     map[string][]*net.IPNet
+	userGroups         map[string][]string
+	bandwidthLimits    BandwidthLimits
+	aclRules           atomic.Pointer[acl.ACL]
 	mainIface          *iface.WaterInterfaceWrapper
 	log                *log.Logger
 	serverID           string
@@ -62,6 +95,10 @@ type Server struct {
 	closers              []io.Closer
 	sockets              map[string]*sockets.Socket
 	authenticatedSockets map[string][]*sockets.Socket
+	pushedRoutes         map[string]*clientRoutes
+	clientNetworkOwners  map[string]string
+	userBandwidthLimits  map[string]BandwidthLimits
+	userLimiters         map[string]*userLimiters
 	closerLock           *sync.Mutex
 	socketsLock          *sync.Mutex
 
@@ -70,22 +107,66 @@ type Server struct {
 	serveWaitGroup    *sync.WaitGroup
 
 	localFeatures map[features.Feature]bool
+
+	connectionsTotal atomic.Uint64
+	metricsLock      *sync.Mutex
+	authFailures     map[string]uint64
+	closedStats      sockets.Stats
+	userClosedStats  map[string]*sockets.Stats
+
+	configLock     *sync.Mutex
+	configReloader ConfigReloader
+	reloadWarnings []string
+
+	eventsLock       *sync.Mutex
+	eventSubscribers map[chan *Event]bool
+	lastEventID      uint64
+
+	revocationChecker *revocation.Checker
+
+	protection     *connectionProtection
+	trustedProxies atomic.Pointer[[]*net.IPNet]
+
+	preauthorizeKey        *preauthorizeKey
+	preauthorizeLock       *sync.Mutex
+	preauthorizedDetails   map[string]*preauthorizedDetails
+	usedPreauthorizeTokens map[string]time.Time
 }
 
 func NewServer() *Server {
 	return &Server{
-		slotMutex:            &sync.Mutex{},
-		ifaceCreationMutex:   &sync.Mutex{},
-		usedSlots:            make(map[uint64]bool),
-		log:                  shared.MakeLogger("SERVER", ""),
-		serveErrorChannel:    make(chan interface{}),
-		serveWaitGroup:       &sync.WaitGroup{},
-		closers:              make([]io.Closer, 0),
-		sockets:              make(map[string]*sockets.Socket),
-		authenticatedSockets: make(map[string][]*sockets.Socket),
-		closerLock:           &sync.Mutex{},
-		socketsLock:          &sync.Mutex{},
-		localFeatures:        make(map[features.Feature]bool),
+		slotMutex:              &sync.Mutex{},
+		ifaceCreationMutex:     &sync.Mutex{},
+		usedSlots:              make(map[uint64]string),
+		staticSlots:            make(map[string]uint64),
+		staticSlotOwners:       make(map[uint64]string),
+		leasedSlots:            make(map[string]uint64),
+		leasedSlotOwners:       make(map[uint64]string),
+		log:                    shared.MakeLogger("SERVER", ""),
+		serveErrorChannel:      make(chan interface{}),
+		serveWaitGroup:         &sync.WaitGroup{},
+		closers:                make([]io.Closer, 0),
+		sockets:                make(map[string]*sockets.Socket),
+		authenticatedSockets:   make(map[string][]*sockets.Socket),
+		pushedRoutes:           make(map[string]*clientRoutes),
+		clientNetworks:         make(map[string][]*net.IPNet),
+		userGroups:             make(map[string][]string),
+		clientNetworkOwners:    make(map[string]string),
+		userBandwidthLimits:    make(map[string]BandwidthLimits),
+		userLimiters:           make(map[string]*userLimiters),
+		closerLock:             &sync.Mutex{},
+		socketsLock:            &sync.Mutex{},
+		localFeatures:          make(map[features.Feature]bool),
+		metricsLock:            &sync.Mutex{},
+		authFailures:           make(map[string]uint64),
+		userClosedStats:        make(map[string]*sockets.Stats),
+		configLock:             &sync.Mutex{},
+		eventsLock:             &sync.Mutex{},
+		eventSubscribers:       make(map[chan *Event]bool),
+		preauthorizeLock:       &sync.Mutex{},
+		preauthorizedDetails:   make(map[string]*preauthorizedDetails),
+		usedPreauthorizeTokens: make(map[string]time.Time),
+		protection:             newConnectionProtection(),
 	}
 }
 
@@ -180,6 +261,10 @@ func (s *Server) SetMTU(mtu int) error {
 	if mtu < 500 || mtu > 65535 {
 		return errors.New("MTU out of range (500 - 65535)")
 	}
+	// Compressed packets carry a 1 byte codec header, which has to fit into the 65535 byte data message limit
+	if mtu > 65534 && s.localFeatures[features.Compression] {
+		return errors.New("MTU out of range with compression enabled (500 - 65534)")
+	}
 	if s.mtu == mtu {
 		return nil
 	}
@@ -210,11 +295,105 @@ func (s *Server) SetMTU(mtu int) error {
 		})
 	}
 
+	if s.mtu != 0 {
+		s.publishEvent(&Event{
+			Type: EventTypeMTUChanged,
+			MTU:  mtu,
+		})
+	}
 	s.mtu = mtu
 
 	return nil
 }
 
+// SetDNS replaces the DNS configuration pushed to clients and sends it to all initialized clients if it changed
+func (s *Server) SetDNS(nameservers []string, searchDomains []string) error {
+	for _, nameserver := range nameservers {
+		if net.ParseIP(nameserver) == nil {
+			return fmt.Errorf("invalid DNS nameserver: %s", nameserver)
+		}
+	}
+
+	var dnsConfig *commands.SetDNSParameters
+	if len(nameservers) > 0 || len(searchDomains) > 0 {
+		dnsConfig = &commands.SetDNSParameters{
+			Nameservers:   nameservers,
+			SearchDomains: searchDomains,
+		}
+	}
+
+	s.socketsLock.Lock()
+	if reflect.DeepEqual(s.dnsConfig, dnsConfig) {
+		s.socketsLock.Unlock()
+		return nil
+	}
+	s.dnsConfig = dnsConfig
+
+	targets := s.getPushTargets()
+	s.socketsLock.Unlock()
+
+	if dnsConfig == nil {
+		dnsConfig = &commands.SetDNSParameters{
+			Nameservers:   []string{},
+			SearchDomains: []string{},
+		}
+	}
+
+	for _, target := range targets {
+		target.routes.lock.Lock()
+		_ = target.socket.MakeAndSendCommand(dnsConfig)
+		target.routes.lock.Unlock()
+	}
+
+	return nil
+}
+
+type aclPacketHandler interface {
+	SetACL(rules *acl.ACL)
+}
+
+// SetACL installs the packet filter rules on the switch, they take effect immediately
+func (s *Server) SetACL(rules *acl.ACL) {
+	s.aclRules.Store(rules)
+
+	aclHandler, ok := s.PacketHandler.(aclPacketHandler)
+	if ok {
+		aclHandler.SetACL(rules)
+	} else if rules.HasRules() {
+		s.ConfigWarning("Packet filter rules have no effect with one-interface-per-connection")
+	}
+}
+
+// SetUserGroups configures which groups users are members of, used by packet filter rules
+// Changes only take effect for new connections
+func (s *Server) SetUserGroups(userGroups map[string][]string) {
+	s.socketsLock.Lock()
+	defer s.socketsLock.Unlock()
+	s.userGroups = userGroups
+}
+
+// getUserGroups returns the configured groups of a user merged with those the authenticator reported
+func (s *Server) getUserGroups(username string, authGroups []string) []string {
+	s.socketsLock.Lock()
+	configGroups := s.userGroups[username]
+	s.socketsLock.Unlock()
+
+	groups := make([]string, 0, len(configGroups)+len(authGroups))
+	seen := make(map[string]bool)
+	addGroups := func(add []string) {
+		for _, group := range add {
+			if seen[group] {
+				continue
+			}
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	addGroups(configGroups)
+	addGroups(authGroups)
+	return groups
+}
+
 func (s *Server) SetLocalFeature(feature features.Feature, enabled bool) {
 	if !enabled {
 		delete(s.localFeatures, feature)
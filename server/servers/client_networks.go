@@ -0,0 +1,135 @@
+package servers
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/Doridian/wsvpn/shared/iface"
+)
+
+// SetClientNetworks configures which subnets are located behind which user's client
+// Changes only take effect for new connections
+func (s *Server) SetClientNetworks(userNetworks map[string][]string) error {
+	newClientNetworks := make(map[string][]*net.IPNet, len(userNetworks))
+	allNetworks := make(map[*net.IPNet]string)
+
+	for username, networks := range userNetworks {
+		parsedNetworks := make([]*net.IPNet, 0, len(networks))
+		for _, network := range networks {
+			_, ipNet, err := net.ParseCIDR(network)
+			if err != nil {
+				return fmt.Errorf("invalid client network for user %s: %v", username, err)
+			}
+
+			for _, vpnNet := range s.getVPNNets() {
+				if networksOverlap(ipNet, vpnNet.GetSubnet()) {
+					return fmt.Errorf("client network %s of user %s overlaps VPN subnet %s", ipNet.String(), username, vpnNet.GetRaw())
+				}
+			}
+
+			for otherNet, otherUsername := range allNetworks {
+				if networksOverlap(ipNet, otherNet) {
+					return fmt.Errorf("client network %s of user %s overlaps %s of user %s", ipNet.String(), username, otherNet.String(), otherUsername)
+				}
+			}
+
+			allNetworks[ipNet] = username
+			parsedNetworks = append(parsedNetworks, ipNet)
+		}
+		newClientNetworks[username] = parsedNetworks
+	}
+
+	s.socketsLock.Lock()
+	defer s.socketsLock.Unlock()
+	s.clientNetworks = newClientNetworks
+
+	return nil
+}
+
+func networksOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// claimClientNetworks hands the user's client networks to clientID
+// Networks already claimed by another connection of the same user are skipped
+func (s *Server) claimClientNetworks(clientID string, username string, logger *log.Logger) []*net.IPNet {
+	if username == "" {
+		return []*net.IPNet{}
+	}
+
+	s.socketsLock.Lock()
+	defer s.socketsLock.Unlock()
+
+	networks := s.clientNetworks[username]
+	res := make([]*net.IPNet, 0, len(networks))
+	for _, ipNet := range networks {
+		network := ipNet.String()
+		ownerClientID := s.clientNetworkOwners[network]
+		if ownerClientID != "" {
+			logger.Printf("Client network %s is already routed to client %s, skipping it", network, ownerClientID)
+			continue
+		}
+		s.clientNetworkOwners[network] = clientID
+		res = append(res, ipNet)
+	}
+	return res
+}
+
+func (s *Server) releaseClientNetworks(clientID string, networks []*net.IPNet) {
+	s.socketsLock.Lock()
+	defer s.socketsLock.Unlock()
+
+	for _, ipNet := range networks {
+		network := ipNet.String()
+		if s.clientNetworkOwners[network] != clientID {
+			continue
+		}
+		delete(s.clientNetworkOwners, network)
+	}
+}
+
+// getClientNetworkGateway picks the client's IP of the same IP family as the network
+func getClientNetworkGateway(ipNet *net.IPNet, ipClients []net.IP) net.IP {
+	networkIsIPv4 := ipNet.IP.To4() != nil
+	for _, ipClient := range ipClients {
+		if (ipClient.To4() != nil) == networkIsIPv4 {
+			return ipClient
+		}
+	}
+	return nil
+}
+
+// addClientNetworkRoutes adds kernel routes for the client networks via the client's IP
+// It returns the routes that were added, so they can be removed on disconnect
+func (s *Server) addClientNetworkRoutes(localIface *iface.WaterInterfaceWrapper, networks []*net.IPNet, ipClients []net.IP, logger *log.Logger) map[*net.IPNet]net.IP {
+	addedRoutes := make(map[*net.IPNet]net.IP)
+	if !s.DoLocalIPConfig {
+		return addedRoutes
+	}
+
+	for _, ipNet := range networks {
+		gateway := getClientNetworkGateway(ipNet, ipClients)
+		if gateway == nil {
+			logger.Printf("Client has no IP of the same IP family as client network %s, not adding route", ipNet.String())
+			continue
+		}
+
+		err := localIface.AddIPRoute(ipNet, gateway)
+		if err != nil {
+			logger.Printf("Error adding route for client network %s: %v", ipNet.String(), err)
+			continue
+		}
+		addedRoutes[ipNet] = gateway
+	}
+	return addedRoutes
+}
+
+func (s *Server) removeClientNetworkRoutes(localIface *iface.WaterInterfaceWrapper, addedRoutes map[*net.IPNet]net.IP, logger *log.Logger) {
+	for ipNet, gateway := range addedRoutes {
+		err := localIface.RemoveIPRoute(ipNet, gateway)
+		if err != nil {
+			logger.Printf("Error removing route for client network %s: %v", ipNet.String(), err)
+		}
+	}
+}
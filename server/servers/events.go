@@ -0,0 +1,151 @@
+package servers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Doridian/wsvpn/shared"
+)
+
+const (
+	EventTypeAuthSuccess    = "auth_success"
+	EventTypeAuthFailure    = "auth_failure"
+	EventTypeClientUp       = "client_up"
+	EventTypeClientDown     = "client_down"
+	EventTypeClientEvicted  = "client_evicted"
+	EventTypeClientRejected = "client_rejected"
+	EventTypeMTUChanged     = "mtu_changed"
+	EventTypeConfigReloaded = "config_reloaded"
+)
+
+// Subscribers that fall this many events behind get disconnected instead of silently missing events
+const eventSubscriberBufferSize = 128
+
+const eventKeepaliveInterval = 30 * time.Second
+
+// Event is a single entry of the /api/events stream, fields not relevant to the type are omitted
+type Event struct {
+	ID         uint64    `json:"id"`
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	ClientID   string    `json:"client_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	VPNIPs     []string  `json:"vpn_ips,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	MTU        int       `json:"mtu,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (s *Server) publishEvent(evt *Event) {
+	s.eventsLock.Lock()
+	defer s.eventsLock.Unlock()
+
+	if len(s.eventSubscribers) == 0 {
+		return
+	}
+
+	s.lastEventID++
+	evt.ID = s.lastEventID
+	evt.Time = time.Now()
+
+	for subscriber := range s.eventSubscribers {
+		select {
+		case subscriber <- evt:
+		default:
+			delete(s.eventSubscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+func (s *Server) subscribeEvents() chan *Event {
+	subscriber := make(chan *Event, eventSubscriberBufferSize)
+
+	s.eventsLock.Lock()
+	defer s.eventsLock.Unlock()
+	s.eventSubscribers[subscriber] = true
+
+	return subscriber
+}
+
+func (s *Server) unsubscribeEvents(subscriber chan *Event) {
+	s.eventsLock.Lock()
+	defer s.eventsLock.Unlock()
+
+	if !s.eventSubscribers[subscriber] {
+		return
+	}
+	delete(s.eventSubscribers, subscriber)
+	close(subscriber)
+}
+
+func (s *Server) publishSocketEvent(scriptEvent string, clientID string, username string, remoteAddr string, vpnIPs []string) {
+	var eventType string
+	switch scriptEvent {
+	case shared.EventUp:
+		eventType = EventTypeClientUp
+	case shared.EventDown:
+		eventType = EventTypeClientDown
+	default:
+		return
+	}
+
+	s.publishEvent(&Event{
+		Type:       eventType,
+		ClientID:   clientID,
+		Username:   username,
+		RemoteAddr: remoteAddr,
+		VPNIPs:     vpnIPs,
+	})
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	subscriber := s.subscribeEvents()
+	defer s.unsubscribeEvents(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+		case evt, ok := <-subscriber:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(evt)
+			if err != nil {
+				s.log.Printf("Error encoding event: %v", err)
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, data)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,121 @@
+package servers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+func (s *Server) SetLeaseFile(leaseFile string) error {
+	s.slotMutex.Lock()
+	defer s.slotMutex.Unlock()
+
+	if s.leaseFile == leaseFile {
+		return nil
+	}
+
+	s.leaseFile = leaseFile
+	s.leasedSlots = make(map[string]uint64)
+	s.leasedSlotOwners = make(map[uint64]string)
+
+	if leaseFile == "" {
+		return nil
+	}
+
+	return s.loadLeases()
+}
+
+// loadLeases must be called with slotMutex held
+func (s *Server) loadLeases() error {
+	data, err := os.ReadFile(s.leaseFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	leases := make(map[string]string)
+	err = json.Unmarshal(data, &leases)
+	if err != nil {
+		return err
+	}
+
+	for username, ipStr := range leases {
+		slot, err := s.ipToSlot(net.ParseIP(ipStr))
+		if err != nil {
+			s.log.Printf("Ignoring lease of %s for %s: %v", ipStr, username, err)
+			continue
+		}
+		s.leasedSlots[username] = slot
+		s.leasedSlotOwners[slot] = username
+	}
+
+	return nil
+}
+
+// setLease must be called with slotMutex held
+func (s *Server) setLease(username string, slot uint64) {
+	if s.leaseFile == "" {
+		return
+	}
+
+	oldSlot, ok := s.leasedSlots[username]
+	if ok && oldSlot == slot {
+		return
+	}
+	if ok {
+		delete(s.leasedSlotOwners, oldSlot)
+	}
+
+	oldUsername, ok := s.leasedSlotOwners[slot]
+	if ok {
+		delete(s.leasedSlots, oldUsername)
+	}
+
+	s.leasedSlots[username] = slot
+	s.leasedSlotOwners[slot] = username
+
+	err := s.saveLeases()
+	if err != nil {
+		s.log.Printf("Error saving lease file: %v", err)
+	}
+}
+
+// saveLeases must be called with slotMutex held
+func (s *Server) saveLeases() error {
+	leases := make(map[string]string, len(s.leasedSlots))
+	for username, slot := range s.leasedSlots {
+		ip, err := s.slotToIP(slot)
+		if err != nil {
+			return err
+		}
+		leases[username] = ip.String()
+	}
+
+	data, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so we never leave a half-written lease file behind
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.leaseFile), ".wsvpn-leases-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+
+	_, err = tmpFile.Write(data)
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+
+	return os.Rename(tmpName, s.leaseFile)
+}
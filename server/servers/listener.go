@@ -3,7 +3,9 @@ package servers
 import (
 	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Doridian/wsvpn/server/upgraders"
@@ -35,6 +37,22 @@ func (s *Server) setUpgraderHeaders() {
 	}
 }
 
+// listenTCP opens the listener for WebSocket connections, HTTP/3 listens on its own
+func (s *Server) listenTCP(defaultAddr string) (net.Listener, error) {
+	addr := s.ListenAddr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.ProxyProtocol {
+		listener = s.wrapProxyProtocol(listener)
+	}
+	return listener, nil
+}
+
 func (s *Server) listenPlaintext(httpHandlerFunc http.HandlerFunc) {
 	if s.HTTP3Enabled {
 		s.setServeError(errors.New("HTTP/3 requires TLS"))
@@ -52,9 +70,15 @@ func (s *Server) listenPlaintext(httpHandlerFunc http.HandlerFunc) {
 	}
 	s.addCloser(server)
 
+	listener, err := s.listenTCP(":http")
+	if err != nil {
+		s.setServeError(err)
+		return
+	}
+
 	s.serveWaitGroup.Add(1)
 	go func() {
-		err := server.ListenAndServe()
+		err := server.Serve(listener)
 		s.setServeError(err)
 	}()
 }
@@ -85,9 +109,15 @@ func (s *Server) listenEncrypted(httpHandlerFunc http.HandlerFunc) {
 	s.addUpgrader(upgraders.NewWebSocketUpgrader())
 	s.listenUpgraders()
 
+	listener, err := s.listenTCP(":https")
+	if err != nil {
+		s.setServeError(err)
+		return
+	}
+
 	s.serveWaitGroup.Add(1)
 	go func() {
-		err := server.ListenAndServeTLS("", "")
+		err := server.ServeTLS(listener, "", "")
 		s.setServeError(err)
 	}()
 }
@@ -100,9 +130,14 @@ func (s *Server) listen() {
 		tlsConfigTemp, _ = tlsConfigTemp.GetConfigForClient(nil)
 	}
 
+	subnetStrs := make([]string, 0, 2)
+	for _, vpnNet := range s.getVPNNets() {
+		subnetStrs = append(subnetStrs, vpnNet.GetRaw())
+	}
+
 	s.log.Printf("VPN server online at %s (HTTP/3 %s, TLS %s, mTLS %s), Mode %s, Subnet %s (%d max clients), MTU %d",
 		s.ListenAddr, shared.BoolToEnabled(s.HTTP3Enabled), shared.BoolToEnabled(tlsConfigTemp != nil),
-		shared.BoolToEnabled(tlsConfigTemp != nil && tlsConfigTemp.ClientAuth == tls.RequireAndVerifyClientCert), s.Mode.ToString(), s.VPNNet.GetRaw(), s.VPNNet.GetClientSlots(), s.mtu)
+		shared.BoolToEnabled(tlsConfigTemp != nil && tlsConfigTemp.ClientAuth == tls.RequireAndVerifyClientCert), s.Mode.ToString(), strings.Join(subnetStrs, ", "), s.getClientSlots(), s.mtu)
 
 	httpHandlerFunc := http.HandlerFunc(s.serveSocket)
 
@@ -111,4 +146,8 @@ func (s *Server) listen() {
 	} else {
 		s.listenEncrypted(httpHandlerFunc)
 	}
+
+	if s.AdminListenAddr != "" {
+		s.listenAdmin()
+	}
 }
@@ -59,8 +59,13 @@ func (s *Server) createMainIface() error {
 	s.ifaceCreationMutex.Unlock()
 
 	if s.DoLocalIPConfig {
-		serverIP := s.VPNNet.GetServerIP()
-		err = s.mainIface.Configure(serverIP, s.VPNNet, serverIP)
+		for _, vpnNet := range s.getVPNNets() {
+			serverIP := vpnNet.GetServerIP()
+			err = s.mainIface.Configure(serverIP, vpnNet, serverIP)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		err = s.mainIface.Configure(nil, nil, nil)
 	}
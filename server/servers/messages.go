@@ -0,0 +1,97 @@
+package servers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+const defaultMessageType = "info"
+
+type MessageRequest struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	// Username limits a broadcast to the connections of this user, ignored for single clients
+	Username string `json:"username"`
+}
+
+type MessageResponse struct {
+	Sent int `json:"sent"`
+}
+
+func readMessageRequest(w http.ResponseWriter, r *http.Request) (*MessageRequest, error) {
+	req := &MessageRequest{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize)).Decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Message == "" {
+		return nil, errors.New("message must not be empty")
+	}
+	if req.Type == "" {
+		req.Type = defaultMessageType
+	}
+	return req, nil
+}
+
+// BroadcastMessage sends a message to all connected clients, or only those of username if it is not empty
+// It returns how many clients the message was sent to, clients too old to receive messages are skipped
+func (s *Server) BroadcastMessage(msgType string, message string, username string) int {
+	s.socketsLock.Lock()
+	targets := make([]*sockets.Socket, 0, len(s.sockets))
+	for _, socket := range s.sockets {
+		if username != "" {
+			socketUsername, _ := socket.Metadata["username"].(string)
+			if socketUsername != username {
+				continue
+			}
+		}
+		targets = append(targets, socket)
+	}
+	s.socketsLock.Unlock()
+
+	sent := 0
+	for _, socket := range targets {
+		if socket.SendMessage(msgType, message) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+func (s *Server) serveBroadcastMessage(w http.ResponseWriter, r *http.Request) {
+	req, err := readMessageRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serveJSON(&MessageResponse{
+		Sent: s.BroadcastMessage(req.Type, req.Message, req.Username),
+	}, w)
+}
+
+func (s *Server) serveClientMessage(w http.ResponseWriter, r *http.Request, socket *sockets.Socket) {
+	req, err := readMessageRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = socket.SendMessage(req.Type, req.Message)
+	if err == sockets.ErrCommandNotSupported {
+		http.Error(w, "Client does not support messages", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error sending message", http.StatusInternalServerError)
+		return
+	}
+
+	serveJSON(&MessageResponse{
+		Sent: 1,
+	}, w)
+}
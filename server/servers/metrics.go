@@ -0,0 +1,227 @@
+package servers
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+const authFailureSourceMTLS = "mtls"
+const authFailureSourcePreauthorize = "preauthorize"
+
+// TableSizeReporter is implemented by packet handlers that keep lookup tables
+type TableSizeReporter interface {
+	GetTableSizes() map[string]int
+}
+
+func (s *Server) recordAuthFailure(source string) {
+	s.metricsLock.Lock()
+	defer s.metricsLock.Unlock()
+	s.authFailures[source]++
+}
+
+// recordClosedSocket keeps the counters of a socket after it is gone, so totals never decrease
+// Must be called with socketsLock held
+func (s *Server) recordClosedSocket(socket *sockets.Socket, username string) {
+	stats := socket.GetStats()
+
+	s.metricsLock.Lock()
+	defer s.metricsLock.Unlock()
+
+	s.closedStats.Add(stats)
+	if username == "" {
+		return
+	}
+	userStats := s.userClosedStats[username]
+	if userStats == nil {
+		userStats = &sockets.Stats{}
+		s.userClosedStats[username] = userStats
+	}
+	userStats.Add(stats)
+}
+
+var metricsLabelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (m *metricsWriter) header(name string, metricType string, help string) {
+	_, _ = fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// value writes a single sample, labels are given as alternating names and values
+func (m *metricsWriter) value(name string, value interface{}, labels ...string) {
+	_, _ = m.w.WriteString(name)
+	if len(labels) > 0 {
+		_ = m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				_ = m.w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(m.w, "%s=\"%s\"", labels[i], metricsLabelEscaper.Replace(labels[i+1]))
+		}
+		_ = m.w.WriteByte('}')
+	}
+	_, _ = fmt.Fprintf(m.w, " %v\n", value)
+}
+
+type socketMetrics struct {
+	clientID string
+	username string
+	stats    *sockets.Stats
+	pingRTT  float64
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request, username string) {
+	if !s.MetricsEnabled {
+		http.Error(w, "Metrics not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if len(s.MetricsUsers) > 0 && !s.MetricsUsers[username] {
+		http.Error(w, "Metrics access not allowed", http.StatusForbidden)
+		return
+	}
+
+	s.handleMetrics(w, r)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Only GET and HEAD are allowed", http.StatusBadRequest)
+		return
+	}
+
+	s.socketsLock.Lock()
+	socketList := make([]*socketMetrics, 0, len(s.sockets))
+	for clientID, socket := range s.sockets {
+		socketUsername, _ := socket.Metadata["username"].(string)
+		socketList = append(socketList, &socketMetrics{
+			clientID: clientID,
+			username: socketUsername,
+			stats:    socket.GetStats(),
+			pingRTT:  socket.GetPingRTT().Seconds(),
+		})
+	}
+
+	// Still holding socketsLock, so no socket can move from the live to the closed counters meanwhile
+	s.metricsLock.Lock()
+	totalStats := s.closedStats
+	userStats := make(map[string]*sockets.Stats, len(s.userClosedStats))
+	for user, stats := range s.userClosedStats {
+		statsCopy := *stats
+		userStats[user] = &statsCopy
+	}
+	authFailures := make(map[string]uint64, len(s.authFailures))
+	for source, count := range s.authFailures {
+		authFailures[source] = count
+	}
+	s.metricsLock.Unlock()
+	s.socketsLock.Unlock()
+
+	sort.Slice(socketList, func(i, j int) bool {
+		return socketList[i].clientID < socketList[j].clientID
+	})
+
+	for _, sock := range socketList {
+		totalStats.Add(sock.stats)
+		if sock.username == "" {
+			continue
+		}
+		stats := userStats[sock.username]
+		if stats == nil {
+			stats = &sockets.Stats{}
+			userStats[sock.username] = stats
+		}
+		stats.Add(sock.stats)
+	}
+
+	usernames := make([]string, 0, len(userStats))
+	for user := range userStats {
+		usernames = append(usernames, user)
+	}
+	sort.Strings(usernames)
+
+	authSources := make([]string, 0, len(authFailures))
+	for source := range authFailures {
+		authSources = append(authSources, source)
+	}
+	sort.Strings(authSources)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m := &metricsWriter{w: bufio.NewWriter(w)}
+
+	m.header("wsvpn_connections_total", "counter", "Number of accepted VPN connections")
+	m.value("wsvpn_connections_total", s.connectionsTotal.Load())
+	m.header("wsvpn_connections", "gauge", "Number of currently connected VPN clients")
+	m.value("wsvpn_connections", len(socketList))
+
+	m.header("wsvpn_auth_failures_total", "counter", "Number of failed authentication attempts")
+	for _, source := range authSources {
+		m.value("wsvpn_auth_failures_total", authFailures[source], "authenticator", source)
+	}
+
+	m.header("wsvpn_bytes_total", "counter", "Tunneled bytes, upload is sent by clients and download is sent to clients")
+	m.value("wsvpn_bytes_total", totalStats.BytesReceived, "direction", "upload")
+	m.value("wsvpn_bytes_total", totalStats.BytesSent, "direction", "download")
+	m.header("wsvpn_packets_total", "counter", "Tunneled packets, upload is sent by clients and download is sent to clients")
+	m.value("wsvpn_packets_total", totalStats.PacketsReceived, "direction", "upload")
+	m.value("wsvpn_packets_total", totalStats.PacketsSent, "direction", "download")
+
+	m.header("wsvpn_fragments_sent_total", "counter", "Fragments sent for packets too large for a single data message")
+	m.value("wsvpn_fragments_sent_total", totalStats.FragmentsSent)
+	m.header("wsvpn_packets_reassembled_total", "counter", "Packets reassembled from received fragments")
+	m.value("wsvpn_packets_reassembled_total", totalStats.PacketsReassembled)
+	m.header("wsvpn_packets_expired_total", "counter", "Partially received fragmented packets dropped after timing out")
+	m.value("wsvpn_packets_expired_total", totalStats.PacketsExpired)
+	m.header("wsvpn_packets_dropped_total", "counter", "Packets dropped by the connections, for example due to bandwidth limits")
+	m.value("wsvpn_packets_dropped_total", totalStats.PacketsDropped)
+
+	m.header("wsvpn_user_bytes_total", "counter", "Tunneled bytes per user")
+	for _, user := range usernames {
+		m.value("wsvpn_user_bytes_total", userStats[user].BytesReceived, "username", user, "direction", "upload")
+		m.value("wsvpn_user_bytes_total", userStats[user].BytesSent, "username", user, "direction", "download")
+	}
+	m.header("wsvpn_user_packets_total", "counter", "Tunneled packets per user")
+	for _, user := range usernames {
+		m.value("wsvpn_user_packets_total", userStats[user].PacketsReceived, "username", user, "direction", "upload")
+		m.value("wsvpn_user_packets_total", userStats[user].PacketsSent, "username", user, "direction", "download")
+	}
+
+	m.header("wsvpn_socket_bytes_total", "counter", "Tunneled bytes per connected client")
+	for _, sock := range socketList {
+		m.value("wsvpn_socket_bytes_total", sock.stats.BytesReceived, "client_id", sock.clientID, "username", sock.username, "direction", "upload")
+		m.value("wsvpn_socket_bytes_total", sock.stats.BytesSent, "client_id", sock.clientID, "username", sock.username, "direction", "download")
+	}
+	m.header("wsvpn_socket_packets_total", "counter", "Tunneled packets per connected client")
+	for _, sock := range socketList {
+		m.value("wsvpn_socket_packets_total", sock.stats.PacketsReceived, "client_id", sock.clientID, "username", sock.username, "direction", "upload")
+		m.value("wsvpn_socket_packets_total", sock.stats.PacketsSent, "client_id", sock.clientID, "username", sock.username, "direction", "download")
+	}
+	m.header("wsvpn_socket_ping_rtt_seconds", "gauge", "Round trip time of the last answered ping per connected client")
+	for _, sock := range socketList {
+		m.value("wsvpn_socket_ping_rtt_seconds", sock.pingRTT, "client_id", sock.clientID, "username", sock.username)
+	}
+
+	tableSizeReporter, ok := s.PacketHandler.(TableSizeReporter)
+	if ok {
+		tableSizes := tableSizeReporter.GetTableSizes()
+		tables := make([]string, 0, len(tableSizes))
+		for table := range tableSizes {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+
+		m.header("wsvpn_switch_table_entries", "gauge", "Number of entries in the lookup tables of the packet switch")
+		for _, table := range tables {
+			m.value("wsvpn_switch_table_entries", tableSizes[table], "table", table)
+		}
+	}
+
+	_ = m.w.Flush()
+}
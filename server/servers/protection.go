@@ -0,0 +1,345 @@
+package servers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entries of idle clients are forgotten at most this often
+const protectionPruneInterval = time.Minute
+
+// Usernames come from unauthenticated requests, so only remember this many of them (and client IPs)
+const maxLockoutEntries = 10000
+
+// LockoutConfig locks out client IPs and usernames after too many failed logins
+type LockoutConfig struct {
+	MaxFailures        int           // Failures before a lockout, 0 to disable
+	LockoutDuration    time.Duration // Duration of the first lockout, doubled for every further one
+	MaxLockoutDuration time.Duration // 0 for unlimited
+	ResetAfter         time.Duration // Failures and previous lockouts are forgotten after this long without failures, 0 for never
+}
+
+// ConnectionLimitConfig limits how fast and how many connections are accepted
+type ConnectionLimitConfig struct {
+	Rate                    float64 // New connections per second and client IP, 0 to disable
+	Burst                   int
+	MaxConcurrentHandshakes int // 0 for unlimited
+}
+
+type lockoutEntry struct {
+	failures    int
+	lockouts    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type connectionBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type connectionProtection struct {
+	lock *sync.Mutex
+
+	lockoutConfig    LockoutConfig
+	limitConfig      ConnectionLimitConfig
+	ipLockouts       map[string]*lockoutEntry
+	userLockouts     map[string]*lockoutEntry
+	buckets          map[string]*connectionBucket
+	activeHandshakes int
+	lastPrune        time.Time
+}
+
+func newConnectionProtection() *connectionProtection {
+	return &connectionProtection{
+		lock:         &sync.Mutex{},
+		ipLockouts:   make(map[string]*lockoutEntry),
+		userLockouts: make(map[string]*lockoutEntry),
+		buckets:      make(map[string]*connectionBucket),
+		lastPrune:    time.Now(),
+	}
+}
+
+func (s *Server) SetLockoutConfig(config LockoutConfig) {
+	s.protection.lock.Lock()
+	defer s.protection.lock.Unlock()
+	s.protection.lockoutConfig = config
+}
+
+func (s *Server) SetConnectionLimitConfig(config ConnectionLimitConfig) {
+	s.protection.lock.Lock()
+	defer s.protection.lock.Unlock()
+	s.protection.limitConfig = config
+	// Buckets are refilled using the new rate, so just start over
+	s.protection.buckets = make(map[string]*connectionBucket)
+}
+
+// getClientIP returns the IP of the client, r.RemoteAddr has already been resolved through trusted proxies
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getAttemptedUsername returns the username a client tries to log in as, if it is known before authenticating
+func getAttemptedUsername(r *http.Request) string {
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+	return username
+}
+
+// isExpired must be called with lock held
+func (p *connectionProtection) isExpired(entry *lockoutEntry, now time.Time) bool {
+	return p.lockoutConfig.ResetAfter > 0 && now.After(entry.lockedUntil) && now.Sub(entry.lastFailure) >= p.lockoutConfig.ResetAfter
+}
+
+// prune must be called with lock held
+func (p *connectionProtection) prune(now time.Time) {
+	if now.Sub(p.lastPrune) < protectionPruneInterval {
+		return
+	}
+	p.lastPrune = now
+
+	for _, lockouts := range []map[string]*lockoutEntry{p.ipLockouts, p.userLockouts} {
+		for key, entry := range lockouts {
+			if p.isExpired(entry, now) {
+				delete(lockouts, key)
+			}
+		}
+	}
+
+	for key, bucket := range p.buckets {
+		if p.limitConfig.Rate <= 0 || bucket.refill(now, &p.limitConfig) >= float64(p.limitConfig.Burst) {
+			delete(p.buckets, key)
+		}
+	}
+}
+
+func (b *connectionBucket) refill(now time.Time, config *ConnectionLimitConfig) float64 {
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*config.Rate, float64(config.Burst))
+	b.last = now
+	return b.tokens
+}
+
+// allowConnection takes a token from the client IP's bucket, returning how long to wait if there is none
+func (s *Server) allowConnection(clientIP string) (bool, time.Duration) {
+	p := s.protection
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.limitConfig.Rate <= 0 {
+		return true, 0
+	}
+
+	now := time.Now()
+	p.prune(now)
+
+	bucket := p.buckets[clientIP]
+	if bucket == nil {
+		bucket = &connectionBucket{
+			tokens: float64(p.limitConfig.Burst),
+			last:   now,
+		}
+		p.buckets[clientIP] = bucket
+	}
+
+	if bucket.refill(now, &p.limitConfig) < 1 {
+		return false, time.Duration((1 - bucket.tokens) / p.limitConfig.Rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// beginHandshake reserves one of the concurrent handshakes, the returned function frees it and may be called more than once
+func (s *Server) beginHandshake() (func(), bool) {
+	p := s.protection
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.limitConfig.MaxConcurrentHandshakes > 0 && p.activeHandshakes >= p.limitConfig.MaxConcurrentHandshakes {
+		return nil, false
+	}
+	p.activeHandshakes++
+
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			p.lock.Lock()
+			p.activeHandshakes--
+			p.lock.Unlock()
+		})
+	}, true
+}
+
+// getLockout returns how long the client IP or username is still locked out, 0 if not at all
+func (s *Server) getLockout(clientIP string, username string) time.Duration {
+	p := s.protection
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	lockedUntil := time.Time{}
+	if entry := p.ipLockouts[clientIP]; entry != nil {
+		lockedUntil = entry.lockedUntil
+	}
+	if entry := p.userLockouts[username]; username != "" && entry != nil && entry.lockedUntil.After(lockedUntil) {
+		lockedUntil = entry.lockedUntil
+	}
+	if now.After(lockedUntil) {
+		return 0
+	}
+	return lockedUntil.Sub(now)
+}
+
+// makeRoom must be called with lock held, it forgets entries until a new one fits
+// Entries not locked out right now go first, then the ones with the oldest failure
+func makeRoom(lockouts map[string]*lockoutEntry, now time.Time) {
+	if len(lockouts) < maxLockoutEntries {
+		return
+	}
+
+	for key, entry := range lockouts {
+		if now.After(entry.lockedUntil) {
+			delete(lockouts, key)
+		}
+	}
+
+	for len(lockouts) >= maxLockoutEntries {
+		oldestKey := ""
+		var oldestFailure time.Time
+		for key, entry := range lockouts {
+			if oldestKey == "" || entry.lastFailure.Before(oldestFailure) {
+				oldestKey = key
+				oldestFailure = entry.lastFailure
+			}
+		}
+		delete(lockouts, oldestKey)
+	}
+}
+
+// addFailure must be called with lock held, it returns the lockout duration if this failure caused one
+func (p *connectionProtection) addFailure(lockouts map[string]*lockoutEntry, key string, now time.Time) time.Duration {
+	entry := lockouts[key]
+	if entry == nil || p.isExpired(entry, now) {
+		if entry == nil {
+			makeRoom(lockouts, now)
+		}
+		entry = &lockoutEntry{}
+		lockouts[key] = entry
+	}
+	entry.lastFailure = now
+	entry.failures++
+	if entry.failures < p.lockoutConfig.MaxFailures {
+		return 0
+	}
+
+	entry.failures = 0
+	entry.lockouts++
+	maxDuration := p.lockoutConfig.MaxLockoutDuration
+	if maxDuration <= 0 {
+		maxDuration = math.MaxInt64 / 2
+	}
+	duration := p.lockoutConfig.LockoutDuration
+	for i := 1; i < entry.lockouts && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	if duration > maxDuration {
+		duration = maxDuration
+	}
+	entry.lockedUntil = now.Add(duration)
+	return duration
+}
+
+func (s *Server) recordLoginFailure(r *http.Request) {
+	clientIP := getClientIP(r)
+	username := getAttemptedUsername(r)
+
+	p := s.protection
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.lockoutConfig.MaxFailures <= 0 {
+		return
+	}
+
+	now := time.Now()
+	p.prune(now)
+
+	duration := p.addFailure(p.ipLockouts, clientIP, now)
+	if duration > 0 {
+		s.log.Printf("Locking out IP %s for %s after %d failed logins", clientIP, duration.String(), p.lockoutConfig.MaxFailures)
+	}
+	if username == "" {
+		return
+	}
+	duration = p.addFailure(p.userLockouts, username, now)
+	if duration > 0 {
+		s.log.Printf("Locking out user %q for %s after %d failed logins", username, duration.String(), p.lockoutConfig.MaxFailures)
+	}
+}
+
+func (s *Server) clearLoginFailures(r *http.Request, username string) {
+	clientIP := getClientIP(r)
+
+	p := s.protection
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.ipLockouts, clientIP)
+	if username != "" {
+		delete(p.userLockouts, username)
+	}
+}
+
+// isHandshakeRequest is true for requests trying to connect or to get a preauthorization token, only those are
+// rate limited and count towards the concurrent handshakes (unlike API, metrics or website requests)
+func (s *Server) isHandshakeRequest(r *http.Request) bool {
+	if r.URL.Path == rootRoutePreauthorize || strings.HasPrefix(r.URL.Path, prefixRoutePreauthorize) {
+		return true
+	}
+	for _, upgrader := range s.upgraders {
+		if upgrader.Matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkConnectionAllowed rejects clients that are locked out, and if rateLimited, those that connect too fast
+func (s *Server) checkConnectionAllowed(w http.ResponseWriter, r *http.Request, clientID string, rateLimited bool) bool {
+	clientIP := getClientIP(r)
+
+	reason := ""
+	var retryAfter time.Duration
+	if lockout := s.getLockout(clientIP, getAttemptedUsername(r)); lockout > 0 {
+		reason = "too many failed logins"
+		retryAfter = lockout
+	} else if !rateLimited {
+		return true
+	} else if allowed, wait := s.allowConnection(clientIP); !allowed {
+		reason = "connection rate limit exceeded"
+		retryAfter = wait
+	} else {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, fmt.Sprintf("Too many requests: %s", reason), http.StatusTooManyRequests)
+	s.publishEvent(&Event{
+		Type:       EventTypeClientRejected,
+		ClientID:   clientID,
+		RemoteAddr: r.RemoteAddr,
+		Reason:     reason,
+	})
+	return false
+}
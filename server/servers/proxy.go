@@ -0,0 +1,192 @@
+package servers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// forwardedAddr is the client address as told by a trusted reverse proxy, the port might be missing
+type forwardedAddr string
+
+func (a forwardedAddr) Network() string {
+	return "tcp"
+}
+
+func (a forwardedAddr) String() string {
+	return string(a)
+}
+
+// SetTrustedProxies sets the reverse proxies (IPs or subnets) allowed to tell us the client address
+// using Forwarded / X-Forwarded-For headers or the PROXY protocol
+func (s *Server) SetTrustedProxies(trustedProxies []string) error {
+	parsedProxies := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, trustedProxy := range trustedProxies {
+		if !strings.Contains(trustedProxy, "/") {
+			ip := net.ParseIP(trustedProxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %s", trustedProxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			parsedProxies = append(parsedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(trustedProxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %s: %v", trustedProxy, err)
+		}
+		parsedProxies = append(parsedProxies, ipNet)
+	}
+
+	s.trustedProxies.Store(&parsedProxies)
+	return nil
+}
+
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	trustedProxies := s.trustedProxies.Load()
+	if trustedProxies == nil || ip == nil {
+		return false
+	}
+	for _, trustedProxy := range *trustedProxies {
+		if trustedProxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) isTrustedProxyAddr(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return s.isTrustedProxy(tcpAddr.IP)
+}
+
+type forwardedHop struct {
+	ip   net.IP
+	port string
+}
+
+func (h *forwardedHop) String() string {
+	if h.port == "" {
+		return h.ip.String()
+	}
+	return net.JoinHostPort(h.ip.String(), h.port)
+}
+
+// parseForwardedNode parses a node of the Forwarded header (RFC 7239), unknown or obfuscated ones return nil
+func parseForwardedNode(node string) *forwardedHop {
+	node = strings.Trim(strings.TrimSpace(node), "\"")
+
+	host := node
+	port := ""
+	if strings.HasPrefix(node, "[") {
+		end := strings.Index(node, "]")
+		if end < 0 {
+			return nil
+		}
+		host = node[1:end]
+		port = strings.TrimPrefix(node[end+1:], ":")
+	} else if strings.Count(node, ":") == 1 {
+		host, port, _ = strings.Cut(node, ":")
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	// Obfuscated ports start with an underscore, just leave them out
+	if strings.HasPrefix(port, "_") {
+		port = ""
+	}
+	return &forwardedHop{ip: ip, port: port}
+}
+
+// getForwardedHops returns the addresses the request was forwarded for, the client first
+// Forwarded is preferred over X-Forwarded-For if a proxy sent it, entries we can not parse are nil
+func getForwardedHops(r *http.Request) []*forwardedHop {
+	hops := make([]*forwardedHop, 0)
+
+	forwarded := r.Header.Values("Forwarded")
+	if len(forwarded) > 0 {
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			var hop *forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = parseForwardedNode(value)
+					break
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	for _, forwardedFor := range strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",") {
+		forwardedFor = strings.TrimSpace(forwardedFor)
+		if forwardedFor == "" {
+			continue
+		}
+		hop := parseForwardedNode(forwardedFor)
+		if hop == nil {
+			ip := net.ParseIP(forwardedFor)
+			if ip != nil {
+				hop = &forwardedHop{ip: ip}
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// resolveClientAddr returns the address of the client, taken from Forwarded or X-Forwarded-For
+// if the request came through trusted proxies, otherwise r.RemoteAddr
+func (s *Server) resolveClientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.isTrustedProxy(net.ParseIP(host)) {
+		return r.RemoteAddr
+	}
+
+	// Every proxy appends the address it got the request from, so walk backwards until we leave our proxies
+	clientAddr := r.RemoteAddr
+	hops := getForwardedHops(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if hop == nil {
+			break
+		}
+		clientAddr = hop.String()
+		if !s.isTrustedProxy(hop.ip) {
+			break
+		}
+	}
+	return clientAddr
+}
+
+// wrapProxyProtocol makes listener accept the PROXY protocol (v1 and v2) from trusted proxies
+// Everyone else has to connect without it, their connections are closed if they send a PROXY header
+func (s *Server) wrapProxyProtocol(listener net.Listener) net.Listener {
+	return &proxyproto.Listener{
+		Listener:          listener,
+		ReadHeaderTimeout: ReadHeaderTimeout,
+		ConnPolicy: func(options proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+			if s.isTrustedProxyAddr(options.Upstream) {
+				return proxyproto.USE, nil
+			}
+			return proxyproto.REJECT, nil
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ConfigReloader applies the configuration again, it gets called with configLock held
+type ConfigReloader = func() error
+
+var ErrReloadNotSupported = errors.New("configuration reload not supported")
+
+// ReloadResult describes the outcome of a configuration reload
+// Warnings lists settings that were not applied, for example ones that can only change on restart
+type ReloadResult struct {
+	Success  bool     `json:"success"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings"`
+}
+
+func (s *Server) SetConfigReloader(reloader ConfigReloader) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	s.configReloader = reloader
+}
+
+// ReloadConfig runs the config reloader and reports the result to event subscribers
+func (s *Server) ReloadConfig() *ReloadResult {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+
+	s.log.Printf("Reloading configuration, might not take effect until next connection...")
+
+	s.reloadWarnings = make([]string, 0)
+	err := ErrReloadNotSupported
+	if s.configReloader != nil {
+		err = s.configReloader()
+	}
+	result := &ReloadResult{
+		Success:  err == nil,
+		Warnings: s.reloadWarnings,
+	}
+	s.reloadWarnings = nil
+
+	evt := &Event{
+		Type: EventTypeConfigReloaded,
+	}
+	if err != nil {
+		s.log.Printf("Error reloading config: %v", err)
+		result.Error = err.Error()
+		evt.Error = result.Error
+	}
+	s.publishEvent(evt)
+
+	return result
+}
+
+// ConfigWarning logs a warning about the configuration and, during ReloadConfig, adds it to the result
+// Must only be called from the config reloader or the setters it uses
+func (s *Server) ConfigWarning(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	s.log.Printf("WARNING: %s", msg)
+	if s.reloadWarnings != nil {
+		s.reloadWarnings = append(s.reloadWarnings, msg)
+	}
+}
+
+// UpdateMTU changes the tunnel MTU outside of a configuration reload
+// The configured MTU is applied again on the next reload
+func (s *Server) UpdateMTU(mtu int) error {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.SetMTU(mtu)
+}
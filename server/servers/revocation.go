@@ -0,0 +1,62 @@
+package servers
+
+import (
+	"errors"
+
+	"github.com/Doridian/wsvpn/server/revocation"
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+// SetRevocationChecker replaces the checker used for connected clients, closing the previous one
+// Connections whose certificate is revoked now are closed, now and whenever the checker's CRL changes
+func (s *Server) SetRevocationChecker(checker *revocation.Checker) {
+	s.socketsLock.Lock()
+	oldChecker := s.revocationChecker
+	s.revocationChecker = checker
+	s.socketsLock.Unlock()
+
+	if oldChecker != nil {
+		_ = oldChecker.Close()
+	}
+	if checker == nil {
+		return
+	}
+
+	checker.Watch(func() {
+		s.closeRevokedSockets(checker)
+	})
+	go s.closeRevokedSockets(checker)
+}
+
+func (s *Server) closeRevokedSockets(checker *revocation.Checker) {
+	s.socketsLock.Lock()
+	socks := make(map[string]*sockets.Socket, len(s.sockets))
+	for clientID, socket := range s.sockets {
+		socks[clientID] = socket
+	}
+	s.socketsLock.Unlock()
+
+	for clientID, socket := range socks {
+		chain := socket.GetTLSVerifiedChain()
+		if len(chain) == 0 {
+			continue
+		}
+
+		// Only close connections for actual revocations, not because an OCSP responder is down
+		err := checker.Check(chain)
+		if !errors.Is(err, revocation.ErrRevoked) {
+			continue
+		}
+
+		s.log.Printf("Disconnecting client %s: %v", clientID, err)
+		username, _ := socket.Metadata["username"].(string)
+		s.publishEvent(&Event{
+			Type:       EventTypeClientEvicted,
+			ClientID:   clientID,
+			Username:   username,
+			RemoteAddr: socket.RemoteAddr().String(),
+			Reason:     "client certificate revoked",
+		})
+		socket.CloseError(err)
+	}
+}
@@ -0,0 +1,142 @@
+package servers
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/Doridian/wsvpn/shared/commands"
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+func parseRoutes(routes []string) ([]string, error) {
+	res := make([]string, 0, len(routes))
+	for _, route := range routes {
+		_, routeNet, err := net.ParseCIDR(route)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, routeNet.String())
+	}
+	return res, nil
+}
+
+// clientRoutes tracks the routes pushed to an initialized client
+// lock keeps DNS and route pushes to the client in order, it is taken before socketsLock, never while holding it
+type clientRoutes struct {
+	lock   sync.Mutex
+	pushed map[string]bool
+}
+
+type pushTarget struct {
+	clientID string
+	socket   *sockets.Socket
+	routes   *clientRoutes
+}
+
+// SetRoutes replaces the routes pushed to clients and updates all connected clients accordingly
+func (s *Server) SetRoutes(routes []string, userRoutes map[string][]string) error {
+	newRoutes, err := parseRoutes(routes)
+	if err != nil {
+		return err
+	}
+
+	newUserRoutes := make(map[string][]string, len(userRoutes))
+	for username, routes := range userRoutes {
+		newUserRoutes[username], err = parseRoutes(routes)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.socketsLock.Lock()
+	s.routes = newRoutes
+	s.userRoutes = newUserRoutes
+	targets := s.getPushTargets()
+	s.socketsLock.Unlock()
+
+	for _, target := range targets {
+		target.routes.lock.Lock()
+		s.syncSocketRoutes(target)
+		target.routes.lock.Unlock()
+	}
+
+	return nil
+}
+
+func (s *Server) getRoutesForUser(username string) map[string]bool {
+	res := make(map[string]bool)
+	for _, route := range s.routes {
+		res[route] = true
+	}
+	if username != "" {
+		for _, route := range s.userRoutes[username] {
+			res[route] = true
+		}
+	}
+	return res
+}
+
+// getPushTargets returns all clients that have been sent init, clients that have not
+// must not be sent any route or DNS changes, yet
+// Must be called with socketsLock held
+func (s *Server) getPushTargets() []*pushTarget {
+	targets := make([]*pushTarget, 0, len(s.pushedRoutes))
+	for clientID, routes := range s.pushedRoutes {
+		socket := s.sockets[clientID]
+		if socket == nil {
+			continue
+		}
+		targets = append(targets, &pushTarget{
+			clientID: clientID,
+			socket:   socket,
+			routes:   routes,
+		})
+	}
+	return targets
+}
+
+// syncSocketRoutes works out the route changes for the client under socketsLock and sends them after unlocking
+// Must be called with target.routes.lock held
+func (s *Server) syncSocketRoutes(target *pushTarget) {
+	pushedRoutes := target.routes.pushed
+	username, _ := target.socket.Metadata["username"].(string)
+
+	s.socketsLock.Lock()
+	wantedRoutes := s.getRoutesForUser(username)
+	s.socketsLock.Unlock()
+
+	removeRoutes := make([]string, 0)
+	for route := range pushedRoutes {
+		if !wantedRoutes[route] {
+			removeRoutes = append(removeRoutes, route)
+		}
+	}
+	sort.Strings(removeRoutes)
+
+	addRoutes := make([]string, 0)
+	for route := range wantedRoutes {
+		if !pushedRoutes[route] {
+			addRoutes = append(addRoutes, route)
+		}
+	}
+	sort.Strings(addRoutes)
+
+	for _, route := range removeRoutes {
+		err := target.socket.MakeAndSendCommand(&commands.RemoveRouteParameters{Route: route})
+		if err == sockets.ErrCommandNotSupported {
+			s.log.Printf("Client %s does not support removing route %s, it will stay until reconnect", target.clientID, route)
+		} else if err != nil {
+			continue
+		}
+		delete(pushedRoutes, route)
+	}
+
+	for _, route := range addRoutes {
+		err := target.socket.MakeAndSendCommand(&commands.AddRouteParameters{Route: route})
+		if err != nil {
+			continue
+		}
+		pushedRoutes[route] = true
+	}
+}
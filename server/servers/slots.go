@@ -0,0 +1,222 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/Doridian/wsvpn/shared"
+)
+
+var errSlotsExhausted = errors.New("IP slots exhausted")
+var errStaticIPInUse = errors.New("static IP already in use")
+
+// getVPNNets returns the primary subnet first, followed by the secondary one (if configured)
+func (s *Server) getVPNNets() []*shared.VPNNet {
+	if s.SecondaryVPNNet == nil {
+		return []*shared.VPNNet{s.VPNNet}
+	}
+	return []*shared.VPNNet{s.VPNNet, s.SecondaryVPNNet}
+}
+
+// getClientSlots returns how many clients can be given an address from every subnet
+func (s *Server) getClientSlots() uint64 {
+	maxSlot := s.VPNNet.GetClientSlots()
+	if s.SecondaryVPNNet != nil && s.SecondaryVPNNet.GetClientSlots() < maxSlot {
+		maxSlot = s.SecondaryVPNNet.GetClientSlots()
+	}
+	return maxSlot
+}
+
+func (s *Server) ipToSlot(ip net.IP) (uint64, error) {
+	vpnNet := s.VPNNet
+	if s.SecondaryVPNNet != nil && s.SecondaryVPNNet.IsIPv4() == (ip.To4() != nil) {
+		vpnNet = s.SecondaryVPNNet
+	}
+
+	idx, err := vpnNet.GetIPIndex(ip)
+	if err != nil {
+		return 0, err
+	}
+
+	// Slots start at 1, which is the IP right after the server's own IP
+	if idx < 2 || idx-1 > s.getClientSlots() {
+		return 0, fmt.Errorf("IP %s is not usable for clients in subnet %s", ip.String(), vpnNet.GetRaw())
+	}
+	return idx - 1, nil
+}
+
+func (s *Server) slotToIP(slot uint64) (net.IP, error) {
+	return s.VPNNet.GetIPAt(int(slot) + 1)
+}
+
+// slotToIPs returns the client's IP in every subnet, the one from the primary subnet first
+func (s *Server) slotToIPs(slot uint64) ([]net.IP, error) {
+	vpnNets := s.getVPNNets()
+	ips := make([]net.IP, 0, len(vpnNets))
+	for _, vpnNet := range vpnNets {
+		ip, err := vpnNet.GetIPAt(int(slot) + 1)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func (s *Server) SetStaticIPs(staticIPs map[string]string) error {
+	staticSlots := make(map[string]uint64, len(staticIPs))
+	staticSlotOwners := make(map[uint64]string, len(staticIPs))
+
+	for username, ipStr := range staticIPs {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return fmt.Errorf("invalid static IP for user %s: %s", username, ipStr)
+		}
+
+		slot, err := s.ipToSlot(ip)
+		if err != nil {
+			return fmt.Errorf("invalid static IP for user %s: %v", username, err)
+		}
+
+		otherUsername, ok := staticSlotOwners[slot]
+		if ok {
+			return fmt.Errorf("static IP %s assigned to both %s and %s", ip.String(), username, otherUsername)
+		}
+
+		staticSlots[username] = slot
+		staticSlotOwners[slot] = username
+	}
+
+	s.slotMutex.Lock()
+	defer s.slotMutex.Unlock()
+	s.staticSlots = staticSlots
+	s.staticSlotOwners = staticSlotOwners
+
+	return nil
+}
+
+// allocateSlot picks the slot for a new connection, framedIP (if not nil) is the address the authenticator asked for
+func (s *Server) allocateSlot(clientID string, username string, framedIP net.IP, logger *log.Logger) (uint64, error) {
+	s.slotMutex.Lock()
+	defer s.slotMutex.Unlock()
+
+	if framedIP != nil {
+		slot, err := s.ipToSlot(framedIP)
+		if err != nil {
+			return 0, fmt.Errorf("invalid framed IP: %v", err)
+		}
+		staticOwner := s.staticSlotOwners[slot]
+		if staticOwner != "" && staticOwner != username {
+			return 0, errStaticIPInUse
+		}
+		leaseOwner := s.leasedSlotOwners[slot]
+		if staticOwner == "" && leaseOwner != "" && leaseOwner != username {
+			return 0, errStaticIPInUse
+		}
+		// Only a connection of the same user may be replaced, never someone else who happens to hold the IP
+		oldClientID := s.usedSlots[slot]
+		if oldClientID != "" && (username == "" || s.getSocketUsername(oldClientID) != username) {
+			return 0, errStaticIPInUse
+		}
+		return slot, s.takeSlot(slot, clientID, logger)
+	}
+
+	if username != "" {
+		staticSlot, ok := s.staticSlots[username]
+		if ok {
+			return staticSlot, s.takeSlot(staticSlot, clientID, logger)
+		}
+
+		leasedSlot, ok := s.leasedSlots[username]
+		if ok && s.usedSlots[leasedSlot] == "" && s.staticSlotOwners[leasedSlot] == "" {
+			s.usedSlots[leasedSlot] = clientID
+			return leasedSlot, nil
+		}
+	}
+
+	// First try to find a slot nobody else holds a lease for, only then take over leases of users not currently connected
+	slot, err := s.findFreeSlot(username, false)
+	if err == errSlotsExhausted {
+		slot, err = s.findFreeSlot(username, true)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	s.usedSlots[slot] = clientID
+	if username != "" {
+		s.setLease(username, slot)
+	}
+	return slot, nil
+}
+
+// findFreeSlot must be called with slotMutex held
+func (s *Server) findFreeSlot(username string, ignoreLeases bool) (uint64, error) {
+	maxSlot := s.getClientSlots()
+	for slot := uint64(1); slot <= maxSlot; slot++ {
+		if s.usedSlots[slot] != "" || s.staticSlotOwners[slot] != "" {
+			continue
+		}
+
+		leaseOwner := s.leasedSlotOwners[slot]
+		if !ignoreLeases && leaseOwner != "" && leaseOwner != username {
+			continue
+		}
+
+		return slot, nil
+	}
+	return 0, errSlotsExhausted
+}
+
+// getSocketUsername returns the username of a registered connection, "" if there is none (yet)
+func (s *Server) getSocketUsername(clientID string) string {
+	s.socketsLock.Lock()
+	defer s.socketsLock.Unlock()
+
+	socket := s.sockets[clientID]
+	if socket == nil {
+		return ""
+	}
+	username, _ := socket.Metadata["username"].(string)
+	return username
+}
+
+// takeSlot hands a static slot to clientID, closing any other connection holding it
+// Must be called with slotMutex held
+func (s *Server) takeSlot(slot uint64, clientID string, logger *log.Logger) error {
+	oldClientID := s.usedSlots[slot]
+	if oldClientID == "" {
+		s.usedSlots[slot] = clientID
+		return nil
+	}
+
+	s.socketsLock.Lock()
+	oldSocket := s.sockets[oldClientID]
+	s.socketsLock.Unlock()
+
+	// The other connection is still being set up, so we can not kick it
+	// Also respect the wish to keep old connections over new ones
+	if oldSocket == nil || s.MaxConnectionsPerUserMode == MaxConnectionsPerUserPreventNew {
+		return errStaticIPInUse
+	}
+
+	// Closing happens asynchronously, the packet handler will evict the old socket
+	// on its own (with an "IP conflict") should the new one register first
+	logger.Printf("Static IP in use by client %s, disconnecting it", oldClientID)
+	s.usedSlots[slot] = clientID
+	go oldSocket.CloseError(errors.New("IP conflict: static IP taken over by new connection"))
+	return nil
+}
+
+func (s *Server) releaseSlot(slot uint64, clientID string) {
+	s.slotMutex.Lock()
+	defer s.slotMutex.Unlock()
+
+	// Someone might have taken over this slot already, only release it if it is still ours
+	if s.usedSlots[slot] != clientID {
+		return
+	}
+	delete(s.usedSlots, slot)
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/Doridian/water"
+	"github.com/Doridian/wsvpn/server/authenticators"
 	"github.com/Doridian/wsvpn/shared"
 	"github.com/Doridian/wsvpn/shared/commands"
 	"github.com/Doridian/wsvpn/shared/features"
@@ -27,12 +30,36 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 	clientID := clientUUID.String()
 	clientLogger := shared.MakeLogger("CLIENT", clientID)
 
+	proxyAddr := r.RemoteAddr
+	r.RemoteAddr = s.resolveClientAddr(r)
+	isProxied := r.RemoteAddr != proxyAddr
+	if isProxied {
+		clientLogger.Printf("Client address %s (through proxy %s)", r.RemoteAddr, proxyAddr)
+	}
+
 	for key, values := range s.headers {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
 
+	isHandshake := s.isHandshakeRequest(r)
+	if !s.checkConnectionAllowed(w, r, clientID, isHandshake) {
+		return
+	}
+
+	endHandshake := func() {}
+	if isHandshake {
+		var ok bool
+		endHandshake, ok = s.beginHandshake()
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many connections being established, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		defer endHandshake()
+	}
+
 	tlsConnectionState := r.TLS
 
 	if r.URL.Path == rootRoutePreauthorize {
@@ -40,6 +67,11 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == routeJWKS {
+		s.handleJWKS(w, r)
+		return
+	}
+
 	if tlsConnectionState != nil {
 		clientLogger.Printf("TLS %s connection established with cipher=%s", shared.TLSVersionString(tlsConnectionState.Version), tls.CipherSuiteName(tlsConnectionState.CipherSuite))
 	} else {
@@ -48,11 +80,12 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 
 	var authOk bool
 	var authUsername string
-	if len(s.PreauthorizeSecret) > 0 && strings.HasPrefix(r.URL.Path, prefixRoutePreauthorize) {
+	var authDetails *authenticators.AuthDetails
+	if s.acceptsPreauthorizeTokens() && strings.HasPrefix(r.URL.Path, prefixRoutePreauthorize) {
 		preauthToken := r.URL.Path[len(prefixRoutePreauthorize):]
-		authOk, authUsername = s.handlePreauthorizeToken(clientLogger, w, r, preauthToken)
+		authOk, authUsername, authDetails = s.handlePreauthorizeToken(clientLogger, w, r, preauthToken)
 	} else {
-		authOk, authUsername = s.handleSocketAuth(clientLogger, w, r, tlsConnectionState)
+		authOk, authUsername, authDetails = s.handleSocketAuth(clientLogger, w, r, tlsConnectionState)
 	}
 
 	if !authOk {
@@ -79,6 +112,7 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !wasUpgraded {
+		endHandshake()
 		s.serveHTTP(w, r, authUsername)
 		return
 	}
@@ -88,29 +122,18 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 	}()
 	s.addCloser(adapter)
 
+	endHandshake()
 	clientLogger.Printf("Upgraded connection to %s", adapter.Name())
+	s.connectionsTotal.Add(1)
 
-	var slot uint64 = 1
-	maxSlot := s.VPNNet.GetClientSlots() + 1
-	s.slotMutex.Lock()
-	for s.usedSlots[slot] {
-		slot = slot + 1
-		if slot > maxSlot {
-			s.slotMutex.Unlock()
-			clientLogger.Println("Cannot connect new client: IP slots exhausted")
-			return
-		}
+	slot, err := s.allocateSlot(clientID, authUsername, authDetails.FramedIP, clientLogger)
+	if err != nil {
+		clientLogger.Printf("Cannot connect new client: %v", err)
+		return
 	}
-	s.usedSlots[slot] = true
-	s.slotMutex.Unlock()
+	defer s.releaseSlot(slot, clientID)
 
-	defer func() {
-		s.slotMutex.Lock()
-		delete(s.usedSlots, slot)
-		s.slotMutex.Unlock()
-	}()
-
-	ipClient, err := s.VPNNet.GetIPAt(int(slot) + 1)
+	ipClients, err := s.slotToIPs(slot)
 	if err != nil {
 		clientLogger.Printf("Error transforming client IP: %v", err)
 		return
@@ -152,7 +175,12 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 		clientLogger.Printf("Assigned interface %s", localIfaceW.Name())
 
 		if s.DoLocalIPConfig {
-			err = localIface.Configure(s.VPNNet.GetServerIP(), nil, ipClient)
+			for i, vpnNet := range s.getVPNNets() {
+				err = localIface.Configure(vpnNet.GetServerIP(), nil, ipClients[i])
+				if err != nil {
+					break
+				}
+			}
 		} else {
 			err = localIface.Configure(nil, nil, nil)
 		}
@@ -170,11 +198,21 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 		localIface = s.mainIface
 	}
 
-	remoteNetStr := fmt.Sprintf("%s/%d", ipClient.String(), s.VPNNet.GetSize())
+	remoteNetStrs := make([]commands.IPAddressWithCIDR, 0, len(ipClients))
+	for i, vpnNet := range s.getVPNNets() {
+		remoteNetStrs = append(remoteNetStrs, fmt.Sprintf("%s/%d", ipClients[i].String(), vpnNet.GetSize()))
+	}
+	remoteNetStr := remoteNetStrs[0]
 	ifaceName := localIface.Interface.Name()
 
+	vpnIPStrs := make([]string, 0, len(ipClients))
+	for _, ip := range ipClients {
+		vpnIPStrs = append(vpnIPStrs, ip.String())
+	}
+
 	doRunEventScript := func(event string) {
-		eventErr := s.RunEventScript(event, remoteNetStr, ifaceName, authUsername)
+		s.publishSocketEvent(event, clientID, authUsername, r.RemoteAddr, vpnIPStrs)
+		eventErr := s.RunEventScript(event, remoteNetStr, ifaceName, authUsername, r.RemoteAddr)
 		if eventErr != nil {
 			s.log.Printf("Error in %s script: %v", event, eventErr)
 		}
@@ -182,22 +220,41 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 
 	socket := sockets.MakeSocket(clientLogger, adapter, localIface, ifaceManaged, doRunEventScript)
 	socket.Metadata["username"] = authUsername
+	socket.Metadata["groups"] = s.getUserGroups(authUsername, authDetails.Groups)
+	socket.SetTLSConnectionState(tlsConnectionState)
+	if isProxied {
+		socket.SetRemoteAddr(forwardedAddr(r.RemoteAddr))
+	}
 	defer socket.Close()
 
 	maxConns := s.MaxConnectionsPerUser
 
 	s.socketsLock.Lock()
-	if authUsername != "" && maxConns > 0 {
+	if authUsername != "" {
 		userSocks := s.authenticatedSockets[authUsername]
 
-		if userSocks != nil && len(userSocks) >= maxConns {
+		if maxConns > 0 && len(userSocks) >= maxConns {
 			switch s.MaxConnectionsPerUserMode {
 			case MaxConnectionsPerUserKillOldest:
 				toKill := userSocks[0]
 				userSocks = userSocks[1:]
+				s.publishEvent(&Event{
+					Type:       EventTypeClientEvicted,
+					ClientID:   s.findClientID(toKill),
+					Username:   authUsername,
+					RemoteAddr: toKill.RemoteAddr().String(),
+					Reason:     "maximum connections for user exceeded",
+				})
 				toKill.CloseError(errors.New("maximum connections for user exceeded"))
 			case MaxConnectionsPerUserPreventNew:
 				s.socketsLock.Unlock()
+				s.publishEvent(&Event{
+					Type:       EventTypeClientRejected,
+					ClientID:   clientID,
+					Username:   authUsername,
+					RemoteAddr: r.RemoteAddr,
+					Reason:     "maximum connections for user exceeded",
+				})
 				socket.CloseError(errors.New("maximum connections for user exceeded"))
 				return
 			}
@@ -211,11 +268,15 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 		s.authenticatedSockets[authUsername] = userSocks
 	}
 	s.sockets[clientID] = socket
+	s.applyBandwidthLimits(socket)
 	s.socketsLock.Unlock()
 
 	defer func() {
 		s.socketsLock.Lock()
 		delete(s.sockets, clientID)
+		delete(s.pushedRoutes, clientID)
+		s.recordClosedSocket(socket, authUsername)
+		s.releaseUserLimiters(authUsername)
 
 		if authUsername != "" {
 			userSocks := s.authenticatedSockets[authUsername]
@@ -244,7 +305,14 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 		socket.SetLocalFeature(feat, en)
 	}
 
-	socket.AssignedIP = ipClient
+	socket.AssignedIPs = ipClients
+
+	clientNetworks := s.claimClientNetworks(clientID, authUsername, clientLogger)
+	defer s.releaseClientNetworks(clientID, clientNetworks)
+	socket.RoutedNetworks = clientNetworks
+
+	clientNetworkRoutes := s.addClientNetworkRoutes(localIface, clientNetworks, ipClients, clientLogger)
+	defer s.removeClientNetworkRoutes(localIface, clientNetworkRoutes, clientLogger)
 
 	if s.SocketConfigurator != nil {
 		err = s.SocketConfigurator.ConfigureSocket(socket)
@@ -271,6 +339,7 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 		Mode:                s.Mode.ToString(),
 		DoIPConfig:          s.DoRemoteIPConfig,
 		IPAddress:           remoteNetStr,
+		IPAddresses:         remoteNetStrs,
 		MTU:                 s.mtu,
 		EnableFragmentation: socket.IsLocalFeature(features.Fragmentation),
 	})
@@ -279,7 +348,76 @@ func (s *Server) serveSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Once the client is in pushedRoutes a concurrent reload pushes to it as well, holding
+	// its routes lock until the initial state is sent makes that reload wait for us
+	target := &pushTarget{
+		clientID: clientID,
+		socket:   socket,
+		routes:   &clientRoutes{pushed: make(map[string]bool)},
+	}
+	target.routes.lock.Lock()
+	s.socketsLock.Lock()
+	s.pushedRoutes[clientID] = target.routes
+	dnsConfig := s.dnsConfig
+	s.socketsLock.Unlock()
+
+	if dnsConfig != nil {
+		err = socket.MakeAndSendCommand(dnsConfig)
+		if err != nil && err != sockets.ErrCommandNotSupported {
+			target.routes.lock.Unlock()
+			socket.CloseError(fmt.Errorf("error sending set_dns command: %v", err))
+			return
+		}
+	}
+	s.syncSocketRoutes(target)
+	target.routes.lock.Unlock()
+
+	var sessionTimedOut atomic.Bool
+	if authDetails.SessionTimeout > 0 {
+		sessionTimer := time.AfterFunc(authDetails.SessionTimeout, func() {
+			sessionTimedOut.Store(true)
+			socket.CloseError(errors.New("session timeout"))
+		})
+		defer sessionTimer.Stop()
+	}
+
+	stopAccounting := s.startAccounting(authDetails, socket, &authenticators.AccountingRecord{
+		SessionID:  clientID,
+		Username:   authUsername,
+		RemoteAddr: r.RemoteAddr,
+		VPNIP:      ipClients[0],
+	})
+
 	socket.Wait()
+
+	if sessionTimedOut.Load() {
+		stopAccounting(authenticators.TerminateSessionTimeout)
+	} else {
+		stopAccounting(authenticators.TerminateUserRequest)
+	}
+}
+
+// findClientID must be called with socketsLock held
+func (s *Server) findClientID(socket *sockets.Socket) string {
+	for clientID, sock := range s.sockets {
+		if sock == socket {
+			return clientID
+		}
+	}
+	return ""
+}
+
+// DisconnectUser closes all connections of the given user and returns how many there were
+func (s *Server) DisconnectUser(username string, reason error) int {
+	s.socketsLock.Lock()
+	userSocks := make([]*sockets.Socket, len(s.authenticatedSockets[username]))
+	copy(userSocks, s.authenticatedSockets[username])
+	s.socketsLock.Unlock()
+
+	for _, socket := range userSocks {
+		socket.CloseError(reason)
+	}
+	return len(userSocks)
 }
 
 func (s *Server) UpdateSocketConfig() error {
@@ -12,6 +12,11 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request, username stri
 		return
 	}
 
+	if r.URL.Path == "/metrics" {
+		s.serveMetrics(w, r, username)
+		return
+	}
+
 	if s.WebsiteDirectory == "" {
 		http.Error(w, "Website not enabled", http.StatusNotFound)
 		return
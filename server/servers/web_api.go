@@ -2,31 +2,87 @@ package servers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/Doridian/wsvpn/shared/sockets"
 )
 
 type SocketStruct struct {
-	ClientID   string `json:"client_id"`
-	Protocol   string `json:"protocol"`
-	VPNIP      string `json:"vpn_ip"`
-	LocalAddr  string `json:"local_addr"`
-	RemoteAddr string `json:"remote_addr"`
-	Username   string `json:"username"`
+	ClientID        string    `json:"client_id"`
+	Protocol        string    `json:"protocol"`
+	VPNIP           string    `json:"vpn_ip"`
+	VPNIPs          []string  `json:"vpn_ips"`
+	LocalAddr       string    `json:"local_addr"`
+	RemoteAddr      string    `json:"remote_addr"`
+	Username        string    `json:"username"`
+	ConnectedSince  time.Time `json:"connected_since"`
+	BytesIn         uint64    `json:"bytes_in"`
+	BytesOut        uint64    `json:"bytes_out"`
+	PacketsIn       uint64    `json:"packets_in"`
+	PacketsOut      uint64    `json:"packets_out"`
+	PacketsDropped  uint64    `json:"packets_dropped"`
+	PingRTT         float64   `json:"ping_rtt_ms"`
+	Features        []string  `json:"features"`
+	ProtocolVersion int       `json:"protocol_version"`
+	Version         string    `json:"version"`
+	TLSVersion      string    `json:"tls_version"`
+	TLSCipher       string    `json:"tls_cipher"`
 }
 
 const apiRouteClients = "clients"
+const apiRouteACL = "acl"
+const apiRouteEvents = "events"
+const apiRouteMessage = "message"
+const apiRouteReload = "reload"
+const apiRouteMTU = "mtu"
+const apiRouteUsers = "users"
+
+const maxAPIRequestSize = 64 * 1024
+
+type MTUStruct struct {
+	MTU int `json:"mtu"`
+}
+
+type DisconnectedStruct struct {
+	Disconnected int `json:"disconnected"`
+}
 
 func socketToJSON(clientID string, socket *sockets.Socket) SocketStruct {
+	vpnIP := ""
+	vpnIPs := make([]string, 0, len(socket.AssignedIPs))
+	for _, ip := range socket.AssignedIPs {
+		vpnIPs = append(vpnIPs, ip.String())
+	}
+	if len(vpnIPs) > 0 {
+		vpnIP = vpnIPs[0]
+	}
+
+	stats := socket.GetStats()
+	tlsVersion, tlsCipher := socket.GetTLSInfo()
+
 	return SocketStruct{
-		ClientID:   clientID,
-		Protocol:   socket.GetAdapter().Name(),
-		VPNIP:      socket.AssignedIP.String(),
-		LocalAddr:  socket.LocalAddr().String(),
-		RemoteAddr: socket.RemoteAddr().String(),
-		Username:   socket.Metadata["username"].(string),
+		ClientID:        clientID,
+		Protocol:        socket.GetAdapter().Name(),
+		VPNIP:           vpnIP,
+		VPNIPs:          vpnIPs,
+		LocalAddr:       socket.LocalAddr().String(),
+		RemoteAddr:      socket.RemoteAddr().String(),
+		Username:        socket.Metadata["username"].(string),
+		ConnectedSince:  socket.GetConnectedSince(),
+		BytesIn:         stats.BytesReceived,
+		BytesOut:        stats.BytesSent,
+		PacketsIn:       stats.PacketsReceived,
+		PacketsOut:      stats.PacketsSent,
+		PacketsDropped:  stats.PacketsDropped,
+		PingRTT:         float64(socket.GetPingRTT().Microseconds()) / 1000,
+		Features:        socket.GetUsedFeatures(),
+		ProtocolVersion: socket.GetRemoteProtocolVersion(),
+		Version:         socket.GetRemoteVersion(),
+		TLSVersion:      tlsVersion,
+		TLSCipher:       tlsCipher,
 	}
 }
 
@@ -42,6 +98,18 @@ func serveJSON(data interface{}, w http.ResponseWriter) {
 	}
 }
 
+func serveJSONStatus(data interface{}, status int, w http.ResponseWriter) {
+	d, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
+
 func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, username string) {
 	if !s.APIEnabled {
 		http.Error(w, "API not enabled", http.StatusBadRequest)
@@ -53,6 +121,19 @@ func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, username strin
 		return
 	}
 
+	// Without an allowlist any authenticated VPN user gets here, keep the administration routes closed to them
+	s.handleAPI(w, r, len(s.APIUsers) > 0)
+}
+
+// checkAPIAdmin refuses administration routes (reload, MTU changes, messages, disconnecting users) if allowAdmin is false
+func checkAPIAdmin(w http.ResponseWriter, allowAdmin bool) bool {
+	if !allowAdmin {
+		http.Error(w, "API administration needs server.api.users or the admin listener", http.StatusForbidden)
+	}
+	return allowAdmin
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request, allowAdmin bool) {
 	pathSplit := strings.Split(r.URL.Path, "/")
 	switch len(pathSplit) {
 	case 3:
@@ -71,9 +152,94 @@ func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, username strin
 
 			serveJSON(sockets, w)
 			return
+		case apiRouteACL:
+			if r.Method != http.MethodGet {
+				break
+			}
+
+			rules := s.aclRules.Load()
+			if rules == nil {
+				http.Error(w, "Not found", http.StatusNotFound)
+				return
+			}
+
+			serveJSON(rules.GetStats(), w)
+			return
+		case apiRouteEvents:
+			if r.Method != http.MethodGet {
+				break
+			}
+
+			s.serveEvents(w, r)
+			return
+		case apiRouteMessage:
+			if r.Method != http.MethodPost {
+				break
+			}
+			if !checkAPIAdmin(w, allowAdmin) {
+				return
+			}
+
+			s.serveBroadcastMessage(w, r)
+			return
+		case apiRouteReload:
+			if r.Method != http.MethodPost {
+				break
+			}
+			if !checkAPIAdmin(w, allowAdmin) {
+				return
+			}
+
+			result := s.ReloadConfig()
+			status := http.StatusOK
+			if !result.Success {
+				status = http.StatusInternalServerError
+			}
+			serveJSONStatus(result, status, w)
+			return
+		case apiRouteMTU:
+			switch r.Method {
+			case http.MethodGet:
+				serveJSON(&MTUStruct{MTU: s.mtu}, w)
+				return
+			case http.MethodPut:
+				if !checkAPIAdmin(w, allowAdmin) {
+					return
+				}
+
+				req := &MTUStruct{}
+				err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequestSize)).Decode(req)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				err = s.UpdateMTU(req.MTU)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				serveJSON(req, w)
+				return
+			}
 		}
 	case 4:
 		switch pathSplit[2] {
+		case apiRouteUsers:
+			if r.Method != http.MethodDelete {
+				break
+			}
+			if !checkAPIAdmin(w, allowAdmin) {
+				return
+			}
+
+			disconnected := s.DisconnectUser(pathSplit[3], errors.New("disconnected by administrator"))
+			if disconnected == 0 {
+				http.Error(w, "Not found", http.StatusNotFound)
+				return
+			}
+			serveJSON(&DisconnectedStruct{Disconnected: disconnected}, w)
+			return
 		case apiRouteClients:
 			clientID := pathSplit[3]
 			s.socketsLock.Lock()
@@ -95,6 +261,25 @@ func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, username strin
 				return
 			}
 		}
+	case 5:
+		if pathSplit[2] != apiRouteClients || pathSplit[4] != apiRouteMessage || r.Method != http.MethodPost {
+			break
+		}
+		if !checkAPIAdmin(w, allowAdmin) {
+			return
+		}
+
+		s.socketsLock.Lock()
+		socket := s.sockets[pathSplit[3]]
+		s.socketsLock.Unlock()
+
+		if socket == nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		s.serveClientMessage(w, r, socket)
+		return
 	}
 
 	http.Error(w, "API method not implemented, yet", http.StatusNotFound)
@@ -7,13 +7,14 @@ import (
 	"github.com/Doridian/wsvpn/shared/commands"
 )
 
-func handleHTTPSerializationHeaders(w http.ResponseWriter, r *http.Request) commands.SerializationType {
-	serializationType := determineBestSerialization(r.Header)
+// allowBinary must only be set for transports that can carry non-UTF-8 control messages
+func handleHTTPSerializationHeaders(w http.ResponseWriter, r *http.Request, allowBinary bool) commands.SerializationType {
+	serializationType := determineBestSerialization(r.Header, allowBinary)
 	addSerializationHeader(w.Header(), serializationType)
 	return serializationType
 }
 
-func determineBestSerialization(header http.Header) commands.SerializationType {
+func determineBestSerialization(header http.Header, allowBinary bool) commands.SerializationType {
 	res := header.Get(commands.SupportedCommandSerializationsHeaderName)
 	if res == "" {
 		return commands.SerializationTypeJSON
@@ -30,6 +31,10 @@ func determineBestSerialization(header http.Header) commands.SerializationType {
 			continue
 		}
 
+		if !allowBinary && commands.SerializationTypeIsBinary(stype) {
+			continue
+		}
+
 		priority := commands.SerializationTypePriority(stype)
 		if priority > bestSerializationTypePriority {
 			bestSerializationTypePriority = priority
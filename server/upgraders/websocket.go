@@ -24,7 +24,8 @@ func (u *WebSocketUpgrader) SetHeaders(headers http.Header) {
 }
 
 func (u *WebSocketUpgrader) Upgrade(w http.ResponseWriter, r *http.Request) (adapters.SocketAdapter, error) {
-	serializationType := handleHTTPSerializationHeaders(w, r)
+	// WebSocket sends control messages as text frames, which must be valid UTF-8
+	serializationType := handleHTTPSerializationHeaders(w, r, false)
 
 	conn, _, _, err := u.upgrader.Upgrade(r, w)
 	if err != nil {
@@ -0,0 +1,89 @@
+package upgraders
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Doridian/wsvpn/shared/commands"
+	"github.com/gobwas/ws"
+)
+
+func TestDetermineBestSerialization(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      string
+		allowBinary bool
+		expected    commands.SerializationType
+	}{
+		{"no header", "", true, commands.SerializationTypeJSON},
+		{"json only", "json", true, commands.SerializationTypeJSON},
+		{"cbor preferred", "json, cbor", true, commands.SerializationTypeCBOR},
+		{"cbor without binary", "json, cbor", false, commands.SerializationTypeJSON},
+		{"cbor only without binary", "cbor", false, commands.SerializationTypeJSON},
+		{"unknown ignored", "msgpack, json", true, commands.SerializationTypeJSON},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			if test.header != "" {
+				header.Set(commands.SupportedCommandSerializationsHeaderName, test.header)
+			}
+
+			stype := determineBestSerialization(header, test.allowBinary)
+			if stype != test.expected {
+				t.Fatalf("expected %s, got %s", commands.SerializationTypeToString(test.expected), commands.SerializationTypeToString(stype))
+			}
+		})
+	}
+}
+
+// WebSocket sends control messages as text frames, so it must pick JSON even if the client offers CBOR
+func TestWebSocketUpgraderUsesJSON(t *testing.T) {
+	upgrader := NewWebSocketUpgrader()
+	adapterSerialization := make(chan commands.SerializationType, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adapter, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		adapterSerialization <- adapter.GetCommandSerializationType()
+		_ = adapter.Close()
+	}))
+	defer server.Close()
+
+	responseSerialization := ""
+	dialer := ws.Dialer{
+		Header: ws.HandshakeHeaderHTTP(http.Header{
+			commands.SupportedCommandSerializationsHeaderName: []string{"cbor, json"},
+		}),
+		OnHeader: func(key, value []byte) error {
+			if strings.EqualFold(string(key), commands.CommandSerializationHeaderName) {
+				responseSerialization = string(value)
+			}
+			return nil
+		},
+	}
+
+	conn, _, _, err := dialer.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// The upgrade response only carries the headers given to SetHeaders, clients use JSON if it does not name a serialization
+	if responseSerialization != "" && responseSerialization != "json" {
+		t.Errorf("expected the server to announce json or nothing, got %q", responseSerialization)
+	}
+	stype := <-adapterSerialization
+	if stype != commands.SerializationTypeJSON {
+		t.Errorf("expected the adapter to use json, got %s", commands.SerializationTypeToString(stype))
+	}
+}
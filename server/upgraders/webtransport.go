@@ -69,7 +69,7 @@ func (u *WebTransportUpgrader) SetQUICHeaders(header http.Header) error {
 }
 
 func (u *WebTransportUpgrader) Upgrade(w http.ResponseWriter, r *http.Request) (adapters.SocketAdapter, error) {
-	serializationType := handleHTTPSerializationHeaders(w, r)
+	serializationType := handleHTTPSerializationHeaders(w, r, true)
 
 	conn, err := u.server.Upgrade(w, r)
 	if err != nil {
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"github.com/Doridian/wsvpn/shared/compression"
+	"github.com/Doridian/wsvpn/shared/sockets"
+)
+
+type CompressionConfig struct {
+	Codec   string `yaml:"codec"`
+	MinSize int    `yaml:"min-size"`
+}
+
+type CompressionSocketConfigurator struct {
+	codec   compression.Codec
+	minSize int
+}
+
+func NewCompressionSocketConfigurator(config *CompressionConfig) (*CompressionSocketConfigurator, error) {
+	codec, err := compression.CodecFromString(config.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompressionSocketConfigurator{
+		codec:   codec,
+		minSize: config.MinSize,
+	}, nil
+}
+
+func (c *CompressionSocketConfigurator) ConfigureSocket(sock *sockets.Socket) error {
+	sock.SetCompression(c.codec, c.minSize)
+	return nil
+}
@@ -1,8 +1,6 @@
 package commands
 
 import (
-	"encoding/json"
-
 	"github.com/google/uuid"
 )
 
@@ -20,9 +18,11 @@ type CommandParameters interface {
 }
 
 type IncomingCommand struct {
-	ID         CommandID       `json:"id"`
-	Command    CommandName     `json:"command"`
-	Parameters json.RawMessage `json:"parameters"`
+	ID         CommandID
+	Command    CommandName
+	Parameters []byte
+
+	serializationType SerializationType
 }
 
 type OutgoingCommand struct {
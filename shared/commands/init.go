@@ -3,13 +3,14 @@ package commands
 const InitCommandName CommandName = "init"
 
 type InitParameters struct {
-	Mode                InterfaceMode     `json:"mode"`
-	DoIPConfig          bool              `json:"do_ip_config"`
-	IPAddress           IPAddressWithCIDR `json:"ip_address"`
-	MTU                 int               `json:"mtu"`
-	ServerID            string            `json:"server_id"`
-	ClientID            string            `json:"client_id"`
-	EnableFragmentation bool              `json:"enable_fragmentation"`
+	Mode                InterfaceMode       `json:"mode"`
+	DoIPConfig          bool                `json:"do_ip_config"`
+	IPAddress           IPAddressWithCIDR   `json:"ip_address"`
+	IPAddresses         []IPAddressWithCIDR `json:"ip_addresses,omitempty"`
+	MTU                 int                 `json:"mtu"`
+	ServerID            string              `json:"server_id"`
+	ClientID            string              `json:"client_id"`
+	EnableFragmentation bool                `json:"enable_fragmentation"`
 }
 
 func (c *InitParameters) MakeCommand(id string) (*OutgoingCommand, error) {
@@ -0,0 +1,23 @@
+package commands
+
+const RemoveRouteCommandName CommandName = "remove_route"
+
+type RemoveRouteParameters struct {
+	Route IPAddressWithCIDR `json:"route"`
+}
+
+func (c *RemoveRouteParameters) MakeCommand(id string) (*OutgoingCommand, error) {
+	return makeCommand(RemoveRouteCommandName, id, c)
+}
+
+func (c *RemoveRouteParameters) MinProtocolVersion() int {
+	return 13
+}
+
+func (c *RemoveRouteParameters) ServerCanIssue() bool {
+	return true
+}
+
+func (c *RemoveRouteParameters) ClientCanIssue() bool {
+	return false
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"strings"
 	"sync"
+
+	"github.com/fxamacker/cbor/v2"
 )
 
 type SerializationType = int
@@ -12,28 +14,51 @@ type SerializationType = int
 const (
 	SerializationTypeInvalid SerializationType = iota
 	SerializationTypeJSON
+	SerializationTypeCBOR
 )
 
 var serializationTypeMap map[string]SerializationType
 var serializationTypeReverseMap map[SerializationType]string
 var serializationTypePriorityMap map[SerializationType]int
+var serializationTypeBinaryMap map[SerializationType]bool
 var serializationInit = &sync.Once{}
 
+var errUnknownSerializationType = errors.New("unknown serialization type")
+
 const SupportedCommandSerializationsHeaderName = "Supported-Command-Serializations"
 const CommandSerializationHeaderName = "Command-Serialization"
 
-func addSerializationType(stype SerializationType, name string, priority int) {
+// Wire representations of IncomingCommand, one per serialization type
+// These keep the parameters raw so they can be decoded once the command name is known
+type incomingCommandJSON struct {
+	ID         CommandID       `json:"id"`
+	Command    CommandName     `json:"command"`
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+type incomingCommandCBOR struct {
+	ID         CommandID       `cbor:"id"`
+	Command    CommandName     `cbor:"command"`
+	Parameters cbor.RawMessage `cbor:"parameters"`
+}
+
+// binary indicates the serialization does not produce valid UTF-8 and thus
+// can not be used on transports that send control messages as text
+func addSerializationType(stype SerializationType, name string, priority int, binary bool) {
 	serializationTypeMap[name] = stype
 	serializationTypeReverseMap[stype] = name
 	serializationTypePriorityMap[stype] = priority
+	serializationTypeBinaryMap[stype] = binary
 }
 
 func initSerializationTypeMaps() {
 	serializationTypeMap = make(map[string]SerializationType)
 	serializationTypeReverseMap = make(map[SerializationType]string)
 	serializationTypePriorityMap = make(map[SerializationType]int)
+	serializationTypeBinaryMap = make(map[SerializationType]bool)
 
-	addSerializationType(SerializationTypeJSON, "json", 1)
+	addSerializationType(SerializationTypeJSON, "json", 1, false)
+	addSerializationType(SerializationTypeCBOR, "cbor", 2, true)
 }
 
 func initSerializationTypeMapsOnce() {
@@ -56,6 +81,12 @@ func SerializationTypePriority(stype SerializationType) int {
 	return serializationTypePriorityMap[stype]
 }
 
+func SerializationTypeIsBinary(stype SerializationType) bool {
+	initSerializationTypeMapsOnce()
+
+	return serializationTypeBinaryMap[stype]
+}
+
 func SerializationTypeFromString(name string) SerializationType {
 	initSerializationTypeMapsOnce()
 
@@ -66,17 +97,27 @@ func SerializationTypeFromString(name string) SerializationType {
 	return stype
 }
 
-func GetSupportedSerializationTypes() []SerializationType {
+func GetSupportedSerializationTypes(allowBinary bool) []SerializationType {
+	initSerializationTypeMapsOnce()
+
 	res := make([]SerializationType, 0, len(serializationTypeMap))
 	for _, stype := range serializationTypeMap {
+		if !allowBinary && serializationTypeBinaryMap[stype] {
+			continue
+		}
 		res = append(res, stype)
 	}
 	return res
 }
 
-func GetSupportedSerializationTypeNames() []string {
+func GetSupportedSerializationTypeNames(allowBinary bool) []string {
+	initSerializationTypeMapsOnce()
+
 	res := make([]string, 0, len(serializationTypeMap))
-	for name := range serializationTypeMap {
+	for name, stype := range serializationTypeMap {
+		if !allowBinary && serializationTypeBinaryMap[stype] {
+			continue
+		}
 		res = append(res, name)
 	}
 	return res
@@ -86,25 +127,49 @@ func (c *OutgoingCommand) Serialize(serializationType SerializationType) ([]byte
 	switch serializationType {
 	case SerializationTypeJSON:
 		return json.Marshal(c)
+	case SerializationTypeCBOR:
+		return cbor.Marshal(c)
 	}
-	return []byte{}, errors.New("unknown serialization type")
+	return []byte{}, errUnknownSerializationType
 }
 
 func (c *IncomingCommand) DeserializeParameters(parameters CommandParameters) error {
-	return json.Unmarshal(c.Parameters, parameters)
+	switch c.serializationType {
+	case SerializationTypeJSON:
+		return json.Unmarshal(c.Parameters, parameters)
+	case SerializationTypeCBOR:
+		return cbor.Unmarshal(c.Parameters, parameters)
+	}
+	return errUnknownSerializationType
 }
 
 func DeserializeCommand(message []byte, serializationType SerializationType) (*IncomingCommand, error) {
-	var command IncomingCommand
-	var err error
+	command := &IncomingCommand{
+		serializationType: serializationType,
+	}
+
 	switch serializationType {
 	case SerializationTypeJSON:
-		err = json.Unmarshal(message, &command)
+		var wireCommand incomingCommandJSON
+		err := json.Unmarshal(message, &wireCommand)
+		if err != nil {
+			return nil, err
+		}
+		command.ID = wireCommand.ID
+		command.Command = wireCommand.Command
+		command.Parameters = wireCommand.Parameters
+	case SerializationTypeCBOR:
+		var wireCommand incomingCommandCBOR
+		err := cbor.Unmarshal(message, &wireCommand)
+		if err != nil {
+			return nil, err
+		}
+		command.ID = wireCommand.ID
+		command.Command = wireCommand.Command
+		command.Parameters = wireCommand.Parameters
 	default:
-		err = errors.New("unknown serialization type")
+		return nil, errUnknownSerializationType
 	}
-	if err != nil {
-		return nil, err
-	}
-	return &command, nil
+
+	return command, nil
 }
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Doridian/wsvpn/shared/features"
+)
+
+// testCommands holds one filled in instance of every command type
+var testCommands = []CommandParameters{
+	&AddRouteParameters{Route: "10.0.0.0/8"},
+	&RemoveRouteParameters{Route: "fd00::/8"},
+	&InitParameters{
+		Mode:                "TUN",
+		DoIPConfig:          true,
+		IPAddress:           "192.168.3.2/24",
+		IPAddresses:         []IPAddressWithCIDR{"192.168.3.2/24", "fd00:3::2/64"},
+		MTU:                 1420,
+		ServerID:            "server-id",
+		ClientID:            "client-id",
+		EnableFragmentation: true,
+	},
+	&MessageParameters{Type: "warning", Message: "Maintenance in 5 minutes, ümlauts included"},
+	&ReplyParameters{Ok: false, Message: "command not supported"},
+	&SetDNSParameters{Nameservers: []string{"192.168.3.1", "fd00:3::1"}, SearchDomains: []string{"corp.example.com"}},
+	&SetMTUParameters{MTU: 1280},
+	&VersionParameters{ProtocolVersion: 13, Version: "5.0.0", EnabledFeatures: []features.Feature{features.Fragmentation, features.Compression}},
+}
+
+func TestSerializationRoundTrip(t *testing.T) {
+	for _, stype := range GetSupportedSerializationTypes(true) {
+		for _, parameters := range testCommands {
+			outgoing, err := parameters.MakeCommand("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Run(SerializationTypeToString(stype)+"/"+outgoing.Command, func(t *testing.T) {
+				data, err := outgoing.Serialize(stype)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !SerializationTypeIsBinary(stype) && !utf8.Valid(data) {
+					t.Fatal("expected valid UTF-8 from a non-binary serialization")
+				}
+
+				incoming, err := DeserializeCommand(data, stype)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if incoming.ID != outgoing.ID || incoming.Command != outgoing.Command {
+					t.Fatalf("expected command %s (%s), got %s (%s)", outgoing.Command, outgoing.ID, incoming.Command, incoming.ID)
+				}
+
+				decoded := reflect.New(reflect.TypeOf(parameters).Elem()).Interface().(CommandParameters)
+				err = incoming.DeserializeParameters(decoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(decoded, parameters) {
+					t.Fatalf("expected parameters %+v, got %+v", parameters, decoded)
+				}
+			})
+		}
+	}
+}
+
+func TestSerializationMismatch(t *testing.T) {
+	outgoing, err := (&SetMTUParameters{MTU: 1280}).MakeCommand("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := outgoing.Serialize(SerializationTypeCBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = DeserializeCommand(data, SerializationTypeJSON)
+	if err == nil {
+		t.Fatal("expected CBOR to not decode as JSON")
+	}
+
+	_, err = outgoing.Serialize(SerializationTypeInvalid)
+	if err != errUnknownSerializationType {
+		t.Fatalf("expected errUnknownSerializationType, got %v", err)
+	}
+	_, err = DeserializeCommand(data, SerializationTypeInvalid)
+	if err != errUnknownSerializationType {
+		t.Fatalf("expected errUnknownSerializationType, got %v", err)
+	}
+}
+
+func TestSerializationTypes(t *testing.T) {
+	if SerializationTypeFromString("CBOR") != SerializationTypeCBOR || SerializationTypeFromString("json") != SerializationTypeJSON {
+		t.Fatal("expected serialization names to be looked up case insensitive")
+	}
+	if SerializationTypeFromString("msgpack") != SerializationTypeInvalid {
+		t.Fatal("expected unknown serialization names to be invalid")
+	}
+
+	// Transports sending control messages as text (WebSocket) must only ever see JSON
+	textTypes := GetSupportedSerializationTypes(false)
+	if !reflect.DeepEqual(textTypes, []SerializationType{SerializationTypeJSON}) {
+		t.Fatalf("expected only JSON without binary serializations, got %v", textTypes)
+	}
+	textNames := GetSupportedSerializationTypeNames(false)
+	if !reflect.DeepEqual(textNames, []string{"json"}) {
+		t.Fatalf("expected only json without binary serializations, got %v", textNames)
+	}
+
+	if SerializationTypePriority(SerializationTypeCBOR) <= SerializationTypePriority(SerializationTypeJSON) {
+		t.Fatal("expected CBOR to be preferred over JSON where it can be used")
+	}
+}
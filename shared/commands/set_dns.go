@@ -0,0 +1,24 @@
+package commands
+
+const SetDNSCommandName CommandName = "set_dns"
+
+type SetDNSParameters struct {
+	Nameservers   []string `json:"nameservers"`
+	SearchDomains []string `json:"search_domains"`
+}
+
+func (c *SetDNSParameters) MakeCommand(id string) (*OutgoingCommand, error) {
+	return makeCommand(SetDNSCommandName, id, c)
+}
+
+func (c *SetDNSParameters) MinProtocolVersion() int {
+	return 13
+}
+
+func (c *SetDNSParameters) ServerCanIssue() bool {
+	return true
+}
+
+func (c *SetDNSParameters) ClientCanIssue() bool {
+	return false
+}
@@ -0,0 +1,84 @@
+package compression
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type CodecID = byte
+
+const (
+	CodecIDNone CodecID = iota
+	CodecIDDeflate
+	CodecIDZstd
+)
+
+// Largest packet we will ever produce when decompressing, anything above that is treated as an error
+const MaxDecompressedSize = 0xFFFF
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+var ErrDecompressedTooLarge = errors.New("decompressed payload too large")
+
+type Codec interface {
+	ID() CodecID
+	Name() string
+
+	// Compress appends the compressed form of src to dst and returns the resulting slice
+	Compress(dst []byte, src []byte) ([]byte, error)
+	// Decompress returns the decompressed form of src, which must not exceed MaxDecompressedSize
+	Decompress(src []byte) ([]byte, error)
+}
+
+var codecMap map[string]Codec
+var codecIDMap map[CodecID]Codec
+var codecInit = &sync.Once{}
+
+func addCodec(codec Codec) {
+	codecMap[codec.Name()] = codec
+	codecIDMap[codec.ID()] = codec
+}
+
+func initCodecMaps() {
+	codecMap = make(map[string]Codec)
+	codecIDMap = make(map[CodecID]Codec)
+
+	addCodec(newDeflateCodec())
+	addCodec(newZstdCodec())
+}
+
+func initCodecMapsOnce() {
+	codecInit.Do(initCodecMaps)
+}
+
+func CodecFromString(name string) (Codec, error) {
+	initCodecMapsOnce()
+
+	codec, ok := codecMap[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+func CodecFromID(id CodecID) (Codec, error) {
+	initCodecMapsOnce()
+
+	codec, ok := codecIDMap[id]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec, nil
+}
+
+func GetSupportedCodecNames() []string {
+	initCodecMapsOnce()
+
+	res := make([]string, 0, len(codecMap))
+	for name := range codecMap {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
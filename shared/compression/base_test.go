@@ -0,0 +1,128 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func testPayloads(t *testing.T) map[string][]byte {
+	random := make([]byte, 1500)
+	_, err := rand.Read(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string][]byte{
+		"empty":          {},
+		"single byte":    {0x42},
+		"repetitive":     bytes.Repeat([]byte("wsvpn "), 250),
+		"random":         random,
+		"max size":       bytes.Repeat([]byte{0}, MaxDecompressedSize),
+		"ip header-like": {0x45, 0x00, 0x00, 0x1c, 0x12, 0x34, 0x00, 0x00, 0x40, 0x11},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range GetSupportedCodecNames() {
+		codec, err := CodecFromString(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for payloadName, payload := range testPayloads(t) {
+			t.Run(name+"/"+payloadName, func(t *testing.T) {
+				prefix := []byte{codec.ID()}
+				compressed, err := codec.Compress(prefix, payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if compressed[0] != codec.ID() {
+					t.Fatalf("expected Compress to keep the prefix in dst, got %x", compressed[0])
+				}
+
+				decompressed, err := codec.Decompress(compressed[1:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(decompressed, payload) {
+					t.Fatalf("round trip changed the payload (%d bytes in, %d bytes out)", len(payload), len(decompressed))
+				}
+			})
+		}
+	}
+}
+
+func TestCodecLookup(t *testing.T) {
+	for _, name := range []string{"deflate", "zstd", "ZSTD"} {
+		codec, err := CodecFromString(name)
+		if err != nil {
+			t.Fatalf("expected codec %s to be found, got %v", name, err)
+		}
+
+		byID, err := CodecFromID(codec.ID())
+		if err != nil || byID != codec {
+			t.Fatalf("expected ID %d to give back codec %s, got %v %v", codec.ID(), codec.Name(), byID, err)
+		}
+	}
+
+	_, err := CodecFromString("lz4")
+	if err != ErrUnknownCodec {
+		t.Errorf("expected ErrUnknownCodec for unknown name, got %v", err)
+	}
+
+	for _, id := range []CodecID{CodecIDNone, 3, 0xFF} {
+		_, err := CodecFromID(id)
+		if err != ErrUnknownCodec {
+			t.Errorf("expected ErrUnknownCodec for ID %d, got %v", id, err)
+		}
+	}
+}
+
+// A small payload that decompresses to more than any packet could be must be rejected
+func TestDecompressionBomb(t *testing.T) {
+	bomb := bytes.Repeat([]byte{0}, MaxDecompressedSize+1)
+
+	for _, name := range GetSupportedCodecNames() {
+		t.Run(name, func(t *testing.T) {
+			codec, err := CodecFromString(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compressed, err := codec.Compress(nil, bomb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(compressed) > 1024 {
+				t.Fatalf("expected the bomb to compress well, got %d bytes", len(compressed))
+			}
+
+			// zstd already stops at the memory limit of its decoder
+			decompressed, err := codec.Decompress(compressed)
+			if err == nil {
+				t.Fatalf("expected an error, got %d bytes", len(decompressed))
+			}
+			if codec.ID() == CodecIDDeflate && !errors.Is(err, ErrDecompressedTooLarge) {
+				t.Fatalf("expected ErrDecompressedTooLarge, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecompressGarbage(t *testing.T) {
+	for _, name := range GetSupportedCodecNames() {
+		t.Run(name, func(t *testing.T) {
+			codec, err := CodecFromString(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = codec.Decompress([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+			if err == nil {
+				t.Fatal("expected an error for garbage input")
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+type deflateCodec struct {
+	writerPool *sync.Pool
+}
+
+var _ Codec = &deflateCodec{}
+
+func newDeflateCodec() *deflateCodec {
+	return &deflateCodec{
+		writerPool: &sync.Pool{
+			New: func() interface{} {
+				writer, _ := flate.NewWriter(nil, flate.BestSpeed)
+				return writer
+			},
+		},
+	}
+}
+
+func (c *deflateCodec) ID() CodecID {
+	return CodecIDDeflate
+}
+
+func (c *deflateCodec) Name() string {
+	return "deflate"
+}
+
+func (c *deflateCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+
+	writer := c.writerPool.Get().(*flate.Writer)
+	defer c.writerPool.Put(writer)
+	writer.Reset(buf)
+
+	_, err := writer.Write(src)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *deflateCodec) Decompress(src []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(src))
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	buf := &bytes.Buffer{}
+	n, err := io.Copy(buf, io.LimitReader(reader, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return buf.Bytes(), nil
+}
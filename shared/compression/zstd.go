@@ -0,0 +1,46 @@
+package compression
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+var _ Codec = &zstdCodec{}
+
+func newZstdCodec() *zstdCodec {
+	// Both of these only fail on invalid options, so the errors can be ignored
+	encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize), zstd.WithDecoderConcurrency(0))
+
+	return &zstdCodec{
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+func (c *zstdCodec) ID() CodecID {
+	return CodecIDZstd
+}
+
+func (c *zstdCodec) Name() string {
+	return "zstd"
+}
+
+func (c *zstdCodec) Compress(dst []byte, src []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(src, dst), nil
+}
+
+func (c *zstdCodec) Decompress(src []byte) ([]byte, error) {
+	res, err := c.decoder.DecodeAll(src, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > MaxDecompressedSize {
+		return nil, ErrDecompressedTooLarge
+	}
+	return res, nil
+}
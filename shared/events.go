@@ -8,18 +8,21 @@ const (
 	EventUp      = "up"
 	EventDown    = "down"
 	EventStartup = "startup"
+	EventMessage = "message"
 )
 
 type EventConfigHolder struct {
 	UpScript      []string
 	DownScript    []string
 	StartupScript []string
+	MessageScript []string
 }
 
 type EventConfig struct {
 	Up      []string `yaml:"up"`
 	Down    []string `yaml:"down"`
 	Startup []string `yaml:"startup"`
+	Message []string `yaml:"message"`
 }
 
 func (c *EventConfigHolder) RunEventScript(op string, remoteNet string, iface string, args ...string) error {
@@ -32,6 +35,8 @@ func (c *EventConfigHolder) RunEventScript(op string, remoteNet string, iface st
 		script = c.DownScript
 	case EventStartup:
 		script = c.StartupScript
+	case EventMessage:
+		script = c.MessageScript
 	default:
 		return fmt.Errorf("invalid event %s", op)
 	}
@@ -53,4 +58,5 @@ func (c *EventConfigHolder) LoadEventConfig(config *EventConfig) {
 	c.UpScript = config.Up
 	c.DownScript = config.Down
 	c.StartupScript = config.Startup
+	c.MessageScript = config.Message
 }
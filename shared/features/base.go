@@ -10,7 +10,7 @@ const (
 type Config = map[Feature]bool
 
 func IsFeatureSupported(feat Feature) bool {
-	return feat == Fragmentation
+	return feat == Fragmentation || feat == Compression
 }
 
 type Container interface {
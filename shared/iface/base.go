@@ -1,6 +1,7 @@
 package iface
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -9,9 +10,12 @@ import (
 	"github.com/Doridian/wsvpn/shared"
 )
 
+var ErrDNSNotSupported = errors.New("DNS configuration not supported on this platform")
+
 type WaterInterfaceWrapper struct {
 	Interface    *water.Interface
 	netInterface *net.Interface
+	dnsRestore   func() error
 }
 
 func NewInterfaceWrapper(iface *water.Interface) *WaterInterfaceWrapper {
@@ -94,6 +98,16 @@ func getInterfaceNameOrPrefix(ifaceConfig *InterfaceConfig) string {
 	return ifaceConfig.Name
 }
 
+// RestoreDNS undoes the last successful SetDNS call, if any
+func (w *WaterInterfaceWrapper) RestoreDNS() error {
+	if w.dnsRestore == nil {
+		return nil
+	}
+	restore := w.dnsRestore
+	w.dnsRestore = nil
+	return restore()
+}
+
 func (w *WaterInterfaceWrapper) SetMTU(mtu int) error {
 	return w.Interface.SetMTU(mtu)
 }
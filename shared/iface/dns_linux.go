@@ -0,0 +1,92 @@
+package iface
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Doridian/wsvpn/shared"
+)
+
+const systemdResolvedRuntimeDir = "/run/systemd/resolve"
+
+func isSystemdResolvedActive() bool {
+	_, err := exec.LookPath("resolvectl")
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(systemdResolvedRuntimeDir)
+	return err == nil
+}
+
+func (w *WaterInterfaceWrapper) SetDNS(nameservers []net.IP, searchDomains []string) error {
+	err := w.RestoreDNS()
+	if err != nil {
+		return err
+	}
+
+	if isSystemdResolvedActive() {
+		return w.setDNSSystemdResolved(nameservers, searchDomains)
+	}
+
+	_, err = exec.LookPath("resolvconf")
+	if err == nil {
+		return w.setDNSResolvconf(nameservers, searchDomains)
+	}
+
+	return ErrDNSNotSupported
+}
+
+func (w *WaterInterfaceWrapper) setDNSSystemdResolved(nameservers []net.IP, searchDomains []string) error {
+	ifaceName := w.Interface.Name()
+
+	w.dnsRestore = func() error {
+		return shared.ExecCmd("resolvectl", "revert", ifaceName)
+	}
+
+	if len(nameservers) > 0 {
+		args := []string{"dns", ifaceName}
+		for _, nameserver := range nameservers {
+			args = append(args, nameserver.String())
+		}
+		err := shared.ExecCmd("resolvectl", args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(searchDomains) > 0 {
+		args := append([]string{"domain", ifaceName}, searchDomains...)
+		err := shared.ExecCmd("resolvectl", args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *WaterInterfaceWrapper) setDNSResolvconf(nameservers []net.IP, searchDomains []string) error {
+	// resolvconf records are keyed by interface, the suffix marks it as ours
+	recordName := fmt.Sprintf("%s.wsvpn", w.Interface.Name())
+
+	resolvConf := &strings.Builder{}
+	for _, nameserver := range nameservers {
+		_, _ = fmt.Fprintf(resolvConf, "nameserver %s\n", nameserver.String())
+	}
+	if len(searchDomains) > 0 {
+		_, _ = fmt.Fprintf(resolvConf, "search %s\n", strings.Join(searchDomains, " "))
+	}
+
+	err := shared.ExecCmdWithStdin(resolvConf.String(), "resolvconf", "-a", recordName)
+	if err != nil {
+		return err
+	}
+
+	w.dnsRestore = func() error {
+		return shared.ExecCmd("resolvconf", "-d", recordName)
+	}
+	return nil
+}
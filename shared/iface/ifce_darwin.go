@@ -48,6 +48,15 @@ func (w *WaterInterfaceWrapper) AddIPRoute(ipNet *net.IPNet, gateway net.IP) err
 	return shared.ExecCmd("route", "add", fmt.Sprintf("-%s", inetType), "-net", ipNet.String(), gateway.String())
 }
 
+func (w *WaterInterfaceWrapper) RemoveIPRoute(ipNet *net.IPNet, gateway net.IP) error {
+	inetType := inetFamily(ipNet.IP)
+	return shared.ExecCmd("route", "delete", fmt.Sprintf("-%s", inetType), "-net", ipNet.String(), gateway.String())
+}
+
+func (w *WaterInterfaceWrapper) SetDNS(nameservers []net.IP, searchDomains []string) error {
+	return ErrDNSNotSupported
+}
+
 func GetPlatformSpecifics(config *water.Config, ifaceConfig *InterfaceConfig) error {
 	setName := getInterfaceNameOrPrefix(ifaceConfig)
 	if setName != "" {
@@ -39,6 +39,10 @@ func (w *WaterInterfaceWrapper) AddIPRoute(ipNet *net.IPNet, gateway net.IP) err
 	return shared.ExecCmd("ip", "route", "add", ipNet.String(), "via", gateway.String())
 }
 
+func (w *WaterInterfaceWrapper) RemoveIPRoute(ipNet *net.IPNet, gateway net.IP) error {
+	return shared.ExecCmd("ip", "route", "del", ipNet.String(), "via", gateway.String())
+}
+
 func GetPlatformSpecifics(config *water.Config, ifaceConfig *InterfaceConfig) error {
 	setName := getInterfaceNameOrPrefix(ifaceConfig)
 	if setName != "" {
@@ -55,6 +55,18 @@ func (w *WaterInterfaceWrapper) AddIPRoute(ipNet *net.IPNet, gateway net.IP) err
 	return shared.ExecCmd("route", "ADD", ipNet.String(), gateway.String(), "IF", fmt.Sprintf("%d", iface.Index))
 }
 
+func (w *WaterInterfaceWrapper) RemoveIPRoute(ipNet *net.IPNet, gateway net.IP) error {
+	iface, err := w.GetNetInterface()
+	if err != nil {
+		return err
+	}
+	return shared.ExecCmd("route", "DELETE", ipNet.String(), gateway.String(), "IF", fmt.Sprintf("%d", iface.Index))
+}
+
+func (w *WaterInterfaceWrapper) SetDNS(nameservers []net.IP, searchDomains []string) error {
+	return ErrDNSNotSupported
+}
+
 func GetPlatformSpecifics(config *water.Config, ifaceConfig *InterfaceConfig) error {
 	setName := getInterfaceNameOrPrefix(ifaceConfig)
 	if setName != "" {
@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Always allow at least one full packet worth of burst, otherwise large packets could never pass
+const minBurst = 0xFFFF
+
+// Allow bursts of this long at full rate
+const burstDuration = 100 * time.Millisecond
+
+// Limiter is a token bucket counting bytes
+type Limiter struct {
+	lock   *sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(bytesPerSecond uint64) *Limiter {
+	l := &Limiter{
+		lock: &sync.Mutex{},
+		last: time.Now(),
+	}
+	l.SetRate(bytesPerSecond)
+	l.tokens = l.burst
+	return l
+}
+
+func (l *Limiter) SetRate(bytesPerSecond uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.refill()
+	l.rate = float64(bytesPerSecond)
+	l.burst = l.rate * burstDuration.Seconds()
+	if l.burst < minBurst {
+		l.burst = minBurst
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// refill must be called with lock held
+func (l *Limiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *Limiter) hasTokens(n int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.refill()
+	return l.tokens >= float64(n)
+}
+
+// take removes n tokens and returns how long to wait until the bucket is no longer in debt
+func (l *Limiter) take(n int) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.refill()
+	l.tokens -= float64(n)
+	if l.tokens >= 0 || l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// AllowAll reports whether every limiter has n bytes available and consumes them if so
+// This is used for policing where waiting is not an option, packets that are not allowed should be dropped
+func AllowAll(limiters []*Limiter, n int) bool {
+	for _, l := range limiters {
+		if !l.hasTokens(n) {
+			return false
+		}
+	}
+	for _, l := range limiters {
+		l.take(n)
+	}
+	return true
+}
+
+// WaitAll consumes n bytes from every limiter, waiting until all of them allow it
+// It returns false if cancel got closed while waiting
+func WaitAll(limiters []*Limiter, n int, cancel <-chan bool) bool {
+	var wait time.Duration
+	for _, l := range limiters {
+		lWait := l.take(n)
+		if lWait > wait {
+			wait = lWait
+		}
+	}
+
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
+// ParseRate parses a rate in bits per second with an optional k, M or G suffix (powers of 1000)
+// and returns it in bytes per second. An empty string or 0 mean unlimited and are returned as 0
+func ParseRate(rate string) (uint64, error) {
+	origRate := rate
+	rate = strings.TrimSpace(rate)
+	if rate == "" {
+		return 0, nil
+	}
+
+	multiplier := uint64(1)
+	switch rate[len(rate)-1] {
+	case 'k', 'K':
+		multiplier = 1000
+	case 'm', 'M':
+		multiplier = 1000 * 1000
+	case 'g', 'G':
+		multiplier = 1000 * 1000 * 1000
+	}
+	if multiplier > 1 {
+		rate = rate[:len(rate)-1]
+	}
+
+	value, err := strconv.ParseFloat(rate, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate: %s", origRate)
+	}
+
+	res := uint64(value * float64(multiplier) / 8)
+	if res == 0 && value > 0 {
+		res = 1
+	}
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package sockets
+
+import (
+	"github.com/Doridian/wsvpn/shared/ratelimit"
+)
+
+// BandwidthLimiters holds the token buckets a socket's traffic has to pass
+// Upload is traffic received from the remote end, Download is traffic sent to it
+type BandwidthLimiters struct {
+	Upload   []*ratelimit.Limiter
+	Download []*ratelimit.Limiter
+}
+
+func (s *Socket) SetBandwidthLimiters(limiters *BandwidthLimiters) {
+	s.bandwidthLimiters.Store(limiters)
+}
+
+// allowUpload polices received packets, waiting here would stall the adapter
+// and with it control messages (like pings) of this connection
+func (s *Socket) allowUpload(n int) bool {
+	limiters := s.bandwidthLimiters.Load()
+	if limiters == nil || len(limiters.Upload) == 0 {
+		return true
+	}
+	return ratelimit.AllowAll(limiters.Upload, n)
+}
+
+// allowDownload polices packets handed to us by a switch, those must not block
+// as the switch is shared by all connections
+func (s *Socket) allowDownload(n int) bool {
+	limiters := s.bandwidthLimiters.Load()
+	if limiters == nil || len(limiters.Download) == 0 {
+		return true
+	}
+	return ratelimit.AllowAll(limiters.Download, n)
+}
+
+// waitDownload shapes packets read from our own interface by delaying them
+func (s *Socket) waitDownload(n int) bool {
+	limiters := s.bandwidthLimiters.Load()
+	if limiters == nil || len(limiters.Download) == 0 {
+		return true
+	}
+	return ratelimit.WaitAll(limiters.Download, n, s.closeChan)
+}
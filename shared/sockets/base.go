@@ -1,13 +1,18 @@
 package sockets
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"log"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Doridian/wsvpn/shared"
 	"github.com/Doridian/wsvpn/shared/commands"
+	"github.com/Doridian/wsvpn/shared/compression"
 	"github.com/Doridian/wsvpn/shared/features"
 	"github.com/Doridian/wsvpn/shared/iface"
 	"github.com/Doridian/wsvpn/shared/sockets/adapters"
@@ -19,9 +24,11 @@ const fragmentationNegotiatedMinProtocol = 11
 const featureFieldMinProtocol = 12
 
 type EventPusher = func(evt string)
+type MessageHandler = func(msgType string, message string)
 
 type Socket struct {
-	AssignedIP net.IP
+	AssignedIPs    []net.IP
+	RoutedNetworks []*net.IPNet
 
 	lastFragmentID        uint32
 	lastFragmentCleanup   time.Time
@@ -31,8 +38,20 @@ type Socket struct {
 	fragmentationEnabled  bool
 
 	compressionEnabled bool
+	compressionCodec   compression.Codec
+	compressionMinSize int
+
+	bandwidthLimiters atomic.Pointer[BandwidthLimiters]
+
+	counters socketCounters
 
 	remoteProtocolVersion int
+	remoteVersion         string
+	connectedSince        time.Time
+	tlsVersion            uint16
+	tlsCipherSuite        uint16
+	tlsVerifiedChain      []*x509.Certificate
+	remoteAddr            net.Addr
 
 	adapter          adapters.SocketAdapter
 	iface            *iface.WaterInterfaceWrapper
@@ -54,18 +73,20 @@ type Socket struct {
 
 	localFeatures  map[features.Feature]bool
 	remoteFeatures map[features.Feature]bool
-	usedFeatures   map[features.Feature]bool
+	usedFeatures   atomic.Pointer[map[features.Feature]bool] // Replaced as a whole, the API reads it from other goroutines
 
-	eventPusher EventPusher
-	upEventSent bool
+	eventPusher    EventPusher
+	messageHandler MessageHandler
+	upEventSent    bool
 
 	Metadata map[string]interface{}
 }
 
 func MakeSocket(logger *log.Logger, adapter adapters.SocketAdapter, iface *iface.WaterInterfaceWrapper, ifaceManaged bool, eventPusher EventPusher) *Socket {
 	return &Socket{
-		AssignedIP: net.IPv6unspecified,
-		mac:        shared.DefaultMAC,
+		AssignedIPs:    []net.IP{},
+		RoutedNetworks: []*net.IPNet{},
+		mac:            shared.DefaultMAC,
 
 		adapter:               adapter,
 		iface:                 iface,
@@ -76,6 +97,7 @@ func MakeSocket(logger *log.Logger, adapter adapters.SocketAdapter, iface *iface
 		closeChan:             make(chan bool),
 		closeChanOpen:         true,
 		remoteProtocolVersion: UndeterminedProtocolVersion,
+		connectedSince:        time.Now(),
 		packetBufferSize:      2000,
 		log:                   logger,
 		isReady:               false,
@@ -90,17 +112,41 @@ func MakeSocket(logger *log.Logger, adapter adapters.SocketAdapter, iface *iface
 		fragmentationEnabled:  false,
 
 		compressionEnabled: false,
+		compressionCodec:   nil,
+		compressionMinSize: defaultCompressionMinSize,
 
 		eventPusher: eventPusher,
 
 		localFeatures:  make(map[features.Feature]bool, 0),
 		remoteFeatures: make(map[features.Feature]bool, 0),
-		usedFeatures:   make(map[features.Feature]bool),
 
 		Metadata: make(map[string]interface{}),
 	}
 }
 
+func (s *Socket) HasAssignedIP(ip net.IP) bool {
+	for _, assignedIP := range s.AssignedIPs {
+		if assignedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowedSourceIP reports whether the client may send packets from ip, which is
+// the case for its assigned IPs as well as any network routed to it
+func (s *Socket) IsAllowedSourceIP(ip net.IP) bool {
+	if s.HasAssignedIP(ip) {
+		return true
+	}
+	for _, routedNetwork := range s.RoutedNetworks {
+		if routedNetwork.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Socket) ConfigurePing(pingInterval time.Duration, pingTimeout time.Duration) {
 	s.pingInterval = pingInterval
 	s.pingTimeout = pingTimeout
@@ -114,12 +160,61 @@ func (s *Socket) SetLocalFeature(feature features.Feature, enabled bool) {
 	s.localFeatures[feature] = true
 }
 
+func (s *Socket) GetRemoteProtocolVersion() int {
+	return s.remoteProtocolVersion
+}
+
+func (s *Socket) GetRemoteVersion() string {
+	return s.remoteVersion
+}
+
+// GetUsedFeatures returns the names of all features negotiated with the remote end, sorted
+func (s *Socket) GetUsedFeatures() []features.Feature {
+	usedFeatures := s.usedFeatures.Load()
+	if usedFeatures == nil {
+		return []features.Feature{}
+	}
+	res := make([]features.Feature, 0, len(*usedFeatures))
+	for feat, en := range *usedFeatures {
+		if en {
+			res = append(res, feat)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (s *Socket) SetTLSConnectionState(state *tls.ConnectionState) {
+	if state == nil {
+		return
+	}
+	s.tlsVersion = state.Version
+	s.tlsCipherSuite = state.CipherSuite
+	if len(state.VerifiedChains) > 0 {
+		s.tlsVerifiedChain = state.VerifiedChains[0]
+	}
+}
+
+// GetTLSVerifiedChain returns the verified client certificate chain (leaf first), nil without mTLS
+func (s *Socket) GetTLSVerifiedChain() []*x509.Certificate {
+	return s.tlsVerifiedChain
+}
+
+// GetTLSInfo returns the TLS version and cipher suite of the connection, both empty if it is unencrypted
+func (s *Socket) GetTLSInfo() (string, string) {
+	if s.tlsVersion == 0 {
+		return "", ""
+	}
+	return shared.TLSVersionString(s.tlsVersion), tls.CipherSuiteName(s.tlsCipherSuite)
+}
+
 func (s *Socket) IsLocalFeature(feature features.Feature) bool {
 	return s.localFeatures[feature]
 }
 
 func (s *Socket) IsFeatureEnabled(feature features.Feature) bool {
-	return s.usedFeatures[feature]
+	usedFeatures := s.usedFeatures.Load()
+	return usedFeatures != nil && (*usedFeatures)[feature]
 }
 
 func (s *Socket) SetPacketHandler(packetHandler PacketHandler) {
@@ -146,15 +241,16 @@ func (s *Socket) featureCheck() {
 		return
 	}
 
-	s.usedFeatures = make(map[features.Feature]bool)
+	usedFeatures := make(map[features.Feature]bool)
 	for feat, en := range s.localFeatures {
 		if !en {
 			continue
 		}
 		if s.remoteFeatures[feat] {
-			s.usedFeatures[feat] = true
+			usedFeatures[feat] = true
 		}
 	}
+	s.usedFeatures.Store(&usedFeatures)
 
 	if s.remoteProtocolVersion >= fragmentationMinProtocol && s.remoteProtocolVersion < fragmentationNegotiatedMinProtocol {
 		s.fragmentationEnabled = true
@@ -167,7 +263,7 @@ func (s *Socket) featureCheck() {
 	s.compressionEnabled = s.IsFeatureEnabled(features.Compression)
 
 	s.log.Printf("Setting fragmentation: %s", shared.BoolToEnabled(s.fragmentationEnabled))
-	// s.log.Printf("Setting compression: %s", shared.BoolToEnabled(s.compressionEnabled))
+	s.log.Printf("Setting compression: %s", shared.BoolToEnabled(s.compressionEnabled))
 
 	if s.adapter != nil {
 		s.adapter.RefreshFeatures()
@@ -231,7 +327,15 @@ func (s *Socket) LocalAddr() net.Addr {
 	return s.adapter.LocalAddr()
 }
 
+// SetRemoteAddr overrides the address of the adapter, for example with the client address told by a reverse proxy
+func (s *Socket) SetRemoteAddr(addr net.Addr) {
+	s.remoteAddr = addr
+}
+
 func (s *Socket) RemoteAddr() net.Addr {
+	if s.remoteAddr != nil {
+		return s.remoteAddr
+	}
 	return s.adapter.RemoteAddr()
 }
 
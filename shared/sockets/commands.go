@@ -59,6 +59,7 @@ func (s *Socket) registerDefaultCommandHandlers() {
 		}
 
 		s.remoteProtocolVersion = parameters.ProtocolVersion
+		s.remoteVersion = parameters.Version
 		s.log.Printf("Remote version is: %s (protocol %d)", parameters.Version, parameters.ProtocolVersion)
 
 		s.remoteFeatures = make(map[features.Feature]bool)
@@ -90,6 +91,9 @@ func (s *Socket) registerDefaultCommandHandlers() {
 			return err
 		}
 		s.log.Printf("Got %s message from remote: %s", parameters.Type, parameters.Message)
+		if s.messageHandler != nil {
+			s.messageHandler(parameters.Type, parameters.Message)
+		}
 		return nil
 	})
 }
@@ -111,6 +115,11 @@ func (s *Socket) sendDefaultWelcome() {
 	})
 }
 
+// SetMessageHandler sets a function to be called for every message the remote sends (in addition to logging it)
+func (s *Socket) SetMessageHandler(handler MessageHandler) {
+	s.messageHandler = handler
+}
+
 func (s *Socket) SendMessage(msgType string, message string) error {
 	return s.MakeAndSendCommand(&commands.MessageParameters{Type: msgType, Message: message})
 }
@@ -0,0 +1,60 @@
+package sockets
+
+import (
+	"errors"
+
+	"github.com/Doridian/wsvpn/shared/compression"
+)
+
+// Compression wire format (only if the "compression" feature is in use):
+// First byte: ID of the codec used to compress the payload that follows
+// An ID of 0 means the payload is not compressed (used for small or incompressible packets)
+// This header is applied to the whole packet before fragmentation
+// Example: [00000000] PAYLOAD
+//          [00000010] ZSTD_COMPRESSED_PAYLOAD
+
+var errCompressedPacketTooShort = errors.New("compressed packet too short")
+
+const defaultCompressionMinSize = 64
+
+func (s *Socket) SetCompression(codec compression.Codec, minSize int) {
+	s.compressionCodec = codec
+	s.compressionMinSize = minSize
+}
+
+func (s *Socket) compressPacket(data []byte) []byte {
+	codec := s.compressionCodec
+	if codec != nil && len(data) >= s.compressionMinSize {
+		buf := make([]byte, 1, len(data)+1)
+		buf[0] = codec.ID()
+		buf, err := codec.Compress(buf, data)
+		if err == nil && len(buf) <= len(data) {
+			return buf
+		}
+		if err != nil {
+			s.log.Printf("Error compressing packet, sending uncompressed: %v", err)
+		}
+	}
+
+	buf := make([]byte, len(data)+1)
+	buf[0] = compression.CodecIDNone
+	copy(buf[1:], data)
+	return buf
+}
+
+func (s *Socket) decompressPacket(data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, errCompressedPacketTooShort
+	}
+
+	codecID := data[0]
+	if codecID == compression.CodecIDNone {
+		return data[1:], nil
+	}
+
+	codec, err := compression.CodecFromID(codecID)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decompress(data[1:])
+}
@@ -0,0 +1,105 @@
+package sockets
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/Doridian/wsvpn/shared/compression"
+)
+
+func newTestCompressionSocket(t *testing.T, codecName string) *Socket {
+	codec, err := compression.CodecFromString(codecName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Socket{log: log.New(&bytes.Buffer{}, "", 0)}
+	s.SetCompression(codec, defaultCompressionMinSize)
+	return s
+}
+
+func TestCompressPacketRoundTrip(t *testing.T) {
+	packets := map[string][]byte{
+		"below min size": bytes.Repeat([]byte{0x45}, defaultCompressionMinSize-1),
+		"compressible":   bytes.Repeat([]byte("wsvpn "), 200),
+	}
+
+	for _, codecName := range compression.GetSupportedCodecNames() {
+		s := newTestCompressionSocket(t, codecName)
+
+		for name, packet := range packets {
+			t.Run(codecName+"/"+name, func(t *testing.T) {
+				data := s.compressPacket(packet)
+
+				expectedID := s.compressionCodec.ID()
+				if len(packet) < defaultCompressionMinSize {
+					expectedID = compression.CodecIDNone
+				}
+				if data[0] != expectedID {
+					t.Fatalf("expected codec ID %d, got %d", expectedID, data[0])
+				}
+
+				res, err := s.decompressPacket(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(res, packet) {
+					t.Fatal("round trip changed the packet")
+				}
+			})
+		}
+	}
+}
+
+// Packets that would grow when compressed are sent as they are
+func TestCompressPacketIncompressible(t *testing.T) {
+	s := newTestCompressionSocket(t, "zstd")
+
+	packet := make([]byte, 256)
+	for i := range packet {
+		packet[i] = byte(i*167 + 13)
+	}
+
+	data := s.compressPacket(packet)
+	if data[0] != compression.CodecIDNone || !bytes.Equal(data[1:], packet) {
+		t.Fatalf("expected the packet to be sent uncompressed, got codec ID %d and %d bytes", data[0], len(data))
+	}
+}
+
+func TestDecompressPacketInvalid(t *testing.T) {
+	s := newTestCompressionSocket(t, "zstd")
+
+	zstd, err := compression.CodecFromString("zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bomb, err := zstd.Compress([]byte{compression.CodecIDZstd}, make([]byte, 2*compression.MaxDecompressedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		expectedErr error
+	}{
+		{"empty", []byte{}, errCompressedPacketTooShort},
+		{"header only", []byte{compression.CodecIDNone}, errCompressedPacketTooShort},
+		{"unknown codec", []byte{0xFF, 0x45, 0x00}, compression.ErrUnknownCodec},
+		{"corrupt payload", []byte{compression.CodecIDDeflate, 0xFF, 0xFF, 0xFF}, nil},
+		{"decompression bomb", bomb, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := s.decompressPacket(test.data)
+			if err == nil {
+				t.Fatalf("expected an error, got %d bytes", len(res))
+			}
+			if test.expectedErr != nil && err != test.expectedErr {
+				t.Fatalf("expected %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
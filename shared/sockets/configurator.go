@@ -3,3 +3,17 @@ package sockets
 type SocketConfigurator interface {
 	ConfigureSocket(sock *Socket) error
 }
+
+type MultiSocketConfigurator struct {
+	Configurators []SocketConfigurator
+}
+
+func (c *MultiSocketConfigurator) ConfigureSocket(sock *Socket) error {
+	for _, configurator := range c.Configurators {
+		err := configurator.ConfigureSocket(sock)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
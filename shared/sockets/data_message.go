@@ -32,6 +32,24 @@ func (s *Socket) processPacket(packet []byte) bool {
 		return false
 	}
 
+	if s.compressionEnabled {
+		var err error
+		packet, err = s.decompressPacket(packet)
+		if err != nil {
+			s.log.Printf("Error decompressing packet: %v", err)
+			s.counters.packetsDropped.Add(1)
+			return false
+		}
+	}
+
+	s.counters.bytesReceived.Add(uint64(len(packet)))
+	s.counters.packetsReceived.Add(1)
+
+	if !s.allowUpload(len(packet)) {
+		s.counters.packetsDropped.Add(1)
+		return true
+	}
+
 	if s.packetHandler != nil {
 		res, err := s.packetHandler.HandlePacket(s, packet)
 		if err != nil {
@@ -109,6 +127,7 @@ func (s *Socket) dataMessageHandler(message []byte) bool {
 		for i := uint8(0); i <= uint8(fragInfo.lastIndex); i++ {
 			buf.Write(fragInfo.data[i])
 		}
+		s.counters.packetsReassembled.Add(1)
 		return s.processPacket(buf.Bytes())
 	}
 
@@ -142,14 +161,30 @@ func (s *Socket) cleanupFragments() {
 	for _, idx := range deleteIndices {
 		delete(s.defragBuffer, idx)
 	}
+	s.counters.packetsExpired.Add(uint64(len(deleteIndices)))
 }
 
 func (s *Socket) WritePacket(data []byte) error {
+	if !s.allowDownload(len(data)) {
+		s.counters.packetsDropped.Add(1)
+		return nil
+	}
+	return s.writePacket(data)
+}
+
+func (s *Socket) writePacket(data []byte) error {
 	// Ignore all packets before version negotiation
 	if s.remoteProtocolVersion == UndeterminedProtocolVersion || !s.isReady || s.isClosing {
 		return nil
 	}
 
+	s.counters.bytesSent.Add(uint64(len(data)))
+	s.counters.packetsSent.Add(1)
+
+	if s.compressionEnabled {
+		data = s.compressPacket(data)
+	}
+
 	if !s.fragmentationEnabled {
 		err := s.adapter.WriteDataMessage(data)
 		if err != nil {
@@ -200,6 +235,7 @@ func (s *Socket) writePacketFragmented(data []byte, maxLen uint16) error {
 	packetID2 := uint8((packetID >> 8) % 0xFF)
 	packetID3 := uint8((packetID >> 16) % 0xFF)
 	packetID4 := uint8((packetID >> 24) % 0xFF)
+	s.counters.fragmentsSent.Add(uint64(fragmentCount))
 	for frag := uint16(0); frag < fragmentCount; frag++ {
 		buf.Reset()
 
@@ -12,6 +12,11 @@ func (s *Socket) installPingPongHandlers() {
 	s.adapter.SetPongHandler(func() {
 		s.log.Println("Received pong")
 		pingTimeoutTimer.Stop()
+
+		pingSentTime := s.counters.pingSentTime.Swap(0)
+		if pingSentTime != 0 {
+			s.counters.pingRTT.Store(int64(time.Since(time.Unix(0, pingSentTime))))
+		}
 	})
 
 	s.wg.Add(1)
@@ -35,6 +40,7 @@ func (s *Socket) installPingPongHandlers() {
 					continue
 				}
 				s.log.Println("Sent ping")
+				s.counters.pingSentTime.Store(time.Now().UnixNano())
 				err := s.adapter.WritePingMessage()
 				if err != nil {
 					s.log.Printf("Error sending ping: %v", err)
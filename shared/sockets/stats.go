@@ -0,0 +1,66 @@
+package sockets
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats are counters of tunneled traffic, sizes are before compression and fragmentation
+// Received is traffic from the remote end, Sent is traffic to it
+type Stats struct {
+	BytesReceived      uint64
+	PacketsReceived    uint64
+	BytesSent          uint64
+	PacketsSent        uint64
+	FragmentsSent      uint64
+	PacketsReassembled uint64
+	PacketsExpired     uint64
+	PacketsDropped     uint64
+}
+
+func (st *Stats) Add(other *Stats) {
+	st.BytesReceived += other.BytesReceived
+	st.PacketsReceived += other.PacketsReceived
+	st.BytesSent += other.BytesSent
+	st.PacketsSent += other.PacketsSent
+	st.FragmentsSent += other.FragmentsSent
+	st.PacketsReassembled += other.PacketsReassembled
+	st.PacketsExpired += other.PacketsExpired
+	st.PacketsDropped += other.PacketsDropped
+}
+
+type socketCounters struct {
+	bytesReceived      atomic.Uint64
+	packetsReceived    atomic.Uint64
+	bytesSent          atomic.Uint64
+	packetsSent        atomic.Uint64
+	fragmentsSent      atomic.Uint64
+	packetsReassembled atomic.Uint64
+	packetsExpired     atomic.Uint64
+	packetsDropped     atomic.Uint64
+
+	pingSentTime atomic.Int64
+	pingRTT      atomic.Int64
+}
+
+func (s *Socket) GetStats() *Stats {
+	return &Stats{
+		BytesReceived:      s.counters.bytesReceived.Load(),
+		PacketsReceived:    s.counters.packetsReceived.Load(),
+		BytesSent:          s.counters.bytesSent.Load(),
+		PacketsSent:        s.counters.packetsSent.Load(),
+		FragmentsSent:      s.counters.fragmentsSent.Load(),
+		PacketsReassembled: s.counters.packetsReassembled.Load(),
+		PacketsExpired:     s.counters.packetsExpired.Load(),
+		PacketsDropped:     s.counters.packetsDropped.Load(),
+	}
+}
+
+func (s *Socket) GetConnectedSince() time.Time {
+	return s.connectedSince
+}
+
+// GetPingRTT returns the round trip time of the last answered ping, 0 if there was none
+func (s *Socket) GetPingRTT() time.Duration {
+	return time.Duration(s.counters.pingRTT.Load())
+}
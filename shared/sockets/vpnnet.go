@@ -53,7 +53,11 @@ func (s *Socket) tryServeIfaceRead() {
 				continue
 			}
 
-			err = s.WritePacket(packet[:n])
+			if !s.waitDownload(n) {
+				return
+			}
+
+			err = s.writePacket(packet[:n])
 			if err != nil {
 				return
 			}
@@ -22,6 +22,18 @@ func ExecCmd(cmd string, arg ...string) error {
 	return fmt.Errorf("command %s %s: %v", cmd, strings.Join(arg, " "), err)
 }
 
+func ExecCmdWithStdin(stdin string, cmd string, arg ...string) error {
+	cmdO := exec.Command(cmd, arg...)
+	cmdO.Stdin = strings.NewReader(stdin)
+	cmdO.Stdout = os.Stdout
+	cmdO.Stderr = os.Stderr
+	err := cmdO.Run()
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("command %s %s: %v", cmd, strings.Join(arg, " "), err)
+}
+
 func ExecCmdGetStdOut(cmd string, arg ...string) (string, error) {
 	stdoutBuffer := &bytes.Buffer{}
 	cmdO := exec.Command(cmd, arg...)
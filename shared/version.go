@@ -6,7 +6,7 @@ import (
 
 var (
 	Version         = "dev"
-	ProtocolVersion = 12
+	ProtocolVersion = 13
 )
 
 func PrintVersion() {
@@ -1,6 +1,8 @@
 package shared
 
 import (
+	"fmt"
+	"math/big"
 	"net"
 
 	"github.com/apparentlymart/go-cidr/cidr"
@@ -26,6 +28,21 @@ func (r *VPNNet) GetIPAt(idx int) (net.IP, error) {
 	return ip, err
 }
 
+// GetIPIndex is the inverse of GetIPAt
+func (r *VPNNet) GetIPIndex(ip net.IP) (uint64, error) {
+	if !r.ipNet.Contains(ip) {
+		return 0, fmt.Errorf("IP %s is not in subnet %s", ip.String(), r.ipNet.String())
+	}
+
+	ipInt := big.NewInt(0).SetBytes(ip.To16())
+	baseInt := big.NewInt(0).SetBytes(r.ipNet.IP.To16())
+	idx := ipInt.Sub(ipInt, baseInt)
+	if !idx.IsUint64() {
+		return 0, fmt.Errorf("IP %s index out of range", ip.String())
+	}
+	return idx.Uint64(), nil
+}
+
 func (r *VPNNet) GetNetmask() string {
 	return IPNetGetNetMask(r.ipNet)
 }
@@ -47,7 +64,14 @@ func (r *VPNNet) GetClientSlots() uint64 {
 	return cidr.AddressCount(r.ipNet) - 3
 }
 
+func (r *VPNNet) IsIPv4() bool {
+	return r.ip.To4() != nil
+}
+
 func (r *VPNNet) Equals(other *VPNNet) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
 	return r.str == other.str
 }
 
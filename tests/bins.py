@@ -8,7 +8,8 @@ from signal import SIGTERM
 from subprocess import Popen, check_output, DEVNULL, PIPE
 from tempfile import NamedTemporaryFile
 from threading import Thread, Condition
-from time import sleep
+from time import monotonic, sleep
+from socket import create_connection
 from typing import Any, Optional
 from yaml import dump as yaml_dump, safe_load as yaml_load
 from ipaddress import ip_address
@@ -87,8 +88,10 @@ class GoBin(Thread):
 
         self.iface_names = {}
         self.auth_names = {}
+        self.remote_addrs = {}
         self.iface_macs = {}
         self.startup_timeout = None
+        self.compression_enabled = False
 
         self.http_auth_enabled = False
         self.mtls_auth_enabled = False
@@ -106,7 +109,7 @@ class GoBin(Thread):
             return ".exe"
         return ""
 
-    def connect_to(self, server: GoBin, user: str = "", password: str = "", protocol: str = "AUTO") -> None:
+    def connect_to(self, server: GoBin, user: str = "", password: str = "", protocol: str = "AUTO", path: str = "") -> None:
         if not self.is_client or not server.is_server:
             raise ValueError("Can only connect client to server")
 
@@ -129,7 +132,28 @@ class GoBin(Thread):
             auth_str = f"{user}:{password}@"
             self.http_auth_enabled = True
 
-        self.cfg["client"]["server"] = f"{protocol}://{auth_str}127.0.0.1:{port}"
+        self.cfg["client"]["server"] = f"{protocol}://{auth_str}127.0.0.1:{port}{path}"
+
+    # The server logs that it is online right before it starts listening, so wait for that before making requests ourselves
+    def wait_listening(self, timeout: float = 5) -> None:
+        if not self.is_server:
+            raise Exception("Only servers can use wait_listening")
+
+        deadline = monotonic() + timeout
+        while True:
+            try:
+                create_connection(("127.0.0.1", self.port), timeout=1).close()
+                return
+            except OSError:
+                if monotonic() > deadline:
+                    raise
+                sleep(0.1)
+
+    def http_url(self, path: str = "/") -> str:
+        if not self.is_server:
+            raise Exception("Only servers can use http_url")
+
+        return f"http://127.0.0.1:{self.port}{path}"
 
     def enable_tls(self, tls_cert_set: Optional[TLSCertSet]) -> None:
         if self.is_client:
@@ -178,6 +202,9 @@ class GoBin(Thread):
         if self.is_server and "VPN server online at" in line:
             self._notify_ready(True)
 
+        if "Setting compression: enabled" in line:
+            self.compression_enabled = True
+
         if "SCRIPT_HDL" in line:
             lspl = line.split(" ")[2:]
 
@@ -192,6 +219,7 @@ class GoBin(Thread):
                 if self.is_server:
                     self.iface_names[ip] = lspl[2]
                     self.auth_names[ip] = lspl[3] if (len(lspl) >= 4) else ""
+                    self.remote_addrs[ip] = lspl[4] if (len(lspl) >= 5) else ""
 
             elif lspl[0] == "down":
                 if self.is_client:
@@ -202,6 +230,7 @@ class GoBin(Thread):
                     ip = split_ip(lspl[1])
                     self.iface_names.pop(ip)
                     self.auth_names.pop(ip)
+                    self.remote_addrs.pop(ip)
 
             else:
                 raise Exception(f"script called with invalid args: {lspl}")
@@ -209,6 +238,17 @@ class GoBin(Thread):
     def get_ip(self) -> str:
         return self.ip
 
+    # Clients get the IP at the same index of tunnel.secondary-subnet as of tunnel.subnet, so does the server
+    def get_secondary_ip_for(self, clbin: GoBin = None) -> str:
+        if not self.is_server:
+            raise Exception("Only servers can use get_secondary_ip_for")
+
+        ip = clbin.get_ip() if clbin else self.ip
+        subnet = ip_address(split_ip(self.cfg["tunnel"]["subnet"]))
+        secondary_subnet = ip_address(
+            split_ip(self.cfg["tunnel"]["secondary-subnet"]))
+        return (secondary_subnet + (int(ip_address(ip)) - int(subnet))).exploded
+
     def get_auth_for(self, clbin: GoBin = None) -> str:
         if not self.is_server:
             raise Exception("Only servers can use get_auth_for")
@@ -216,6 +256,13 @@ class GoBin(Thread):
         client_ip = clbin.get_ip()
         return self.auth_names[client_ip]
 
+    def get_remote_addr_for(self, clbin: GoBin = None) -> str:
+        if not self.is_server:
+            raise Exception("Only servers can use get_remote_addr_for")
+
+        client_ip = clbin.get_ip()
+        return self.remote_addrs[client_ip]
+
     def get_interface_for(self, clbin: GoBin = None) -> str:
         if self.is_client:
             # clbin does not matter here, we only have one iface
@@ -6,7 +6,7 @@ from shutil import rmtree
 from os import remove
 
 from tests.bins import new_clbin, new_svbin
-from tests.tls_utils import tls_cert_set
+from tests.tls_utils import tls_ca, tls_cert_set
 
 
 TEST_USER = "testuser"
@@ -65,6 +65,14 @@ def tls_cert_client() -> Generator:
     rmtree(res.dir)
 
 
+# CA for client certificates issued by tls_cert_signed
+@pytest.fixture(scope="session")
+def tls_client_ca() -> Generator:
+    res = tls_ca("wsvpn-test-client-ca")
+    yield res
+    rmtree(res.dir)
+
+
 @pytest.fixture(scope="function")
 def svbin() -> Generator:
     gobin = new_svbin()
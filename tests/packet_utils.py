@@ -99,7 +99,7 @@ class PacketTestRun:
 
         return len(self._expected_packets) == 0
 
-    def run(self):
+    def run(self, should_arrive: bool = True):
         self._expected_packets = self.pkts[:]
 
         t = Thread(target=self._send_packets)
@@ -107,7 +107,10 @@ class PacketTestRun:
                              stop_filter=self._handle_packet, count=0, store=False, timeout=2)
         t.join()
 
-        assert len(self._expected_packets) == 0
+        if should_arrive:
+            assert len(self._expected_packets) == 0
+        else:
+            assert len(self._expected_packets) == len(self.pkts)
 
 
 class PacketTest:
@@ -137,12 +140,38 @@ class PacketTest:
         else:
             raise ValueError(f"Invalid ip_version {self.ip_version}")
 
+        self.ip_pkt_add(pkt)
+
+    def ip_pkt_add(self, pkt):
         if self.need_dummy_layer:
             pkt = scapy_layers.Loopback(
                 type=0x1e if self.ip_version == 6 else 0x2) / pkt
 
         self.pkt_add(pkt)
 
+    # Splits a single UDP datagram into fragments, only the first one carries the ports
+    def fragmented_pkt(self, pktlen: int, fragsize: int):
+        payload = scapy_layers.UDP(sport=124, dport=125, chksum=0) / \
+            scapy_packet.Raw(bytes(b"A"*pktlen))
+
+        if self.ip_version == 4:
+            frags = scapy_layers.fragment(
+                scapy_layers.IP(version=4, id=0x1234) / payload, fragsize=fragsize)
+            ip_layer = scapy_layers.IP
+        elif self.ip_version == 6:
+            frags = scapy_layers.fragment6(scapy_layers.IPv6(
+                version=6) / scapy_layers.IPv6ExtHdrFragment(id=0x1234) / payload, fragsize)
+            ip_layer = scapy_layers.IPv6
+        else:
+            raise ValueError(f"Invalid ip_version {self.ip_version}")
+
+        for frag in frags:
+            # Dissect the fragments again, so they compare equal to what is sniffed
+            frag = ip_layer(bytes(frag))
+            if self.ip_version == 4:
+                del frag.chksum
+            self.ip_pkt_add(frag)
+
     def add_defaults(self, minimal: bool):
         self.simple_pkt(1)
         if minimal:
@@ -151,7 +180,7 @@ class PacketTest:
         self.simple_pkt(1000)
         self.simple_pkt(1300)
 
-    def run(self):
+    def run(self, client_to_server: bool = True, server_to_client: bool = True):
         self.svbin.assert_ready_ok()
         self.clbin.assert_ready_ok()
 
@@ -161,6 +190,9 @@ class PacketTest:
         client_iface = self.clbin.get_interface_for()
         server_ip = self.svbin.get_ip()
         client_ip = self.clbin.get_ip()
+        if get_ip_version(server_ip) != self.ip_version:
+            server_ip = self.svbin.get_secondary_ip_for()
+            client_ip = self.svbin.get_secondary_ip_for(self.clbin)
         server_mac = None
         client_mac = None
         if self.ethernet:
@@ -174,14 +206,20 @@ class PacketTest:
 
         print("CLIENT SENDING, SERVER RECEIVING", flush=True)
         test = PacketTestRun(self.pkts, src=client_tuple, dst=server_tuple)
-        test.run()
+        test.run(should_arrive=client_to_server)
 
         print("SERVER SENDING, CLIENT RECEIVING", flush=True)
         test = PacketTestRun(self.pkts, src=server_tuple, dst=client_tuple)
-        test.run()
+        test.run(should_arrive=server_to_client)
+
+
+def fragmented_traffic_test(svbin: GoBin, clbin: GoBin, ip_version: int = 4, client_to_server: bool = True, server_to_client: bool = True) -> None:
+    t = PacketTest(svbin=svbin, clbin=clbin, ip_version=ip_version)
+    t.fragmented_pkt(pktlen=1000, fragsize=400)
+    t.run(client_to_server=client_to_server, server_to_client=server_to_client)
 
 
-def basic_traffic_test(svbin: GoBin, clbin: GoBin, minimal: bool = False, ip_version: int = 4) -> None:
+def basic_traffic_test(svbin: GoBin, clbin: GoBin, minimal: bool = False, ip_version: int = 4, client_to_server: bool = True, server_to_client: bool = True) -> None:
     t = PacketTest(svbin=svbin, clbin=clbin, ip_version=ip_version)
     t.add_defaults(minimal=minimal)
-    t.run()
+    t.run(client_to_server=client_to_server, server_to_client=server_to_client)
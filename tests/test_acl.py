@@ -0,0 +1,105 @@
+from tests.bins import GoBin
+from tests.conftest import TEST_PASSWORD, TEST_USER
+from tests.packet_utils import basic_traffic_test, fragmented_traffic_test
+
+
+# basic_traffic_test and fragmented_traffic_test send UDP packets from port 124 to port 125 in both directions
+def run_acl_test(svbin: GoBin, clbin: GoBin, acl: dict, client_to_server: bool, server_to_client: bool, fragmented: bool = False) -> None:
+    svbin.cfg["tunnel"]["acl"] = acl
+    clbin.connect_to(svbin)
+
+    svbin.start()
+    svbin.assert_ready_ok()
+
+    clbin.start()
+    clbin.assert_ready_ok()
+
+    if fragmented:
+        fragmented_traffic_test(svbin=svbin, clbin=clbin,
+                                client_to_server=client_to_server, server_to_client=server_to_client)
+    else:
+        basic_traffic_test(svbin=svbin, clbin=clbin, minimal=True,
+                           client_to_server=client_to_server, server_to_client=server_to_client)
+
+
+def test_run_acl_allow_all(svbin: GoBin, clbin: GoBin) -> None:
+    run_acl_test(svbin=svbin, clbin=clbin, acl={
+        "default-action": "allow",
+        "rules": [],
+    }, client_to_server=True, server_to_client=True)
+
+
+def test_run_acl_deny_rule(svbin: GoBin, clbin: GoBin) -> None:
+    run_acl_test(svbin=svbin, clbin=clbin, acl={
+        "default-action": "allow",
+        "rules": [
+            {"action": "deny", "direction": "in",
+                "protocol": "udp", "destination-ports": ["125"]},
+        ],
+    }, client_to_server=False, server_to_client=True)
+
+
+def test_run_acl_deny_rule_other_port(svbin: GoBin, clbin: GoBin) -> None:
+    run_acl_test(svbin=svbin, clbin=clbin, acl={
+        "default-action": "allow",
+        "rules": [
+            {"action": "deny", "protocol": "udp",
+                "destination-ports": ["1000-2000"]},
+        ],
+    }, client_to_server=True, server_to_client=True)
+
+
+def test_run_acl_default_deny(svbin: GoBin, clbin: GoBin) -> None:
+    run_acl_test(svbin=svbin, clbin=clbin, acl={
+        "default-action": "deny",
+        "rules": [],
+    }, client_to_server=False, server_to_client=False)
+
+
+def test_run_acl_default_deny_allow_rule(svbin: GoBin, clbin: GoBin) -> None:
+    run_acl_test(svbin=svbin, clbin=clbin, acl={
+        "default-action": "deny",
+        "rules": [
+            {"action": "allow", "direction": "out", "protocol": "udp"},
+        ],
+    }, client_to_server=False, server_to_client=True)
+
+
+def test_run_acl_default_deny_allow_port_fragmented(svbin: GoBin, clbin: GoBin) -> None:
+    run_acl_test(svbin=svbin, clbin=clbin, acl={
+        "default-action": "deny",
+        "rules": [
+            {"action": "allow", "protocol": "udp",
+                "destination-ports": ["125"]},
+        ],
+    }, client_to_server=True, server_to_client=True, fragmented=True)
+
+
+def test_run_acl_default_deny_allow_other_port_fragmented(svbin: GoBin, clbin: GoBin) -> None:
+    run_acl_test(svbin=svbin, clbin=clbin, acl={
+        "default-action": "deny",
+        "rules": [
+            {"action": "allow", "protocol": "udp",
+                "destination-ports": ["126"]},
+        ],
+    }, client_to_server=False, server_to_client=False, fragmented=True)
+
+
+def test_run_acl_default_deny_allow_user(svbin: GoBin, clbin: GoBin, authenticator_config: str) -> None:
+    svbin.cfg["server"]["authenticator"]["type"] = "htpasswd"
+    svbin.cfg["server"]["authenticator"]["config"] = authenticator_config
+    svbin.cfg["tunnel"]["acl"] = {
+        "default-action": "deny",
+        "rules": [
+            {"action": "allow", "users": [TEST_USER]},
+        ],
+    }
+    clbin.connect_to(svbin, user=TEST_USER, password=TEST_PASSWORD)
+
+    svbin.start()
+    svbin.assert_ready_ok()
+
+    clbin.start()
+    clbin.assert_ready_ok()
+
+    basic_traffic_test(svbin=svbin, clbin=clbin, minimal=True)
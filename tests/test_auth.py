@@ -1,7 +1,10 @@
 import pytest
 
+from os import remove
 from shutil import rmtree
+from tempfile import NamedTemporaryFile
 from typing import Generator, Optional
+from yaml import dump as yaml_dump
 from tests.bins import GoBin, new_clbin
 from tests.conftest import INVALID_TEXT, TEST_PASSWORD, TEST_USER
 from tests.tls_utils import TLSCertSet, tls_cert_set
@@ -22,7 +25,23 @@ def tls_cert_client_invalid_user() -> Generator:
     rmtree(res.dir)
 
 
-def run_client_auth(svbin: GoBin, protocol: str, tls_cert_server: Optional[TLSCertSet], mtls: Optional[TLSCertSet], user: str, password: str, should_be_ok: bool) -> None:
+OTHER_USER = "otheruser"
+OTHER_PASSWORD = "0tHeRpAsS5678"
+
+
+@pytest.fixture(scope="module")
+def authenticator_config_other() -> Generator:
+    aconf = None
+    with NamedTemporaryFile(mode="w", delete=False) as f:
+        f.write(f"{TEST_USER}:{TEST_PASSWORD}\n")
+        f.write(f"{OTHER_USER}:{OTHER_PASSWORD}\n")
+        aconf = f.name
+
+    yield aconf
+    remove(aconf)
+
+
+def run_client_auth(svbin: GoBin, protocol: str, tls_cert_server: Optional[TLSCertSet], mtls: Optional[TLSCertSet], user: str, password: str, should_be_ok: bool, expected_user: str = TEST_USER) -> None:
     clbin = new_clbin()
 
     try:
@@ -36,7 +55,7 @@ def run_client_auth(svbin: GoBin, protocol: str, tls_cert_server: Optional[TLSCe
 
         if should_be_ok:
             basic_traffic_test(svbin=svbin, clbin=clbin, minimal=True)
-            assert svbin.get_auth_for(clbin=clbin) == TEST_USER
+            assert svbin.get_auth_for(clbin=clbin) == expected_user
 
     finally:
         clbin.stop()
@@ -154,3 +173,47 @@ def test_run_e2e_webtransport_htpasswd(svbin: GoBin, tls_cert_server: TLSCertSet
 
     run_auth_server(svbin=svbin, protocol="webtransport", tls_cert_server=tls_cert_server, mtls_server=tls_cert_client,
                     mtls_on=False, authenticator="htpasswd", authenticator_config=authenticator_config)
+
+
+# Chain
+def run_chain_server(svbin: GoBin, mode: str, authenticator_configs: list[str], logins: list[tuple[str, str, bool]]) -> None:
+    chain_config = None
+    with NamedTemporaryFile(mode="w", delete=False) as f:
+        yaml_dump({
+            "mode": mode,
+            "authenticators": [{"type": "htpasswd", "config": aconf} for aconf in authenticator_configs],
+        }, f)
+        chain_config = f.name
+
+    try:
+        svbin.cfg["server"]["authenticator"]["type"] = "chain"
+        svbin.cfg["server"]["authenticator"]["config"] = chain_config
+        svbin.http_auth_enabled = True
+
+        svbin.start()
+        svbin.assert_ready_ok()
+
+        for user, password, should_be_ok in logins:
+            run_client_auth(svbin=svbin, tls_cert_server=None, protocol="ws", mtls=None,
+                            user=user, password=password, should_be_ok=should_be_ok, expected_user=user)
+    finally:
+        remove(chain_config)
+
+
+def test_run_e2e_ws_chain_any(svbin: GoBin, authenticator_config: str, authenticator_config_other: str) -> None:
+    run_chain_server(svbin=svbin, mode="any", authenticator_configs=[authenticator_config, authenticator_config_other], logins=[
+        (TEST_USER, TEST_PASSWORD, True),
+        (OTHER_USER, OTHER_PASSWORD, True),
+        (OTHER_USER, TEST_PASSWORD, False),
+        (INVALID_TEXT, TEST_PASSWORD, False),
+        ("", "", False),
+    ])
+
+
+def test_run_e2e_ws_chain_all(svbin: GoBin, authenticator_config: str, authenticator_config_other: str) -> None:
+    run_chain_server(svbin=svbin, mode="all", authenticator_configs=[authenticator_config, authenticator_config_other], logins=[
+        (TEST_USER, TEST_PASSWORD, True),
+        (OTHER_USER, OTHER_PASSWORD, False),
+        (TEST_USER, OTHER_PASSWORD, False),
+        ("", "", False),
+    ])
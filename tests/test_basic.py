@@ -106,3 +106,39 @@ def test_run_e2e_ipv6_tap(svbin: GoBin, clbin: GoBin) -> None:
     clbin.assert_ready_ok()
 
     basic_traffic_test(svbin=svbin, clbin=clbin, ip_version=6)
+
+
+@pytest.mark.parametrize("codec", ["zstd", "deflate"])
+def test_run_e2e_compression(svbin: GoBin, clbin: GoBin, codec: str) -> None:
+    svbin.cfg["tunnel"]["features"]["compression"] = True
+    svbin.cfg["tunnel"]["compression"]["codec"] = codec
+    clbin.cfg["tunnel"]["features"]["compression"] = True
+    clbin.cfg["tunnel"]["compression"]["codec"] = codec
+    clbin.connect_to(svbin)
+
+    svbin.start()
+    svbin.assert_ready_ok()
+
+    clbin.start()
+    clbin.assert_ready_ok()
+
+    basic_traffic_test(svbin=svbin, clbin=clbin)
+
+    assert svbin.compression_enabled
+    assert clbin.compression_enabled
+
+
+def test_run_e2e_dual_stack(svbin: GoBin, clbin: GoBin) -> None:
+    svbin.cfg["tunnel"]["mode"] = "TUN"
+    svbin.cfg["tunnel"]["secondary-subnet"] = "fd42:1338:%x::/64" % svbin.port
+
+    clbin.connect_to(svbin)
+
+    svbin.start()
+    svbin.assert_ready_ok()
+
+    clbin.start()
+    clbin.assert_ready_ok()
+
+    basic_traffic_test(svbin=svbin, clbin=clbin)
+    basic_traffic_test(svbin=svbin, clbin=clbin, ip_version=6)
@@ -0,0 +1,124 @@
+import pytest
+
+from shutil import rmtree
+from typing import Generator, Optional
+from tests.bins import GoBin, new_clbin
+from tests.conftest import TEST_PASSWORD, TEST_USER
+from tests.packet_utils import basic_traffic_test
+from tests.tls_utils import TLSCertSet, tls_cert_signed
+
+
+OTHER_USER = "otheruser"
+
+
+# The CN is the default identity, so it is set to something else (or a valid username) to make sure it is not used
+@pytest.fixture(scope="module")
+def tls_cert_client_email(tls_client_ca: TLSCertSet) -> Generator:
+    res = tls_cert_signed(tls_client_ca, "someone",
+                          san=f"email:{TEST_USER}@example.com")
+    yield res
+    rmtree(res.dir)
+
+
+@pytest.fixture(scope="module")
+def tls_cert_client_email_other(tls_client_ca: TLSCertSet) -> Generator:
+    res = tls_cert_signed(tls_client_ca, TEST_USER,
+                          san=f"email:{OTHER_USER}@example.com")
+    yield res
+    rmtree(res.dir)
+
+
+@pytest.fixture(scope="module")
+def tls_cert_client_email_nomatch(tls_client_ca: TLSCertSet) -> Generator:
+    res = tls_cert_signed(tls_client_ca, TEST_USER,
+                          san=f"email:{TEST_USER}@example.org")
+    yield res
+    rmtree(res.dir)
+
+
+@pytest.fixture(scope="module")
+def tls_cert_client_uri(tls_client_ca: TLSCertSet) -> Generator:
+    res = tls_cert_signed(tls_client_ca, "someone",
+                          san=f"URI:spiffe://example.com/users/{TEST_USER}, email:{OTHER_USER}@example.com")
+    yield res
+    rmtree(res.dir)
+
+
+def run_client_identity(svbin: GoBin, tls_cert_server: TLSCertSet, mtls: TLSCertSet, user: str, password: str, expected_user: Optional[str]) -> None:
+    clbin = new_clbin()
+
+    try:
+        clbin.connect_to(server=svbin, protocol="wss",
+                         user=user, password=password)
+        clbin.enable_tls(tls_cert_server)
+        clbin.enable_mtls(mtls)
+
+        clbin.start()
+        clbin.assert_ready_ok(should=(expected_user is not None))
+
+        if expected_user is not None:
+            basic_traffic_test(svbin=svbin, clbin=clbin, minimal=True)
+            assert svbin.get_auth_for(clbin=clbin) == expected_user
+
+    finally:
+        clbin.stop()
+
+
+def start_identity_server(svbin: GoBin, tls_cert_server: TLSCertSet, tls_client_ca: TLSCertSet, identity: dict, authenticator_config: str = "") -> None:
+    svbin.enable_tls(tls_cert_server)
+    svbin.enable_mtls(tls_client_ca)
+    svbin.cfg["server"]["tls"]["identity"].update(identity)
+
+    if authenticator_config:
+        svbin.cfg["server"]["authenticator"]["type"] = "htpasswd"
+        svbin.cfg["server"]["authenticator"]["config"] = authenticator_config
+        svbin.http_auth_enabled = True
+
+    svbin.start()
+    svbin.assert_ready_ok()
+
+
+EMAIL_IDENTITY = {
+    "source": "email",
+    "pattern": r"^(.+)@example\.com$",
+}
+
+
+def test_run_identity_email(svbin: GoBin, tls_cert_server: TLSCertSet, tls_client_ca: TLSCertSet, tls_cert_client_email: TLSCertSet, tls_cert_client_email_other: TLSCertSet, tls_cert_client_email_nomatch: TLSCertSet) -> None:
+    start_identity_server(svbin, tls_cert_server,
+                          tls_client_ca, EMAIL_IDENTITY)
+
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_email,
+                        user="", password="", expected_user=TEST_USER)
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_email_other,
+                        user="", password="", expected_user=OTHER_USER)
+    # No SAN matching the pattern, so there is no identity (the CN is not used)
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_email_nomatch,
+                        user="", password="", expected_user=None)
+
+
+def test_run_identity_uri_replacement(svbin: GoBin, tls_cert_server: TLSCertSet, tls_client_ca: TLSCertSet, tls_cert_client_uri: TLSCertSet, tls_cert_client_email: TLSCertSet) -> None:
+    start_identity_server(svbin, tls_cert_server, tls_client_ca, {
+        "source": "uri",
+        "pattern": r"^spiffe://example\.com/users/([a-z]+)$",
+        "replacement": "${1}",
+    })
+
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_uri,
+                        user="", password="", expected_user=TEST_USER)
+    # Only has an email SAN
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_email,
+                        user="", password="", expected_user=None)
+
+
+def test_run_identity_email_htpasswd_mismatch(svbin: GoBin, tls_cert_server: TLSCertSet, tls_client_ca: TLSCertSet, tls_cert_client_email: TLSCertSet, tls_cert_client_email_other: TLSCertSet, authenticator_config: str) -> None:
+    start_identity_server(svbin, tls_cert_server, tls_client_ca,
+                          EMAIL_IDENTITY, authenticator_config=authenticator_config)
+
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_email,
+                        user=TEST_USER, password=TEST_PASSWORD, expected_user=TEST_USER)
+    # The mapped identity has to match the authenticator username, even though the CN would
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_email_other,
+                        user=TEST_USER, password=TEST_PASSWORD, expected_user=None)
+    run_client_identity(svbin, tls_cert_server, tls_cert_client_email,
+                        user="", password="", expected_user=None)
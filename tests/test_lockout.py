@@ -0,0 +1,94 @@
+from requests import get
+from time import sleep
+from tests.bins import GoBin
+from tests.conftest import INVALID_TEXT, TEST_PASSWORD, TEST_USER
+from tests.packet_utils import basic_traffic_test
+
+
+MAX_FAILURES = 3
+
+# Requests that pass authentication end up at the (disabled) website
+STATUS_AUTHENTICATED = 404
+
+
+def start_lockout_server(svbin: GoBin, authenticator_config: str, max_failures: int = MAX_FAILURES) -> None:
+    svbin.cfg["server"]["authenticator"]["type"] = "htpasswd"
+    svbin.cfg["server"]["authenticator"]["config"] = authenticator_config
+    svbin.cfg["server"]["lockout"]["max-failures"] = max_failures
+    svbin.cfg["server"]["lockout"]["duration"] = "2s"
+    svbin.http_auth_enabled = True
+
+    svbin.start()
+    svbin.assert_ready_ok()
+    svbin.wait_listening()
+
+
+def login(svbin: GoBin, user: str, password: str):
+    auth = None
+    if user or password:
+        auth = (user, password)
+    return get(svbin.http_url("/"), auth=auth, timeout=5)
+
+
+def test_run_lockout(svbin: GoBin, clbin: GoBin, authenticator_config: str) -> None:
+    start_lockout_server(svbin, authenticator_config)
+
+    for _ in range(MAX_FAILURES):
+        assert login(svbin, TEST_USER, INVALID_TEXT).status_code == 401
+
+    # Locked out now, even with the right password
+    res = login(svbin, TEST_USER, TEST_PASSWORD)
+    assert res.status_code == 429
+    retry_after = int(res.headers["Retry-After"])
+    assert 0 < retry_after <= 2
+
+    sleep(retry_after + 0.5)
+
+    assert login(svbin, TEST_USER, TEST_PASSWORD).status_code == STATUS_AUTHENTICATED
+
+    clbin.connect_to(svbin, user=TEST_USER, password=TEST_PASSWORD)
+    clbin.start()
+    clbin.assert_ready_ok()
+    basic_traffic_test(svbin=svbin, clbin=clbin, minimal=True)
+
+
+def test_run_lockout_client(svbin: GoBin, clbin: GoBin, authenticator_config: str) -> None:
+    start_lockout_server(svbin, authenticator_config)
+
+    for _ in range(MAX_FAILURES):
+        assert login(svbin, INVALID_TEXT, INVALID_TEXT).status_code == 401
+
+    clbin.connect_to(svbin, user=TEST_USER, password=TEST_PASSWORD)
+    clbin.start()
+    clbin.assert_ready_ok(should=False)
+
+
+def test_run_lockout_no_credentials(svbin: GoBin, authenticator_config: str) -> None:
+    start_lockout_server(svbin, authenticator_config)
+
+    # Clients always ask without credentials first, that must not count as a failure
+    for _ in range(MAX_FAILURES * 3):
+        assert login(svbin, "", "").status_code == 401
+
+    assert login(svbin, TEST_USER, TEST_PASSWORD).status_code == STATUS_AUTHENTICATED
+
+
+def test_run_lockout_success_resets(svbin: GoBin, authenticator_config: str) -> None:
+    start_lockout_server(svbin, authenticator_config)
+
+    for _ in range(MAX_FAILURES - 1):
+        assert login(svbin, TEST_USER, INVALID_TEXT).status_code == 401
+    assert login(svbin, TEST_USER, TEST_PASSWORD).status_code == STATUS_AUTHENTICATED
+
+    for _ in range(MAX_FAILURES - 1):
+        assert login(svbin, TEST_USER, INVALID_TEXT).status_code == 401
+    assert login(svbin, TEST_USER, TEST_PASSWORD).status_code == STATUS_AUTHENTICATED
+
+
+def test_run_lockout_disabled(svbin: GoBin, authenticator_config: str) -> None:
+    start_lockout_server(svbin, authenticator_config, max_failures=0)
+
+    for _ in range(MAX_FAILURES * 3):
+        assert login(svbin, TEST_USER, INVALID_TEXT).status_code == 401
+
+    assert login(svbin, TEST_USER, TEST_PASSWORD).status_code == STATUS_AUTHENTICATED
@@ -0,0 +1,85 @@
+from requests import post
+
+from tests.bins import GoBin, new_clbin
+from tests.conftest import INVALID_TEXT, TEST_PASSWORD, TEST_USER
+from tests.packet_utils import basic_traffic_test
+
+
+def get_preauthorize_token(svbin: GoBin, user: str, password: str) -> str:
+    res = post(svbin.http_url("/preauthorize"),
+               auth=(user, password), timeout=5)
+    if res.status_code != 200:
+        return None
+    data = res.json()
+    assert data["success"]
+    return data["token"]
+
+
+def run_client_token(svbin: GoBin, token: str, should_be_ok: bool) -> None:
+    clbin = new_clbin()
+
+    try:
+        clbin.connect_to(svbin, protocol="ws", path=f"/preauthorize/{token}")
+
+        clbin.start()
+        clbin.assert_ready_ok(should=should_be_ok)
+
+        if should_be_ok:
+            basic_traffic_test(svbin=svbin, clbin=clbin, minimal=True)
+            assert svbin.get_auth_for(clbin=clbin) == TEST_USER
+
+    finally:
+        clbin.stop()
+
+
+def start_preauthorize_server(svbin: GoBin, authenticator_config: str) -> None:
+    svbin.cfg["server"]["authenticator"]["type"] = "htpasswd"
+    svbin.cfg["server"]["authenticator"]["config"] = authenticator_config
+    svbin.cfg["server"]["preauthorize-secret"] = "preauthorize-test-secret-0123456789abcdef"
+    svbin.http_auth_enabled = True
+
+    svbin.start()
+    svbin.assert_ready_ok()
+    svbin.wait_listening()
+
+
+def test_run_preauthorize_token(svbin: GoBin, authenticator_config: str) -> None:
+    start_preauthorize_server(svbin, authenticator_config)
+
+    token = get_preauthorize_token(svbin, TEST_USER, TEST_PASSWORD)
+    assert token
+    run_client_token(svbin, token, should_be_ok=True)
+
+
+def test_run_preauthorize_invalid_credentials(svbin: GoBin, authenticator_config: str) -> None:
+    start_preauthorize_server(svbin, authenticator_config)
+
+    assert get_preauthorize_token(svbin, TEST_USER, INVALID_TEXT) is None
+    assert get_preauthorize_token(svbin, INVALID_TEXT, TEST_PASSWORD) is None
+
+
+def test_run_preauthorize_invalid_token(svbin: GoBin, authenticator_config: str) -> None:
+    start_preauthorize_server(svbin, authenticator_config)
+
+    run_client_token(svbin, INVALID_TEXT, should_be_ok=False)
+
+    # Flip a character of the signature
+    token = get_preauthorize_token(svbin, TEST_USER, TEST_PASSWORD)
+    assert token
+    tampered = token[:-2] + ("A" if token[-2] != "A" else "B") + token[-1]
+    run_client_token(svbin, tampered, should_be_ok=False)
+
+
+def test_run_preauthorize_token_replay(svbin: GoBin, authenticator_config: str) -> None:
+    start_preauthorize_server(svbin, authenticator_config)
+
+    token = get_preauthorize_token(svbin, TEST_USER, TEST_PASSWORD)
+    assert token
+    run_client_token(svbin, token, should_be_ok=True)
+    # Every token (by its jti) can only be used once
+    run_client_token(svbin, token, should_be_ok=False)
+
+    # A new token works again
+    token = get_preauthorize_token(svbin, TEST_USER, TEST_PASSWORD)
+    assert token
+    run_client_token(svbin, token, should_be_ok=True)
@@ -0,0 +1,81 @@
+from requests import get
+from tests.bins import GoBin
+from tests.conftest import INVALID_TEXT, TEST_PASSWORD, TEST_USER
+
+
+FORWARDED_IP = "203.0.113.7"
+OTHER_FORWARDED_IP = "203.0.113.8"
+
+
+def run_forwarded_for(svbin: GoBin, clbin: GoBin, trusted_proxies: list[str], forwarded_for: str) -> str:
+    svbin.cfg["server"]["trusted-proxies"] = trusted_proxies
+    clbin.cfg["client"]["headers"]["X-Forwarded-For"] = [forwarded_for]
+    clbin.connect_to(svbin)
+
+    svbin.start()
+    svbin.assert_ready_ok()
+
+    clbin.start()
+    clbin.assert_ready_ok()
+
+    return svbin.get_remote_addr_for(clbin=clbin)
+
+
+def test_run_forwarded_for_trusted(svbin: GoBin, clbin: GoBin) -> None:
+    remote_addr = run_forwarded_for(
+        svbin, clbin, trusted_proxies=["127.0.0.1"], forwarded_for=FORWARDED_IP)
+    assert remote_addr == FORWARDED_IP
+
+
+def test_run_forwarded_for_trusted_chain(svbin: GoBin, clbin: GoBin) -> None:
+    # The client tries to spoof the first entry, only the one appended by our (trusted) proxy counts
+    remote_addr = run_forwarded_for(svbin, clbin, trusted_proxies=[
+                                    "127.0.0.0/8"], forwarded_for=f"{OTHER_FORWARDED_IP}, {FORWARDED_IP}")
+    assert remote_addr == FORWARDED_IP
+
+
+def test_run_forwarded_for_untrusted(svbin: GoBin, clbin: GoBin) -> None:
+    remote_addr = run_forwarded_for(
+        svbin, clbin, trusted_proxies=[], forwarded_for=FORWARDED_IP)
+    assert remote_addr.startswith("127.0.0.1:")
+
+
+def test_run_forwarded_for_untrusted_other_proxy(svbin: GoBin, clbin: GoBin) -> None:
+    remote_addr = run_forwarded_for(
+        svbin, clbin, trusted_proxies=["192.0.2.1"], forwarded_for=FORWARDED_IP)
+    assert remote_addr.startswith("127.0.0.1:")
+
+
+def run_forwarded_for_lockout(svbin: GoBin, authenticator_config: str, trusted_proxies: list[str]) -> int:
+    svbin.cfg["server"]["trusted-proxies"] = trusted_proxies
+    svbin.cfg["server"]["authenticator"]["type"] = "htpasswd"
+    svbin.cfg["server"]["authenticator"]["config"] = authenticator_config
+    svbin.cfg["server"]["lockout"]["max-failures"] = 1
+    svbin.http_auth_enabled = True
+
+    svbin.start()
+    svbin.assert_ready_ok()
+    svbin.wait_listening()
+
+    res = get(svbin.http_url("/"), auth=(INVALID_TEXT, INVALID_TEXT),
+              headers={"X-Forwarded-For": FORWARDED_IP}, timeout=5)
+    assert res.status_code == 401
+
+    res = get(svbin.http_url("/"), auth=(TEST_USER, TEST_PASSWORD),
+              headers={"X-Forwarded-For": FORWARDED_IP}, timeout=5)
+    assert res.status_code == 429
+
+    res = get(svbin.http_url("/"), auth=(TEST_USER, TEST_PASSWORD),
+              headers={"X-Forwarded-For": OTHER_FORWARDED_IP}, timeout=5)
+    return res.status_code
+
+
+def test_run_forwarded_for_lockout_trusted(svbin: GoBin, authenticator_config: str) -> None:
+    # Only the forwarded client IP is locked out, not the proxy
+    assert run_forwarded_for_lockout(
+        svbin, authenticator_config, trusted_proxies=["127.0.0.1"]) == 404
+
+
+def test_run_forwarded_for_lockout_untrusted(svbin: GoBin, authenticator_config: str) -> None:
+    assert run_forwarded_for_lockout(
+        svbin, authenticator_config, trusted_proxies=[]) == 429
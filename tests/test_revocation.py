@@ -0,0 +1,112 @@
+import pytest
+
+from os import close, remove
+from shutil import rmtree
+from tempfile import mkstemp
+from time import monotonic, sleep
+from typing import Callable, Generator
+from tests.bins import GoBin, new_clbin
+from tests.conftest import TEST_USER
+from tests.packet_utils import basic_traffic_test
+from tests.tls_utils import TLSCertSet, tls_cert_signed, tls_crl
+
+
+# The server checks the CRL file for changes every 10 seconds
+CRL_RELOAD_TIMEOUT = 25
+
+
+@pytest.fixture(scope="module")
+def tls_cert_client_valid(tls_client_ca: TLSCertSet) -> Generator:
+    res = tls_cert_signed(tls_client_ca, TEST_USER)
+    yield res
+    rmtree(res.dir)
+
+
+@pytest.fixture(scope="module")
+def tls_cert_client_revoked(tls_client_ca: TLSCertSet) -> Generator:
+    res = tls_cert_signed(tls_client_ca, TEST_USER)
+    yield res
+    rmtree(res.dir)
+
+
+@pytest.fixture(scope="function")
+def crl_file() -> Generator:
+    fd, crl = mkstemp(suffix=".pem")
+    close(fd)
+    yield crl
+    remove(crl)
+
+
+def wait_until(predicate: Callable[[], bool], timeout: float) -> bool:
+    deadline = monotonic() + timeout
+    while monotonic() < deadline:
+        if predicate():
+            return True
+        sleep(0.1)
+    return predicate()
+
+
+def start_crl_server(svbin: GoBin, tls_cert_server: TLSCertSet, tls_client_ca: TLSCertSet, crl_file: str) -> None:
+    svbin.enable_tls(tls_cert_server)
+    svbin.enable_mtls(tls_client_ca)
+    svbin.cfg["server"]["tls"]["crl"] = crl_file
+
+    svbin.start()
+    svbin.assert_ready_ok()
+
+
+def start_crl_client(svbin: GoBin, tls_cert_server: TLSCertSet, mtls: TLSCertSet, should_be_ok: bool) -> GoBin:
+    clbin = new_clbin()
+    clbin.connect_to(svbin, protocol="wss")
+    clbin.enable_tls(tls_cert_server)
+    clbin.enable_mtls(mtls)
+
+    clbin.start()
+    clbin.assert_ready_ok(should=should_be_ok)
+    return clbin
+
+
+def test_run_crl_revoked(svbin: GoBin, tls_cert_server: TLSCertSet, tls_client_ca: TLSCertSet, tls_cert_client_valid: TLSCertSet, tls_cert_client_revoked: TLSCertSet, crl_file: str) -> None:
+    tls_crl(tls_client_ca, [tls_cert_client_revoked], crl_file)
+    start_crl_server(svbin, tls_cert_server, tls_client_ca, crl_file)
+
+    clbin = start_crl_client(svbin, tls_cert_server,
+                             tls_cert_client_valid, should_be_ok=True)
+    try:
+        basic_traffic_test(svbin=svbin, clbin=clbin, minimal=True)
+        assert svbin.get_auth_for(clbin=clbin) == TEST_USER
+    finally:
+        clbin.stop()
+
+    clbin = start_crl_client(svbin, tls_cert_server,
+                             tls_cert_client_revoked, should_be_ok=False)
+    clbin.stop()
+
+
+def test_run_crl_change_disconnects(svbin: GoBin, tls_cert_server: TLSCertSet, tls_client_ca: TLSCertSet, tls_cert_client_valid: TLSCertSet, tls_cert_client_revoked: TLSCertSet, crl_file: str) -> None:
+    tls_crl(tls_client_ca, [], crl_file)
+    start_crl_server(svbin, tls_cert_server, tls_client_ca, crl_file)
+
+    clbin_valid = start_crl_client(
+        svbin, tls_cert_server, tls_cert_client_valid, should_be_ok=True)
+    clbin_revoked = start_crl_client(
+        svbin, tls_cert_server, tls_cert_client_revoked, should_be_ok=True)
+    try:
+        valid_ip = clbin_valid.get_ip()
+        revoked_ip = clbin_revoked.get_ip()
+        assert svbin.get_auth_for(clbin=clbin_revoked) == TEST_USER
+
+        tls_crl(tls_client_ca, [tls_cert_client_revoked], crl_file)
+
+        # Only the connection with the now revoked certificate is closed
+        assert wait_until(lambda: revoked_ip not in svbin.auth_names,
+                          timeout=CRL_RELOAD_TIMEOUT)
+        assert valid_ip in svbin.auth_names
+        basic_traffic_test(svbin=svbin, clbin=clbin_valid, minimal=True)
+    finally:
+        clbin_valid.stop()
+        clbin_revoked.stop()
+
+    clbin = start_crl_client(svbin, tls_cert_server,
+                             tls_cert_client_revoked, should_be_ok=False)
+    clbin.stop()
@@ -1,6 +1,8 @@
 from subprocess import check_call
 from tempfile import mkdtemp
+from os import replace
 from os.path import join
+from shutil import copyfile, rmtree
 from dataclasses import dataclass
 
 
@@ -24,3 +26,64 @@ def tls_cert_set(cn: str, conf: str) -> TLSCertSet:
     check_call(args, cwd=tmpdir)
 
     return TLSCertSet(cn=cn, ca=join(tmpdir, "cert.pem"), cert=join(tmpdir, "cert.pem"), key=join(tmpdir, "key.pem"), dir=tmpdir)
+
+
+LAST_SERIAL = 1000
+
+
+def tls_ca(cn: str) -> TLSCertSet:
+    tmpdir = mkdtemp()
+    check_call(["openssl", "req", "-x509", "-newkey", "rsa:2048", "-nodes", "-keyout", "key.pem", "-out", "cert.pem", "-sha256", "-days", "365", "-subj", f"/CN={cn}/",
+                "-addext", "basicConstraints=critical,CA:true", "-addext", "keyUsage=critical,keyCertSign,cRLSign"], cwd=tmpdir)
+
+    return TLSCertSet(cn=cn, ca=join(tmpdir, "cert.pem"), cert=join(tmpdir, "cert.pem"), key=join(tmpdir, "key.pem"), dir=tmpdir)
+
+
+# Issues a certificate signed by ca, san is an OpenSSL subjectAltName value (for example "email:user@example.com")
+def tls_cert_signed(ca: TLSCertSet, cn: str, san: str = "") -> TLSCertSet:
+    global LAST_SERIAL
+    LAST_SERIAL += 1
+
+    tmpdir = mkdtemp()
+    check_call(["openssl", "req", "-new", "-newkey", "rsa:2048", "-nodes", "-keyout",
+                "key.pem", "-out", "req.pem", "-subj", f"/CN={cn}/"], cwd=tmpdir)
+
+    args = ["openssl", "x509", "-req", "-in", "req.pem", "-CA", ca.cert, "-CAkey", ca.key, "-set_serial", str(LAST_SERIAL),
+            "-out", "cert.pem", "-sha256", "-days", "365"]
+    if san:
+        with open(join(tmpdir, "ext.cnf"), "w") as f:
+            f.write(f"subjectAltName = {san}\n")
+        args.append("-extfile")
+        args.append("ext.cnf")
+    check_call(args, cwd=tmpdir)
+
+    return TLSCertSet(cn=cn, ca=ca.cert, cert=join(tmpdir, "cert.pem"), key=join(tmpdir, "key.pem"), dir=tmpdir)
+
+
+# Writes a CRL signed by ca revoking the given certificates to out, replacing it atomically
+def tls_crl(ca: TLSCertSet, revoked: list[TLSCertSet], out: str) -> None:
+    tmpdir = mkdtemp()
+    try:
+        with open(join(tmpdir, "ca.cnf"), "w") as f:
+            f.write("[ca]\n")
+            f.write("default_ca = test_ca\n")
+            f.write("[test_ca]\n")
+            f.write("database = index.txt\n")
+            f.write("crlnumber = crlnumber\n")
+            f.write("default_md = sha256\n")
+            f.write("default_crl_days = 30\n")
+        with open(join(tmpdir, "index.txt"), "w") as f:
+            pass
+        with open(join(tmpdir, "crlnumber"), "w") as f:
+            f.write("01\n")
+
+        ca_args = ["openssl", "ca", "-config", "ca.cnf",
+                   "-keyfile", ca.key, "-cert", ca.cert]
+        for cert in revoked:
+            check_call(ca_args + ["-revoke", cert.cert], cwd=tmpdir)
+        check_call(ca_args + ["-gencrl", "-out", "crl.pem"], cwd=tmpdir)
+
+        copyfile(join(tmpdir, "crl.pem"), f"{out}.tmp")
+        replace(f"{out}.tmp", out)
+    finally:
+        rmtree(tmpdir)
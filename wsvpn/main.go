@@ -6,16 +6,21 @@ import (
 	"fmt"
 
 	client_cli "github.com/Doridian/wsvpn/client/cli"
+	ctl_cli "github.com/Doridian/wsvpn/ctl/cli"
 	server_cli "github.com/Doridian/wsvpn/server/cli"
 	shared_cli "github.com/Doridian/wsvpn/shared/cli"
 )
 
 func main() {
-	modePtr := flag.String("mode", "", "client or server")
-	configPtr, printDefaultConfigPtr := shared_cli.LoadFlags("MODE.yml", "Config file name (\"MODE.yml\" means use either server.yml or client.yml)")
+	modePtr := flag.String("mode", "", "client, server or ctl")
+	configPtr, printDefaultConfigPtr := shared_cli.LoadFlags("MODE.yml", "Config file name (\"MODE.yml\" means use either server.yml or client.yml, ctl uses server.yml)")
 
 	if *configPtr == "MODE.yml" {
-		configName := fmt.Sprintf("%s.yml", *modePtr)
+		configMode := *modePtr
+		if configMode == "ctl" {
+			configMode = "server"
+		}
+		configName := fmt.Sprintf("%s.yml", configMode)
 		configPtr = &configName
 	}
 
@@ -24,7 +29,9 @@ func main() {
 		client_cli.Main(configPtr, printDefaultConfigPtr)
 	case "server":
 		server_cli.Main(configPtr, printDefaultConfigPtr)
+	case "ctl":
+		ctl_cli.Main(configPtr, flag.Args())
 	default:
-		panic(errors.New("please choose a valid mode: client or server"))
+		panic(errors.New("please choose a valid mode: client, server or ctl"))
 	}
 }